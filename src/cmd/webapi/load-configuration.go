@@ -28,6 +28,52 @@ type WebAPIConfiguration struct {
 	DB    struct {
 		Filename string `conf:"default:/tmp/dajetrains.json"`
 	}
+	Payments struct {
+		Timeout   time.Duration `conf:"default:5s"`
+		FakeMode  string        `conf:"default:approve"`
+		FakeDelay time.Duration `conf:"default:0s"`
+	}
+	Auth struct {
+		TokenSecret   string        `conf:"noprint"`
+		TokenTTL      time.Duration `conf:"default:24h"`
+		AdminPassword string        `conf:"noprint"`
+	}
+	Tickets struct {
+		SigningKey          string        `conf:"noprint"`
+		FineAmount          int64         `conf:"default:5000"`
+		StationCodeValidity time.Duration `conf:"default:8760h"`
+	}
+	Positioning struct {
+		Strategy        string        `conf:"default:consistent"`
+		MinRSSI         int           `conf:"default:-90"`
+		MaxSightingAge  time.Duration `conf:"default:30s"`
+		ConsistentScans int           `conf:"default:3"`
+		ScanWindow      time.Duration `conf:"default:1m"`
+		Dwell           time.Duration `conf:"default:10s"`
+		Grace           time.Duration `conf:"default:1m"`
+	}
+	Beacons struct {
+		RotationPeriod time.Duration `conf:"default:15m"`
+		ClockSkew      time.Duration `conf:"default:2m"`
+	}
+	Fraud struct {
+		MaxSpeed          float64       `conf:"default:350"`
+		MinDistance       float64       `conf:"default:5"`
+		MinElapsed        time.Duration `conf:"default:1m"`
+		TicketChurnLimit  int           `conf:"default:4"`
+		TicketChurnWindow time.Duration `conf:"default:1h"`
+	}
+	Sessions struct {
+		IdleTimeout    time.Duration `conf:"default:3h"`
+		Policy         string        `conf:"default:last_station"`
+		ReaperInterval time.Duration `conf:"default:1m"`
+	}
+	Receipts struct {
+		VATRate           float64 `conf:"default:0.10"`
+		OperatorName      string  `conf:"default:DajeTrains S.p.A."`
+		OperatorAddress   string  `conf:"default:Piazza dei Cinquecento 1, 00185 Roma RM"`
+		OperatorVATNumber string  `conf:"default:IT00000000000"`
+	}
 }
 
 // loadConfiguration creates a WebAPIConfiguration starting from flags, environment variables and configuration file.
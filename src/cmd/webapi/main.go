@@ -25,6 +25,9 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -36,6 +39,7 @@ import (
 	"github.com/ami-sc/DajeTrains/service/api"
 	"github.com/ami-sc/DajeTrains/service/database"
 	"github.com/ami-sc/DajeTrains/service/globaltime"
+	"github.com/ami-sc/DajeTrains/service/payments"
 	"github.com/ardanlabs/conf"
 	"github.com/sirupsen/logrus"
 )
@@ -52,7 +56,7 @@ func main() {
 // run executes the program. The body of this function should perform the following steps:
 // * reads the configuration
 // * creates and configure the logger
-// * connects to any external resources (like databases, authenticators, etc.)
+// * connects to any external resources (like databases, payment providers, authenticators, etc.)
 // * creates an instance of the service/api package
 // * starts the principal web server (using the service/api.Router.Handler() for HTTP handlers)
 // * waits for any termination event: SIGTERM signal (UNIX), non-recoverable server error, etc.
@@ -79,13 +83,93 @@ func run() error {
 
 	logger.Infof("application initializing")
 
+	// Start the payment provider
+	logger.Println("initializing payment provider")
+	provider, err := payments.NewFake(payments.FakeConfig{
+		Mode:  cfg.Payments.FakeMode,
+		Delay: cfg.Payments.FakeDelay,
+	})
+	if err != nil {
+		logger.WithError(err).Error("error creating the payment provider")
+		return fmt.Errorf("creating the payment provider: %w", err)
+	}
+
+	// Load the key used to sign the tickets (a base64 encoded Ed25519 seed)
+	var ticketKeySeed []byte
+	if cfg.Tickets.SigningKey != "" {
+		ticketKeySeed, err = base64.StdEncoding.DecodeString(cfg.Tickets.SigningKey)
+		if err != nil || len(ticketKeySeed) != ed25519.SeedSize {
+			return errors.New("the ticket signing key must be a base64 encoded 32 bytes seed")
+		}
+	} else {
+		logger.Warning("no ticket signing key configured, generating a random one: tickets will be invalid after a restart, and station codes can't be generated")
+		ticketKeySeed = make([]byte, ed25519.SeedSize)
+		if _, err := crand.Read(ticketKeySeed); err != nil {
+			return fmt.Errorf("generating the ticket signing key: %w", err)
+		}
+	}
+
+	if cfg.Positioning.Strategy != database.PositioningStrongest && cfg.Positioning.Strategy != database.PositioningConsistent {
+		return errors.New("the positioning strategy must be strongest or consistent")
+	}
+
+	switch cfg.Sessions.Policy {
+	case database.SessionPolicyLastStation, database.SessionPolicyMaxFare, database.SessionPolicyIncomplete:
+	default:
+		return errors.New("the session policy must be last_station, max_fare or incomplete")
+	}
+
+	dbcfg := database.Config{
+		PaymentProvider:           provider,
+		PaymentTimeout:            cfg.Payments.Timeout,
+		VATRate:                   cfg.Receipts.VATRate,
+		TicketSigningKey:          ed25519.NewKeyFromSeed(ticketKeySeed),
+		TicketSigningKeyGenerated: cfg.Tickets.SigningKey == "",
+		FineAmount:                cfg.Tickets.FineAmount,
+		Positioning: database.PositioningRules{
+			Strategy:        cfg.Positioning.Strategy,
+			MinRSSI:         cfg.Positioning.MinRSSI,
+			MaxSightingAge:  cfg.Positioning.MaxSightingAge,
+			ConsistentScans: cfg.Positioning.ConsistentScans,
+			ScanWindow:      cfg.Positioning.ScanWindow,
+			Dwell:           cfg.Positioning.Dwell,
+			Grace:           cfg.Positioning.Grace,
+		},
+		BeaconRotationPeriod: cfg.Beacons.RotationPeriod,
+		BeaconClockSkew:      cfg.Beacons.ClockSkew,
+		Fraud: database.FraudRules{
+			MaxSpeed:          cfg.Fraud.MaxSpeed,
+			MinDistance:       cfg.Fraud.MinDistance,
+			MinElapsed:        cfg.Fraud.MinElapsed,
+			TicketChurnLimit:  cfg.Fraud.TicketChurnLimit,
+			TicketChurnWindow: cfg.Fraud.TicketChurnWindow,
+		},
+		Sessions: database.SessionRules{
+			IdleTimeout: cfg.Sessions.IdleTimeout,
+			Policy:      cfg.Sessions.Policy,
+		},
+		StationCodeValidity: cfg.Tickets.StationCodeValidity,
+	}
+
 	// Start Database
 	logger.Println("initializing database support")
-	db, err := database.Load(cfg.DB.Filename)
+	db, err := database.Load(cfg.DB.Filename, dbcfg)
 
-	if err != nil {
+	if errors.Is(err, os.ErrNotExist) {
 		logger.WithError(err).Error("error opening the json file, creating a new one with default values...")
-		db = database.NewDatabase(cfg.DB.Filename)
+		db = database.NewDatabase(cfg.DB.Filename, dbcfg)
+	} else if err != nil {
+		// the file is not replaced, so that its data is not lost
+		logger.WithError(err).Error("error loading the database")
+		return fmt.Errorf("loading the database: %w", err)
+	}
+
+	// Create the first admin, who can then assign the roles to the other users
+	if cfg.Auth.AdminPassword != "" {
+		if err := createAdmin(db, cfg.Auth.AdminPassword); err != nil {
+			logger.WithError(err).Error("error creating the admin user")
+			return fmt.Errorf("creating the admin user: %w", err)
+		}
 	}
 
 	// Start (main) API server
@@ -100,10 +184,28 @@ func run() error {
 	// buffered channel so the goroutine can exit if we don't collect this error.
 	serverErrors := make(chan error, 1)
 
+	// Load the key used to sign the bearer tokens
+	tokenSecret := []byte(cfg.Auth.TokenSecret)
+	if len(tokenSecret) == 0 {
+		logger.Warning("no token secret configured, generating a random one: tokens will be invalid after a restart")
+		tokenSecret = make([]byte, 32)
+		if _, err := crand.Read(tokenSecret); err != nil {
+			return fmt.Errorf("generating the token secret: %w", err)
+		}
+	}
+
 	// Create the API router
 	apirouter, err := api.New(api.Config{
 		Logger:   logger,
 		Database: db,
+		Operator: api.OperatorDetails{
+			Name:      cfg.Receipts.OperatorName,
+			Address:   cfg.Receipts.OperatorAddress,
+			VATNumber: cfg.Receipts.OperatorVATNumber,
+		},
+		TokenSecret:           tokenSecret,
+		TokenTTL:              cfg.Auth.TokenTTL,
+		SessionReaperInterval: cfg.Sessions.ReaperInterval,
 	})
 	if err != nil {
 		logger.WithError(err).Error("error creating the API server instance")
@@ -173,3 +275,29 @@ func run() error {
 
 	return nil
 }
+
+// createAdmin registers the "admin" user with the admin role, if it does not exist yet. An existing "admin" user
+// without the admin role (e.g., a passenger who registered first) is an error, since it can't be trusted.
+func createAdmin(db database.AppDatabase, password string) error {
+	hash, salt, err := database.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.CreateUser("admin", hash, salt)
+	if errors.Is(err, database.ErrUserExists) {
+		user, err := db.GetUser("admin")
+		if err != nil {
+			return err
+		}
+		if user.Role != database.RoleAdmin {
+			return fmt.Errorf("the existing %q user has the %s role, not the admin one", user.Username, user.Role)
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	_, err = db.SetUserRole("admin", database.RoleAdmin, nil)
+	return err
+}
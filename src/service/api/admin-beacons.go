@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ami-sc/DajeTrains/service/api/reqcontext"
+	"github.com/ami-sc/DajeTrains/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+// write the outcome of a change to the beacon registry
+func (rt *_router) writeBeaconResponse(w http.ResponseWriter, result interface{}, err error, status int, ctx reqcontext.RequestContext) {
+	if errors.Is(err, database.ErrBeaconNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if errors.Is(err, database.ErrBeaconExists) ||
+		errors.Is(err, database.ErrBeaconRetired) ||
+		errors.Is(err, database.ErrBeaconNotRotating) {
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if errors.Is(err, database.ErrInvalidBeaconType) ||
+		errors.Is(err, database.ErrBeaconOwnerNotFound) ||
+		errors.Is(err, database.ErrInvalidBeaconPlacement) ||
+		errors.Is(err, database.ErrInvalidBeaconStatus) ||
+		errors.Is(err, database.ErrInvalidInstallDate) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't change the beacon registry")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// register a new beacon
+func (rt *_router) createBeacon(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	var request BeaconRequest
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid request body"})
+		return
+	}
+
+	beacon, err := rt.db.CreateBeacon(request.ID, request.BeaconOwner, request.InstalledAt)
+
+	rt.writeBeaconResponse(w, beacon, err, http.StatusCreated, ctx)
+}
+
+// change the status or the install date of a beacon
+func (rt *_router) updateBeacon(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	var request BeaconUpdateRequest
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid request body"})
+		return
+	}
+
+	beacon, err := rt.db.UpdateBeacon(ps.ByName("beacon_id"), request.Status, request.InstalledAt)
+
+	rt.writeBeaconResponse(w, beacon, err, http.StatusOK, ctx)
+}
+
+// move a beacon to another station, platform or train car
+func (rt *_router) reassignBeacon(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	var owner database.BeaconOwner
+
+	err := json.NewDecoder(r.Body).Decode(&owner)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid request body"})
+		return
+	}
+
+	beacon, err := rt.db.ReassignBeacon(ps.ByName("beacon_id"), owner)
+
+	rt.writeBeaconResponse(w, beacon, err, http.StatusOK, ctx)
+}
+
+// retire a beacon
+func (rt *_router) retireBeacon(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	beacon, err := rt.db.RetireBeacon(ps.ByName("beacon_id"))
+
+	rt.writeBeaconResponse(w, beacon, err, http.StatusOK, ctx)
+}
+
+// give a beacon a new secret key, so that it broadcasts rotating IDs
+func (rt *_router) enableBeaconRotation(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	key, err := rt.db.EnableBeaconRotation(ps.ByName("beacon_id"))
+
+	rt.writeBeaconResponse(w, key, err, http.StatusCreated, ctx)
+}
+
+// make a beacon broadcast its static ID again
+func (rt *_router) disableBeaconRotation(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	beacon, err := rt.db.DisableBeaconRotation(ps.ByName("beacon_id"))
+
+	rt.writeBeaconResponse(w, beacon, err, http.StatusOK, ctx)
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ami-sc/DajeTrains/service/api/reqcontext"
+	"github.com/ami-sc/DajeTrains/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+// get the review queue of the suspicious events (by default, the open ones)
+func (rt *_router) getSuspiciousEvents(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = database.SuspiciousOpen
+	} else if status == "all" {
+		status = ""
+	}
+
+	_ = json.NewEncoder(w).Encode(rt.db.GetSuspiciousEvents(status))
+}
+
+// confirm or dismiss a suspicious event
+func (rt *_router) reviewSuspiciousEvent(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	var request ReviewRequest
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid request body"})
+		return
+	}
+
+	event, err := rt.db.ReviewSuspiciousEvent(ps.ByName("event_id"), ctx.UserID, request.Status, request.Note)
+
+	if errors.Is(err, database.ErrInvalidReviewStatus) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if errors.Is(err, database.ErrSuspiciousEventNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if errors.Is(err, database.ErrAlreadyReviewed) {
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't review the suspicious event")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(event)
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ami-sc/DajeTrains/service/api/reqcontext"
+	"github.com/ami-sc/DajeTrains/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Format an amount in euro cents as a decimal string (e.g., 550 -> "5.50")
+func formatCents(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+}
+
+// Parse an optional date (YYYY-MM-DD) from the query string
+func parseDateParam(r *http.Request, name string) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+// get the trial balance of the ledger
+func (rt *_router) getTrialBalance(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(rt.db.GetTrialBalance())
+}
+
+// export the ledger entries as CSV, one row per journal line
+func (rt *_router) exportLedgerEntries(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+
+	from, err := parseDateParam(r, "from")
+	if err != nil {
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid from date"})
+		return
+	}
+
+	to, err := parseDateParam(r, "to")
+	if err != nil {
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid to date"})
+		return
+	}
+
+	// the "to" date is inclusive
+	if !to.IsZero() {
+		to = to.AddDate(0, 0, 1)
+	}
+
+	entries := rt.db.GetJournal(from, to)
+
+	w.Header().Set("content-type", "text/csv")
+	w.Header().Set("content-disposition", `attachment; filename="ledger.csv"`)
+
+	writer := csv.NewWriter(w)
+	_ = writer.Write([]string{"entry_id", "time", "type", "reference", "description", "account", "debit", "credit"})
+	for _, entry := range entries {
+		for _, line := range entry.Lines {
+			_ = writer.Write([]string{
+				entry.ID,
+				entry.Time,
+				entry.Type,
+				entry.Reference,
+				entry.Description,
+				line.Account,
+				formatCents(line.Debit),
+				formatCents(line.Credit),
+			})
+		}
+	}
+	writer.Flush()
+
+	if err := writer.Error(); err != nil {
+		ctx.Logger.WithError(err).Error("can't write the ledger CSV")
+	}
+}
+
+// write off an unpaid payment
+func (rt *_router) writeOffPayment(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	user_id := ps.ByName("user_id")
+	payment_id := ps.ByName("payment_id")
+
+	payment, err := rt.db.WriteOffPayment(user_id, payment_id)
+
+	if errors.Is(err, database.ErrPaymentNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if errors.Is(err, database.ErrPaymentNotWritable) {
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't write off the payment")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(payment)
+}
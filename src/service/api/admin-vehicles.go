@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ami-sc/DajeTrains/service/api/reqcontext"
+	"github.com/ami-sc/DajeTrains/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+// get the vehicles
+func (rt *_router) getVehicles(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(rt.db.GetVehicles())
+}
+
+// register a new vehicle
+func (rt *_router) createVehicle(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	var vehicle database.Vehicle
+
+	err := json.NewDecoder(r.Body).Decode(&vehicle)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid request body"})
+		return
+	}
+
+	created, err := rt.db.CreateVehicle(vehicle)
+
+	if errors.Is(err, database.ErrInvalidVehicle) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if errors.Is(err, database.ErrVehicleExists) {
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't register the vehicle")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(created)
+}
+
+// get the vehicle assignments of a day (by default, today)
+func (rt *_router) getVehicleAssignments(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	date := r.URL.Query().Get("date")
+	if date == "" {
+		date = time.Now().Format("2006-01-02")
+	}
+
+	assignments, err := rt.db.GetVehicleAssignments(date)
+
+	if errors.Is(err, database.ErrInvalidRunDate) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't get the vehicle assignments")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(assignments)
+}
+
+// assign the vehicles running a train on a day
+func (rt *_router) assignVehicles(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	var request AssignmentRequest
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid request body"})
+		return
+	}
+
+	assignment, err := rt.db.AssignVehicles(ps.ByName("date"), ps.ByName("train_id"), request.Vehicles)
+
+	if errors.Is(err, database.ErrInvalidRunDate) || errors.Is(err, database.ErrVehicleNotFound) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if errors.Is(err, database.ErrTrainNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if errors.Is(err, database.ErrVehicleAlreadyAssigned) || errors.Is(err, database.ErrInvalidSchedule) {
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't assign the vehicles")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(assignment)
+}
@@ -1,9 +1,12 @@
 package api
 
 import (
+	"encoding/json"
 	"net/http"
+	"strings"
 
 	"github.com/ami-sc/DajeTrains/service/api/reqcontext"
+	"github.com/ami-sc/DajeTrains/service/database"
 	"github.com/gofrs/uuid"
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
@@ -13,26 +16,199 @@ import (
 // required by the httprouter package.
 type httpRouterHandler func(http.ResponseWriter, *http.Request, httprouter.Params, reqcontext.RequestContext)
 
-// wrap parses the request and adds a reqcontext.RequestContext instance related to the request.
-func (rt *_router) wrap(fn httpRouterHandler) func(http.ResponseWriter, *http.Request, httprouter.Params) {
+// authorizer is the signature for functions that check whether a request can be served. If not, they write the error
+// response and return false.
+type authorizer func(http.ResponseWriter, *http.Request, httprouter.Params, reqcontext.RequestContext) bool
+
+// wrap parses the request and adds a reqcontext.RequestContext instance related to the request. The authorizers are
+// called in order before the handler. Requests are served one at a time, since the database is not safe for
+// concurrent use.
+func (rt *_router) wrap(fn httpRouterHandler, authorizers ...authorizer) func(http.ResponseWriter, *http.Request, httprouter.Params) {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		reqUUID, err := uuid.NewV4()
-		if err != nil {
-			rt.baseLogger.WithError(err).Error("can't generate a request UUID")
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		rt.dbLock.Lock()
+		defer rt.dbLock.Unlock()
+
+		if ctx, ok := rt.newRequestContext(w, r, ps, authorizers, false); ok {
+			// Call the next handler in chain (usually, the handler function for the path)
+			fn(w, r, ps, ctx)
 		}
-		var ctx = reqcontext.RequestContext{
-			ReqUUID: reqUUID,
+	}
+}
+
+// wrapUnlocked is like wrap, for the handlers with slow steps that don't use the database (e.g., hashing passwords or
+// calling the payment provider). The database is locked only while the request is authorized: the handler must lock
+// it by itself around its calls, so that the other requests are not held up by the slow steps.
+func (rt *_router) wrapUnlocked(fn httpRouterHandler, authorizers ...authorizer) func(http.ResponseWriter, *http.Request, httprouter.Params) {
+	return rt.wrapWithoutLock(fn, authorizers, false)
+}
+
+// wrapStream is like wrapUnlocked, for the handlers that keep the connection open (e.g., the event streams). Since
+// browsers can't set headers on event streams and WebSockets, the bearer token can be passed in the access_token query
+// parameter too.
+func (rt *_router) wrapStream(fn httpRouterHandler, authorizers ...authorizer) func(http.ResponseWriter, *http.Request, httprouter.Params) {
+	return rt.wrapWithoutLock(fn, authorizers, true)
+}
+
+// wrapWithoutLock builds the handlers of wrapUnlocked and wrapStream
+func (rt *_router) wrapWithoutLock(fn httpRouterHandler, authorizers []authorizer, queryToken bool) func(http.ResponseWriter, *http.Request, httprouter.Params) {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		rt.dbLock.Lock()
+		ctx, ok := rt.newRequestContext(w, r, ps, authorizers, queryToken)
+		rt.dbLock.Unlock()
+
+		if ok {
+			fn(w, r, ps, ctx)
 		}
+	}
+}
 
-		// Create a request-specific logger
-		ctx.Logger = rt.baseLogger.WithFields(logrus.Fields{
-			"reqid":     ctx.ReqUUID.String(),
-			"remote-ip": r.RemoteAddr,
+// newRequestContext creates the context of a request, authenticating the user and calling the authorizers. If the
+// request can't be served, the error response has been written and false is returned. With queryToken, the bearer
+// token is also read from the access_token query parameter.
+func (rt *_router) newRequestContext(w http.ResponseWriter, r *http.Request, ps httprouter.Params, authorizers []authorizer, queryToken bool) (reqcontext.RequestContext, bool) {
+	reqUUID, err := uuid.NewV4()
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("can't generate a request UUID")
+		w.WriteHeader(http.StatusInternalServerError)
+		return reqcontext.RequestContext{}, false
+	}
+	var ctx = reqcontext.RequestContext{
+		ReqUUID: reqUUID,
+	}
+
+	// Create a request-specific logger
+	ctx.Logger = rt.baseLogger.WithFields(logrus.Fields{
+		"reqid":     ctx.ReqUUID.String(),
+		"remote-ip": r.RemoteAddr,
+	})
+
+	header := r.Header.Get("Authorization")
+	if token := r.URL.Query().Get("access_token"); header == "" && queryToken && token != "" {
+		header = "Bearer " + token
+	}
+
+	// Authenticate the user, if a bearer token is provided
+	if header != "" {
+		token := strings.TrimPrefix(header, "Bearer ")
+
+		claims, err := rt.parseToken(token)
+		if token == header || err != nil {
+			ctx.Logger.WithError(err).Debug("invalid bearer token")
+			w.Header().Set("content-type", "application/json")
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid token"})
+			return ctx, false
+		}
+
+		// the role is read at every request, so that changes are applied immediately
+		user, err := rt.db.GetUser(claims.Subject)
+		if err != nil {
+			ctx.Logger.WithError(err).Debug("bearer token of an unknown user")
+			w.Header().Set("content-type", "application/json")
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid token"})
+			return ctx, false
+		}
+
+		ctx.UserID = user.Username
+		ctx.Role = user.Role
+		ctx.Logger = ctx.Logger.WithFields(logrus.Fields{
+			"user": ctx.UserID,
+			"role": ctx.Role,
 		})
+	}
+
+	for _, authorize := range authorizers {
+		if !authorize(w, r, ps, ctx) {
+			return ctx, false
+		}
+	}
+
+	return ctx, true
+}
+
+// requireUser allows the request only if a user is authenticated
+func requireUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) bool {
+	if ctx.UserID == "" {
+		w.Header().Set("content-type", "application/json")
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Authentication required"})
+		return false
+	}
+	return true
+}
+
+// requireSelf allows the request only if the authenticated user is the one in the given path parameter
+func requireSelf(param string) authorizer {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) bool {
+		if !requireUser(w, r, ps, ctx) {
+			return false
+		}
+
+		if ctx.UserID != ps.ByName(param) {
+			w.Header().Set("content-type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Forbidden"})
+			return false
+		}
+
+		return true
+	}
+}
+
+// requireRole allows the request only if the authenticated user has one of the given roles
+func requireRole(roles ...string) authorizer {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) bool {
+		if !requireUser(w, r, ps, ctx) {
+			return false
+		}
+
+		for _, role := range roles {
+			if ctx.Role == role {
+				return true
+			}
+		}
+
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Forbidden"})
+		return false
+	}
+}
+
+// requireTrainOperator allows the request only if the authenticated user is an operator working on the line of the
+// train in the given path parameter (or an admin)
+func (rt *_router) requireTrainOperator(param string) authorizer {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) bool {
+		if ctx.Role == database.RoleAdmin || rt.db.IsOperatorOfTrain(ctx.UserID, ps.ByName(param)) {
+			return true
+		}
+
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Forbidden: the train is not on one of your lines"})
+		return false
+	}
+}
+
+// requireTicketHolder allows the request only if the authenticated user holds the ticket in the given path parameter
+// (or is an inspector or an admin)
+func (rt *_router) requireTicketHolder(param string) authorizer {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) bool {
+		if !requireUser(w, r, ps, ctx) {
+			return false
+		}
+
+		if ctx.Role == database.RoleInspector || ctx.Role == database.RoleAdmin || rt.db.IsTicketHolder(ctx.UserID, ps.ByName(param)) {
+			return true
+		}
 
-		// Call the next handler in chain (usually, the handler function for the path)
-		fn(w, r, ps, ctx)
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Forbidden"})
+		return false
 	}
 }
@@ -2,9 +2,12 @@ package api
 
 import (
 	"net/http"
+
+	"github.com/ami-sc/DajeTrains/service/database"
 )
 
-// Handler returns an instance of httprouter.Router that handle APIs registered here
+// Handler returns an instance of httprouter.Router that handle APIs registered here. Each route declares who can call
+// it: routes without authorizers are public.
 func (rt *_router) Handler() http.Handler {
 	// Register routes
 	rt.router.GET("/", rt.getHelloWorld)
@@ -13,19 +16,66 @@ func (rt *_router) Handler() http.Handler {
 	rt.router.GET("/stations/:name/departures", rt.wrap(rt.getStationDepartures))
 	rt.router.GET("/stations/:name/arrivals", rt.wrap(rt.getStationArrivals))
 
-	rt.router.PUT("/positions/:user_id", rt.wrap(rt.updateUserPosition))
-	rt.router.GET("/positions/:user_id", rt.wrap(rt.getUserPosition))
+	rt.router.POST("/users", rt.wrapUnlocked(rt.registerUser))
+	rt.router.POST("/session", rt.wrapUnlocked(rt.doLogin))
+
+	rt.router.PUT("/positions/:user_id", rt.wrap(rt.updateUserPosition, requireSelf("user_id")))
+	rt.router.GET("/positions/:user_id", rt.wrap(rt.getUserPosition, requireSelf("user_id")))
+	rt.router.POST("/positions/:user_id/events", rt.wrap(rt.uploadPositionEvents, requireSelf("user_id")))
+	rt.router.POST("/positions/:user_id/check_in", rt.wrap(rt.checkIn, requireSelf("user_id")))
+	rt.router.POST("/positions/:user_id/check_out", rt.wrap(rt.checkOut, requireSelf("user_id")))
+
+	rt.router.GET("/trips/:user_id", rt.wrap(rt.getTrips, requireSelf("user_id")))
+	rt.router.GET("/trips/:user_id/live", rt.wrapStream(rt.getLiveTrip, requireSelf("user_id")))
+
+	rt.router.GET("/payment_history/:user_id", rt.wrap(rt.getPaymentHistory, requireSelf("user_id")))
+	rt.router.PUT("/payment_history/:user_id/:payment_id/refund", rt.wrap(rt.refundPayment, requireRole(database.RoleAdmin)))
+	rt.router.PUT("/payment_history/:user_id/:payment_id/write_off", rt.wrap(rt.writeOffPayment, requireRole(database.RoleAdmin)))
 
-	rt.router.GET("/payment_history/:user_id", rt.wrap(rt.getPaymentHistory))
+	rt.router.GET("/receipts/:receipt_id", rt.wrap(rt.getReceipt, requireUser))
+
+	rt.router.GET("/wallets/:user_id", rt.wrap(rt.getWallet, requireSelf("user_id")))
+	rt.router.PUT("/wallets/:user_id/top_up", rt.wrapUnlocked(rt.topUpWallet, requireSelf("user_id")))
+	rt.router.PUT("/wallets/:user_id/top_ups/:top_up_id/refund", rt.wrap(rt.refundTopUp, requireRole(database.RoleAdmin)))
 
 	rt.router.GET("/trains/:name", rt.wrap(rt.getTrains))
+	rt.router.GET("/trains/:name/tickets", rt.wrap(rt.getTicketManifest, requireRole(database.RoleInspector, database.RoleAdmin)))
+
+	rt.router.PUT("/trains/:train_id", rt.wrap(rt.updateTrainPosition, requireRole(database.RoleOperator, database.RoleAdmin), rt.requireTrainOperator("train_id")))
+	rt.router.DELETE("/trains/:train_id", rt.wrap(rt.resetTrainPosition, requireRole(database.RoleAdmin)))
 
-	rt.router.PUT("/trains/:train_id", rt.wrap(rt.updateTrainPosition))
-	rt.router.DELETE("/trains/:train_id", rt.wrap(rt.resetTrainPosition))
+	rt.router.GET("/events", rt.wrapStream(rt.getEvents))
 
-	rt.router.GET("/tickets/:ticket_code", rt.wrap(rt.validateTicket))
+	rt.router.GET("/tickets/:ticket_code", rt.wrap(rt.validateTicket, requireRole(database.RoleInspector, database.RoleAdmin)))
+	rt.router.GET("/tickets/:ticket_code/qr.png", rt.wrap(rt.getTicketQRCodePNG, rt.requireTicketHolder("ticket_code")))
+	rt.router.GET("/tickets/:ticket_code/qr.svg", rt.wrap(rt.getTicketQRCodeSVG, rt.requireTicketHolder("ticket_code")))
+	rt.router.DELETE("/tickets/:ticket_code", rt.wrap(rt.revokeTicket, requireRole(database.RoleAdmin)))
+	rt.router.GET("/revoked_tickets", rt.wrap(rt.getRevokedTickets, requireRole(database.RoleInspector, database.RoleAdmin)))
+	rt.router.GET("/ticket_keys", rt.wrap(rt.getTicketPublicKey))
+
+	rt.router.POST("/inspections", rt.wrap(rt.inspectTicket, requireRole(database.RoleInspector, database.RoleAdmin)))
+	rt.router.POST("/inspections/:inspection_id/fine", rt.wrap(rt.issueFine, requireRole(database.RoleInspector, database.RoleAdmin)))
 
 	rt.router.GET("/beacons", rt.wrap(rt.getBeacons))
 
+	rt.router.GET("/admin/ledger/trial_balance", rt.wrap(rt.getTrialBalance, requireRole(database.RoleAdmin)))
+	rt.router.GET("/admin/ledger/entries", rt.wrap(rt.exportLedgerEntries, requireRole(database.RoleAdmin)))
+	rt.router.GET("/admin/inspections/stats", rt.wrap(rt.getInspectionStats, requireRole(database.RoleAdmin)))
+	rt.router.PUT("/admin/users/:username/role", rt.wrap(rt.setUserRole, requireRole(database.RoleAdmin)))
+	rt.router.POST("/admin/beacons", rt.wrap(rt.createBeacon, requireRole(database.RoleAdmin)))
+	rt.router.PUT("/admin/beacons/:beacon_id", rt.wrap(rt.updateBeacon, requireRole(database.RoleAdmin)))
+	rt.router.PUT("/admin/beacons/:beacon_id/owner", rt.wrap(rt.reassignBeacon, requireRole(database.RoleAdmin)))
+	rt.router.DELETE("/admin/beacons/:beacon_id", rt.wrap(rt.retireBeacon, requireRole(database.RoleAdmin)))
+	rt.router.POST("/admin/beacons/:beacon_id/key", rt.wrap(rt.enableBeaconRotation, requireRole(database.RoleAdmin)))
+	rt.router.DELETE("/admin/beacons/:beacon_id/key", rt.wrap(rt.disableBeaconRotation, requireRole(database.RoleAdmin)))
+	rt.router.GET("/admin/vehicles", rt.wrap(rt.getVehicles, requireRole(database.RoleAdmin)))
+	rt.router.POST("/admin/vehicles", rt.wrap(rt.createVehicle, requireRole(database.RoleAdmin)))
+	rt.router.GET("/admin/assignments", rt.wrap(rt.getVehicleAssignments, requireRole(database.RoleAdmin)))
+	rt.router.PUT("/admin/assignments/:date/:train_id", rt.wrap(rt.assignVehicles, requireRole(database.RoleAdmin)))
+	rt.router.GET("/admin/stations/:name/codes", rt.wrap(rt.getStationCodes, requireRole(database.RoleAdmin)))
+	rt.router.GET("/admin/stations/:name/codes.svg", rt.wrap(rt.getStationCodeSheet, requireRole(database.RoleAdmin)))
+	rt.router.GET("/admin/suspicious_events", rt.wrap(rt.getSuspiciousEvents, requireRole(database.RoleAdmin)))
+	rt.router.PUT("/admin/suspicious_events/:event_id", rt.wrap(rt.reviewSuspiciousEvent, requireRole(database.RoleAdmin)))
+
 	return rt.router
 }
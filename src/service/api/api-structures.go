@@ -1,9 +1,83 @@
 package api
 
+import "github.com/ami-sc/DajeTrains/service/database"
+
 type UpdateResponse struct {
 	UpdateStatus string `json:"status"`
 }
 
 type TicketValidationResponse struct {
-	TrainID string `json:"train_id"`
-}
\ No newline at end of file
+	TrainID string           `json:"train_id"`
+	Valid   bool             `json:"valid"`
+	Reason  string           `json:"reason"`
+	Ticket  *database.Ticket `json:"ticket"`
+}
+
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type UserResponse struct {
+	Username string   `json:"username"`
+	Role     string   `json:"role"`
+	Lines    []string `json:"lines"`
+}
+
+type RoleRequest struct {
+	Role  string   `json:"role"`
+	Lines []string `json:"lines"`
+}
+
+type LoginResponse struct {
+	Token     string `json:"token"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+type InspectionRequest struct {
+	TicketCode string `json:"ticket_code"`
+	TrainID    string `json:"train_id"`
+}
+
+type FineRequest struct {
+	UserID string `json:"user_id"`
+}
+
+type PositionRequest struct {
+	Sightings []database.BeaconSighting `json:"sightings"`
+}
+
+type PositionEventsRequest struct {
+	Events []database.PositionEvent `json:"events"`
+}
+
+type CheckInRequest struct {
+	TrainID     string `json:"train_id"`
+	Station     string `json:"station"`
+	StationCode string `json:"station_code"`
+}
+
+type CheckOutRequest struct {
+	Station     string `json:"station"`
+	StationCode string `json:"station_code"`
+}
+
+type BeaconRequest struct {
+	ID string `json:"id"`
+	database.BeaconOwner
+	InstalledAt string `json:"installed_at"`
+}
+
+type BeaconUpdateRequest struct {
+	Status      string `json:"status"`
+	InstalledAt string `json:"installed_at"`
+}
+
+type AssignmentRequest struct {
+	Vehicles []string `json:"vehicles"`
+}
+
+type ReviewRequest struct {
+	Status string `json:"status"`
+	Note   string `json:"note"`
+}
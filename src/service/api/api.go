@@ -39,6 +39,8 @@ package api
 import (
 	"errors"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/ami-sc/DajeTrains/service/database"
 	"github.com/julienschmidt/httprouter"
@@ -52,6 +54,25 @@ type Config struct {
 
 	// Database is the instance of database.AppDatabase where data are saved
 	Database database.AppDatabase
+
+	// Operator contains the details of the train operator, printed on the receipts
+	Operator OperatorDetails
+
+	// TokenSecret is the key used to sign the bearer tokens
+	TokenSecret []byte
+
+	// TokenTTL is the validity of the bearer tokens
+	TokenTTL time.Duration
+
+	// SessionReaperInterval is how often the stale in-train sessions are closed. Zero disables the reaper.
+	SessionReaperInterval time.Duration
+}
+
+// OperatorDetails are the details of the train operator
+type OperatorDetails struct {
+	Name      string
+	Address   string
+	VATNumber string
 }
 
 // Router is the package API interface representing an API handler builder
@@ -72,6 +93,12 @@ func New(cfg Config) (Router, error) {
 	if cfg.Database == nil {
 		return nil, errors.New("database is required")
 	}
+	if len(cfg.TokenSecret) < 32 {
+		return nil, errors.New("token secret must be at least 32 bytes long")
+	}
+	if cfg.TokenTTL <= 0 {
+		return nil, errors.New("token TTL must be positive")
+	}
 
 	// Create a new router where we will register HTTP endpoints. The server will pass requests to this router to be
 	// handled.
@@ -79,11 +106,23 @@ func New(cfg Config) (Router, error) {
 	router.RedirectTrailingSlash = false
 	router.RedirectFixedPath = false
 
-	return &_router{
-		router:     router,
-		baseLogger: cfg.Logger,
-		db:         cfg.Database,
-	}, nil
+	rt := &_router{
+		router:      router,
+		baseLogger:  cfg.Logger,
+		db:          cfg.Database,
+		operator:    cfg.Operator,
+		tokenSecret: cfg.TokenSecret,
+		tokenTTL:    cfg.TokenTTL,
+		done:        make(chan struct{}),
+		events:      newEventHub(),
+	}
+
+	if cfg.SessionReaperInterval > 0 {
+		rt.background.Add(1)
+		go rt.runSessionReaper(cfg.SessionReaperInterval)
+	}
+
+	return rt, nil
 }
 
 type _router struct {
@@ -94,4 +133,19 @@ type _router struct {
 	baseLogger logrus.FieldLogger
 
 	db database.AppDatabase
+
+	operator OperatorDetails
+
+	tokenSecret []byte
+	tokenTTL    time.Duration
+
+	// dbLock serializes the access to the database, shared by the requests and the background tasks
+	dbLock sync.Mutex
+
+	// done is closed when the router is closed, to stop the background tasks
+	done       chan struct{}
+	background sync.WaitGroup
+
+	// events fans out the live events to the open streams
+	events *eventHub
 }
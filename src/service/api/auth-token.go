@@ -0,0 +1,91 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ami-sc/DajeTrains/service/globaltime"
+)
+
+var (
+	errMalformedToken = errors.New("malformed token")
+	errInvalidToken   = errors.New("invalid token signature")
+	errExpiredToken   = errors.New("token expired")
+)
+
+// tokenHeader is the JOSE header of the tokens issued by the API: HMAC-SHA256 signed JWTs (RFC 7519)
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// sign computes the signature of the token header and payload
+func (rt *_router) sign(signingInput string) string {
+	mac := hmac.New(sha256.New, rt.tokenSecret)
+	_, _ = mac.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// issueToken returns a new bearer token for the user, and its expiration time
+func (rt *_router) issueToken(userID string) (string, time.Time, error) {
+	now := globaltime.Now()
+	expiresAt := now.Add(rt.tokenTTL)
+
+	payload, err := json.Marshal(tokenClaims{
+		Subject:   userID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	signingInput := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	return signingInput + "." + rt.sign(signingInput), expiresAt, nil
+}
+
+// parseToken checks the signature and the expiration of a token, returning its claims
+func (rt *_router) parseToken(token string) (*tokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errMalformedToken
+	}
+
+	// only the header we issue is accepted: this rules out "alg: none" and algorithm confusion
+	if parts[0] != tokenHeader {
+		return nil, errMalformedToken
+	}
+
+	if !hmac.Equal([]byte(parts[2]), []byte(rt.sign(parts[0]+"."+parts[1]))) {
+		return nil, errInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errMalformedToken
+	}
+
+	if claims.Subject == "" {
+		return nil, errMalformedToken
+	}
+
+	if globaltime.Now().Unix() >= claims.ExpiresAt {
+		return nil, errExpiredToken
+	}
+
+	return &claims, nil
+}
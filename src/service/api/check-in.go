@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ami-sc/DajeTrains/service/api/reqcontext"
+	"github.com/ami-sc/DajeTrains/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+// check the user in on a train, without beacons
+func (rt *_router) checkIn(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	var request CheckInRequest
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid request body"})
+		return
+	}
+
+	response, err := rt.db.CheckIn(ps.ByName("user_id"), request.TrainID, database.StationDeclaration{
+		Station:     request.Station,
+		StationCode: request.StationCode,
+	})
+
+	if err == nil {
+		rt.publishUserUpdate(ps.ByName("user_id"), response)
+	}
+
+	writeCheckInResponse(w, response, err, ctx)
+}
+
+// check the user out of their train, without beacons
+func (rt *_router) checkOut(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	var request CheckOutRequest
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid request body"})
+		return
+	}
+
+	response, err := rt.db.CheckOut(ps.ByName("user_id"), database.StationDeclaration{
+		Station:     request.Station,
+		StationCode: request.StationCode,
+	})
+
+	if err == nil {
+		rt.publishUserUpdate(ps.ByName("user_id"), response)
+	}
+
+	writeCheckInResponse(w, response, err, ctx)
+}
+
+// write the new position of the user after a check-in (or a check-out), or the error
+func writeCheckInResponse(w http.ResponseWriter, response *database.UpdateUserPositionResponse, err error, ctx reqcontext.RequestContext) {
+	if errors.Is(err, database.ErrStationNotFound) || errors.Is(err, database.ErrInvalidStationCode) ||
+		errors.Is(err, database.ErrStationCodeExpired) || errors.Is(err, database.ErrStationNotServed) ||
+		errors.Is(err, database.ErrTrainNotAtStation) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if errors.Is(err, database.ErrTrainNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if errors.Is(err, database.ErrAlreadyCheckedIn) || errors.Is(err, database.ErrNotCheckedIn) {
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't update the user position")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"sync"
+)
+
+// subscriberBuffer is how many events a subscriber can lag behind before being dropped
+const subscriberBuffer = 64
+
+// streamEvent is an event published on the hub. The type and the data are sent to the clients; the train, the stops
+// and the user are only used to deliver the event to the subscribers interested in it.
+type streamEvent struct {
+	ID   uint64
+	Type string
+	Data interface{}
+
+	Train string
+	Stops []string
+	User  string
+}
+
+// subscription receives the events accepted by its filter, until it is dropped: then its channel is closed
+type subscription struct {
+	events  chan streamEvent
+	filter  func(streamEvent) bool
+	dropped bool
+}
+
+// eventHub fans out the published events to the subscribers. A subscriber that doesn't keep up is dropped, so that
+// the publishers are never blocked; the clients are expected to reconnect.
+type eventHub struct {
+	mu            sync.Mutex
+	subscriptions map[*subscription]struct{}
+	lastID        uint64
+	closed        bool
+
+	// active counts the subscriptions not cancelled yet, so that closing the hub waits for the handlers to return
+	active sync.WaitGroup
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subscriptions: make(map[*subscription]struct{})}
+}
+
+// subscribe registers a subscriber for the events accepted by the filter. It returns nil if the hub is closed. The
+// subscription must be cancelled when the subscriber is done.
+func (h *eventHub) subscribe(filter func(streamEvent) bool) *subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil
+	}
+
+	s := &subscription{
+		events: make(chan streamEvent, subscriberBuffer),
+		filter: filter,
+	}
+	h.subscriptions[s] = struct{}{}
+	h.active.Add(1)
+	return s
+}
+
+// cancel removes a subscription from the hub
+func (h *eventHub) cancel(s *subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, found := h.subscriptions[s]; !found {
+		return
+	}
+	h.drop(s)
+	delete(h.subscriptions, s)
+	h.active.Done()
+}
+
+// drop closes the channel of a subscription. The hub must be locked.
+func (h *eventHub) drop(s *subscription) {
+	if !s.dropped {
+		s.dropped = true
+		close(s.events)
+	}
+}
+
+// publish sends an event to the interested subscribers, numbering it
+func (h *eventHub) publish(event streamEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+
+	h.lastID++
+	event.ID = h.lastID
+
+	for s := range h.subscriptions {
+		if s.dropped || !s.filter(event) {
+			continue
+		}
+		select {
+		case s.events <- event:
+		default:
+			h.drop(s)
+		}
+	}
+}
+
+// close drops all the subscribers, and waits for them to cancel their subscriptions
+func (h *eventHub) close() {
+	h.mu.Lock()
+	h.closed = true
+	for s := range h.subscriptions {
+		h.drop(s)
+	}
+	h.mu.Unlock()
+
+	h.active.Wait()
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ami-sc/DajeTrains/service/api/reqcontext"
+	"github.com/ami-sc/DajeTrains/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// streamKeepAlive is how often a comment is sent on an idle stream, so that proxies don't close it
+	streamKeepAlive = 15 * time.Second
+
+	// streamWriteTimeout is how long a client can take to receive an event before being disconnected
+	streamWriteTimeout = 10 * time.Second
+
+	// streamRetry is how long the clients wait before reconnecting
+	streamRetry = 3 * time.Second
+)
+
+// stream the events of the trains as Server-Sent Events: all of them, or only the events of a train and/or of the
+// trains stopping at a station
+func (rt *_router) getEvents(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+
+	rt.dbLock.Lock()
+	trainID, station, err := rt.eventFilters(r.URL.Query().Get("train"), r.URL.Query().Get("station"))
+	rt.dbLock.Unlock()
+
+	if errors.Is(err, database.ErrTrainNotFound) || errors.Is(err, database.ErrStationNotFound) {
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	}
+
+	sub := rt.events.subscribe(func(event streamEvent) bool {
+		if event.User != "" {
+			return false
+		}
+		if trainID != "" && !strings.EqualFold(event.Train, trainID) {
+			return false
+		}
+		return station == "" || containsStation(event.Stops, station)
+	})
+
+	if sub == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	defer rt.events.cancel(sub)
+
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	w.Header().Set("connection", "close")
+
+	conn, rw, err := hijack(w, http.StatusOK)
+
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't open the event stream")
+		return
+	}
+	defer conn.Close()
+
+	gone := clientGone(rw)
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	_, _ = fmt.Fprintf(rw, "retry: %d\n\n", streamRetry.Milliseconds())
+
+	for {
+		if err := flushStream(conn, rw); err != nil {
+			ctx.Logger.WithError(err).Debug("event stream closed by the client")
+			return
+		}
+
+		select {
+		case <-gone:
+			return
+		case <-keepAlive.C:
+			_, _ = rw.WriteString(": keepalive\n\n")
+		case event, ok := <-sub.events:
+			if !ok {
+				// the client is too slow, or the server is shutting down
+				return
+			}
+
+			data, err := json.Marshal(event.Data)
+			if err != nil {
+				ctx.Logger.WithError(err).Error("can't encode the event")
+				continue
+			}
+			_, _ = fmt.Fprintf(rw, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+		}
+	}
+}
+
+// Get the train ID and the station name the events are filtered by. Empty filters are left empty.
+func (rt *_router) eventFilters(train string, station string) (string, string, error) {
+	if station != "" {
+		name := ""
+		for _, candidate := range *rt.db.GetStations(station) {
+			if strings.EqualFold(candidate.Name, station) {
+				name = candidate.Name
+			}
+		}
+		if name == "" {
+			return "", "", database.ErrStationNotFound
+		}
+		station = name
+	}
+
+	if train != "" {
+		for _, candidate := range *rt.db.GetTrains(train) {
+			if strings.EqualFold(candidate.ID, train) {
+				return candidate.ID, station, nil
+			}
+		}
+		return "", "", database.ErrTrainNotFound
+	}
+
+	return "", station, nil
+}
+
+// publish the events of a train update to the streams
+func (rt *_router) publishTrainEvents(events []database.TrainEvent) {
+	for _, event := range events {
+		rt.events.publish(streamEvent{
+			Type:  event.Type,
+			Data:  event,
+			Train: event.TrainID,
+			Stops: event.Stops,
+		})
+	}
+}
+
+// Check if a station is in a list of station names
+func containsStation(stations []string, station string) bool {
+	for _, name := range stations {
+		if strings.EqualFold(name, station) {
+			return true
+		}
+	}
+	return false
+}
+
+// hijack takes over the connection of a request, writing the status line and the headers of the response (with the
+// ones already set, e.g. by the CORS handler). The write timeout of the server doesn't apply to the connection
+// anymore, so that it can stay open.
+func hijack(w http.ResponseWriter, status int) (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the connection can't be hijacked")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+
+	_, _ = fmt.Fprintf(rw, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	_ = w.Header().Write(rw)
+	_, _ = rw.WriteString("\r\n")
+
+	if err := flushStream(conn, rw); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+
+	return conn, rw, nil
+}
+
+// flushStream sends what has been written on a hijacked connection, giving up if the client doesn't receive it in
+// time
+func flushStream(conn net.Conn, rw *bufio.ReadWriter) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil {
+		return err
+	}
+	return rw.Flush()
+}
+
+// clientGone returns a channel closed when the client of a hijacked connection disconnects. Anything the client
+// sends is discarded.
+func clientGone(rw *bufio.ReadWriter) <-chan struct{} {
+	gone := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(ioutil.Discard, rw)
+		close(gone)
+	}()
+	return gone
+}
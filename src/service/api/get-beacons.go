@@ -5,11 +5,19 @@ import (
 	"net/http"
 
 	"github.com/ami-sc/DajeTrains/service/api/reqcontext"
+	"github.com/ami-sc/DajeTrains/service/database"
 	"github.com/julienschmidt/httprouter"
 )
 
+// get the beacons of the registry, optionally filtered by type, owner and status
 func (rt *_router) getBeacons(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("content-type", "application/json")
 
-	_ = json.NewEncoder(w).Encode(rt.db.GetBeaconList())
-}
\ No newline at end of file
+	filter := database.BeaconFilter{
+		Type:   r.URL.Query().Get("type"),
+		Owner:  r.URL.Query().Get("owner"),
+		Status: r.URL.Query().Get("status"),
+	}
+
+	_ = json.NewEncoder(w).Encode(rt.db.GetBeacons(filter))
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/ami-sc/DajeTrains/service/api/reqcontext"
+	"github.com/ami-sc/DajeTrains/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+var receiptTemplate = template.Must(template.New("receipt").Funcs(template.FuncMap{
+	"euro": formatCents,
+	"percent": func(rate float64) string {
+		return formatCents(int64(rate*10000+0.5)) + "%"
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Receipt {{ .Payment.ReceiptNumber }}</title>
+<style>
+	body { font-family: sans-serif; max-width: 40em; margin: 2em auto; color: #222; }
+	header { border-bottom: 2px solid #222; margin-bottom: 1em; }
+	table { width: 100%; border-collapse: collapse; margin: 1em 0; }
+	th, td { text-align: left; padding: .3em; border-bottom: 1px solid #ccc; }
+	td.amount, th.amount { text-align: right; }
+	.status { font-weight: bold; text-transform: uppercase; }
+	@media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+<header>
+	<h1>{{ .Operator.Name }}</h1>
+	<p>{{ .Operator.Address }}<br>VAT number: {{ .Operator.VATNumber }}</p>
+</header>
+<h2>Receipt {{ .Payment.ReceiptNumber }}</h2>
+<p>Date: {{ .Payment.Date }}{{ if ne .Payment.Status "captured" }} &mdash; <span class="status">{{ .Payment.Status }}</span>{{ end }}</p>
+<table>
+	<tr><th>Train</th><td colspan="2">{{ .Payment.TrainID }}</td></tr>
+{{- if eq .Payment.Type "fine" }}
+	<tr><th>Fine</th><td colspan="2">Travelling without a valid ticket</td></tr>
+{{- else }}
+	<tr><th></th><th>Scheduled</th><th>Actual</th></tr>
+	<tr><th>Departure: {{ with .Payment.FromStation }}{{ .Name }}{{ end }}</th><td>{{ .Payment.ScheduledDepartureTime }}</td><td>{{ .Payment.DepartureTime }}</td></tr>
+	<tr><th>Arrival: {{ with .Payment.ToStation }}{{ .Name }}{{ end }}</th><td>{{ .Payment.ScheduledArrivalTime }}</td><td>{{ .Payment.ArrivalTime }}</td></tr>
+{{- end }}
+</table>
+{{ with .Payment.VAT }}
+<table>
+	<tr><th>Net amount</th><td class="amount">&euro; {{ euro .Net }}</td></tr>
+	<tr><th>VAT ({{ percent .Rate }})</th><td class="amount">&euro; {{ euro .VAT }}</td></tr>
+	<tr><th>Total</th><td class="amount"><strong>&euro; {{ euro .Gross }}</strong></td></tr>
+</table>
+{{ end }}
+</body>
+</html>
+`))
+
+// render a printable receipt of the user (admins can render any receipt)
+func (rt *_router) getReceipt(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+
+	receipt_id := ps.ByName("receipt_id")
+
+	// users can only see their own receipts, admins any receipt
+	owner := ctx.UserID
+	if ctx.Role == database.RoleAdmin {
+		owner = ""
+	}
+
+	payment, err := rt.db.GetReceipt(owner, receipt_id)
+
+	if errors.Is(err, database.ErrReceiptNotFound) {
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't get the receipt")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "text/html; charset=utf-8")
+
+	err = receiptTemplate.Execute(w, struct {
+		Operator OperatorDetails
+		Payment  *database.PaymentResponse
+	}{
+		Operator: rt.operator,
+		Payment:  payment,
+	})
+
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't render the receipt")
+	}
+}
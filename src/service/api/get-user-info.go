@@ -1,10 +1,14 @@
 package api
 
 import (
+	"encoding/csv"
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/ami-sc/DajeTrains/service/api/reqcontext"
+	"github.com/ami-sc/DajeTrains/service/database"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -25,19 +29,112 @@ func (rt *_router) getUserPosition(w http.ResponseWriter, r *http.Request, ps ht
 	_ = json.NewEncoder(w).Encode(status)
 }
 
+// get the trips of a user
+func (rt *_router) getTrips(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	user_id := ps.ByName("user_id")
+
+	_ = json.NewEncoder(w).Encode(rt.db.GetTrips(user_id))
+}
+
 // get user payment history
 func (rt *_router) getPaymentHistory(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("content-type", "application/json")
 
 	user_id := ps.ByName("user_id")
+	query := r.URL.Query()
+
+	filter := database.PaymentHistoryFilter{
+		TrainID: query.Get("train"),
+		Station: query.Get("station"),
+		Sort:    query.Get("sort"),
+		Cursor:  query.Get("cursor"),
+	}
 
-	history, err := rt.db.GetPaymentHistory(user_id)
+	var err error
 
+	filter.From, err = parseDateParam(r, "from")
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		_ = json.NewEncoder(w).Encode((&UpdateResponse{UpdateStatus: err.Error()}))
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid from date"})
+		return
+	}
+
+	filter.To, err = parseDateParam(r, "to")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid to date"})
+		return
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid limit"})
+			return
+		}
+	}
+
+	history, next, err := rt.db.GetPaymentHistory(user_id, filter)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	}
+
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
+
+	if query.Get("format") == "csv" || strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		writePaymentHistoryCSV(w, history, ctx)
 		return
 	}
 
 	_ = json.NewEncoder(w).Encode(history)
-}
\ No newline at end of file
+}
+
+// write the payment history as CSV, for expense reports
+func writePaymentHistoryCSV(w http.ResponseWriter, history []database.PaymentResponse, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "text/csv")
+	w.Header().Set("content-disposition", `attachment; filename="payment_history.csv"`)
+
+	writer := csv.NewWriter(w)
+	_ = writer.Write([]string{
+		"id", "date", "train_id", "from_station", "to_station",
+		"scheduled_departure_time", "departure_time", "scheduled_arrival_time", "arrival_time",
+		"cost", "status", "type",
+	})
+	for _, payment := range history {
+		from_station, to_station := "", ""
+		if payment.FromStation != nil {
+			from_station = payment.FromStation.Name
+		}
+		if payment.ToStation != nil {
+			to_station = payment.ToStation.Name
+		}
+
+		_ = writer.Write([]string{
+			payment.ID,
+			payment.Date,
+			payment.TrainID,
+			from_station,
+			to_station,
+			payment.ScheduledDepartureTime,
+			payment.DepartureTime,
+			payment.ScheduledArrivalTime,
+			payment.ArrivalTime,
+			strconv.FormatFloat(payment.Cost, 'f', 2, 64),
+			payment.Status,
+			payment.Type,
+		})
+	}
+	writer.Flush()
+
+	if err := writer.Error(); err != nil {
+		ctx.Logger.WithError(err).Error("can't write the payment history CSV")
+	}
+}
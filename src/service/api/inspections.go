@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ami-sc/DajeTrains/service/api/reqcontext"
+	"github.com/ami-sc/DajeTrains/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+// check a ticket on board, recording the inspection
+func (rt *_router) inspectTicket(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	var request InspectionRequest
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid request body"})
+		return
+	}
+
+	inspection, err := rt.db.InspectTicket(ctx.UserID, request.TrainID, request.TicketCode)
+
+	if errors.Is(err, database.ErrInspectorNotOnTrain) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if errors.Is(err, database.ErrInspectorOnOtherTrain) {
+		w.WriteHeader(http.StatusForbidden)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if errors.Is(err, database.ErrTrainNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't record the inspection")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(inspection)
+}
+
+// fine a passenger found without a valid ticket
+func (rt *_router) issueFine(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	var request FineRequest
+
+	// the body is optional: the passenger is the owner of the scanned ticket, and must be given only if there is none
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid request body"})
+			return
+		}
+	}
+
+	payment, err := rt.db.IssueFine(ps.ByName("inspection_id"), request.UserID)
+
+	if errors.Is(err, database.ErrInspectionNotFound) || errors.Is(err, database.ErrUserNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if errors.Is(err, database.ErrFineNotAllowed) || errors.Is(err, database.ErrFineAlreadyIssued) ||
+		errors.Is(err, database.ErrFinePassengerMismatch) || errors.Is(err, database.ErrPassengerNotOnTrain) {
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't issue the fine")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(payment)
+}
+
+// get the inspection statistics of each train
+func (rt *_router) getInspectionStats(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	from, err := parseDateParam(r, "from")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid from date"})
+		return
+	}
+
+	to, err := parseDateParam(r, "to")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid to date"})
+		return
+	}
+
+	// the "to" date is inclusive
+	if !to.IsZero() {
+		to = to.AddDate(0, 0, 1)
+	}
+
+	_ = json.NewEncoder(w).Encode(rt.db.GetInspectionStats(from, to))
+}
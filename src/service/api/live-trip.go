@@ -0,0 +1,210 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ami-sc/DajeTrains/service/api/reqcontext"
+	"github.com/ami-sc/DajeTrains/service/database"
+	"github.com/ami-sc/DajeTrains/service/websocket"
+	"github.com/julienschmidt/httprouter"
+)
+
+// liveTripPing is how often the live trip connections are pinged. A connection silent for two pings is closed.
+const liveTripPing = 20 * time.Second
+
+const (
+	// liveTripProgress is a change of the position or of the trip of a passenger. Its data is the new progress.
+	liveTripProgress = "progress"
+
+	// liveTripPayment is the payment of a trip, charged when the passenger gets off
+	liveTripPayment = "payment"
+
+	// liveTripTrain is a train event of the train the passenger is on
+	liveTripTrain = "train"
+)
+
+// LiveTripMessage is a message sent to a passenger following their trip
+type LiveTripMessage struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// push the changes of the current trip of a passenger over a WebSocket. The first message is always the progress of
+// the trip, so that reconnecting clients catch up with whatever they missed. The events of the passenger and the ones
+// of their train come from separate subscriptions: the second one follows the passenger from train to train.
+func (rt *_router) getLiveTrip(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+
+	userID := ps.ByName("user_id")
+
+	sub := rt.events.subscribe(func(event streamEvent) bool {
+		return event.User == userID
+	})
+
+	if sub == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	defer rt.events.cancel(sub)
+
+	conn, err := websocket.Upgrade(w, r)
+
+	if errors.Is(err, websocket.ErrNotWebSocket) {
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't open the live trip connection")
+		return
+	}
+
+	// the reader answers the pings, and notices when the client goes away (or stops answering the pings)
+	gone := make(chan error, 1)
+	go func() {
+		for {
+			_ = conn.SetReadDeadline(time.Now().Add(2 * liveTripPing))
+			if _, _, err := conn.ReadMessage(); err != nil {
+				gone <- err
+				return
+			}
+		}
+	}()
+
+	send := func(messageType string, data interface{}) error {
+		message, err := json.Marshal(&LiveTripMessage{Type: messageType, Data: data})
+		if err != nil {
+			return err
+		}
+		return conn.WriteMessage(websocket.OpText, message)
+	}
+
+	// the subscription to the events of the train of the passenger, if any; its channel is nil otherwise, so that it
+	// is never selected
+	var trainSub *subscription
+	var trainEvents chan streamEvent
+	subscribedTrain := ""
+	defer func() {
+		if trainSub != nil {
+			rt.events.cancel(trainSub)
+		}
+	}()
+
+	followTrain := func(trainID string) {
+		if trainID == subscribedTrain {
+			return
+		}
+		if trainSub != nil {
+			rt.events.cancel(trainSub)
+			trainSub, trainEvents = nil, nil
+		}
+		subscribedTrain = trainID
+		if trainID == "" {
+			return
+		}
+
+		trainSub = rt.events.subscribe(func(event streamEvent) bool {
+			return event.User == "" && strings.EqualFold(event.Train, trainID)
+		})
+		if trainSub != nil {
+			trainEvents = trainSub.events
+		}
+	}
+
+	// the progress is sent only when it changes; the train of the passenger is followed to get its events
+	lastProgress := ""
+	sendProgress := func() error {
+		rt.dbLock.Lock()
+		progress := rt.db.GetTripProgress(userID)
+		data, err := json.Marshal(progress)
+		rt.dbLock.Unlock()
+
+		if err != nil {
+			return err
+		}
+
+		trainID := ""
+		if progress.Position != nil && progress.Position.Status == database.InTrain && progress.Position.Train != nil {
+			trainID = progress.Position.Train.ID
+		}
+		followTrain(trainID)
+
+		if string(data) == lastProgress {
+			return nil
+		}
+		lastProgress = string(data)
+		return send(liveTripProgress, json.RawMessage(data))
+	}
+
+	// the server is shutting down, or the client is too slow: either way, it should reconnect later
+	closeDropped := func() {
+		select {
+		case <-rt.done:
+			_ = conn.Close(websocket.CloseGoingAway, "Server shutting down")
+		default:
+			_ = conn.Close(websocket.CloseTryAgainLater, "Too many updates")
+		}
+	}
+
+	ping := time.NewTicker(liveTripPing)
+	defer ping.Stop()
+
+	err = sendProgress()
+
+	for err == nil {
+		select {
+		case err = <-gone:
+			ctx.Logger.WithError(err).Debug("live trip closed by the client")
+			_ = conn.Close(websocket.CloseNormal, "")
+			return
+
+		case <-ping.C:
+			err = conn.Ping(nil)
+
+		case event, ok := <-sub.events:
+			if !ok {
+				closeDropped()
+				return
+			}
+
+			if event.Type == liveTripPayment {
+				err = send(liveTripPayment, event.Data)
+			}
+
+			if err == nil {
+				err = sendProgress()
+			}
+
+		case event, ok := <-trainEvents:
+			if !ok {
+				closeDropped()
+				return
+			}
+
+			err = send(liveTripTrain, event.Data)
+
+			if err == nil {
+				err = sendProgress()
+			}
+		}
+	}
+
+	ctx.Logger.WithError(err).Debug("live trip closed")
+	_ = conn.Close(websocket.CloseNormal, "")
+}
+
+// publish a change of the position of a passenger to their live trip, with the payment charged if any. The database
+// must be locked.
+func (rt *_router) publishUserUpdate(userID string, response *database.UpdateUserPositionResponse) {
+	if response != nil && response.PaymentResponse != nil {
+		// the payment is encoded now, since it may change later (e.g., when refunded)
+		if data, err := json.Marshal(response.PaymentResponse); err == nil {
+			rt.events.publish(streamEvent{Type: liveTripPayment, Data: json.RawMessage(data), User: userID})
+		}
+	}
+
+	rt.events.publish(streamEvent{Type: liveTripProgress, User: userID})
+}
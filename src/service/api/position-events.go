@@ -0,0 +1,46 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ami-sc/DajeTrains/service/api/reqcontext"
+	"github.com/ami-sc/DajeTrains/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+// upload the position events buffered by the phone while it was offline
+func (rt *_router) uploadPositionEvents(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	user_id := ps.ByName("user_id")
+
+	var request PositionEventsRequest
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid request body"})
+		return
+	}
+
+	response, err := rt.db.ProcessPositionEvents(user_id, request.Events)
+
+	if errors.Is(err, database.ErrInvalidPositionEvent) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't process the position events")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	for _, update := range response.Updates {
+		rt.publishUserUpdate(user_id, update)
+	}
+
+	_ = json.NewEncoder(w).Encode(response)
+}
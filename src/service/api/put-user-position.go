@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/ami-sc/DajeTrains/service/api/reqcontext"
+	"github.com/ami-sc/DajeTrains/service/database"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -13,11 +14,25 @@ func (rt *_router) updateUserPosition(w http.ResponseWriter, r *http.Request, ps
 	w.Header().Set("content-type", "application/json")
 
 	user_id := ps.ByName("user_id")
-	beacon_id := r.URL.Query().Get("beacon_id")
 
-	status, err := rt.db.UpdateUserPosition(user_id, beacon_id)
+	// older apps send the single beacon they chose, newer ones all the beacons they heard
+	var sightings []database.BeaconSighting
+	if beacon_id := r.URL.Query().Get("beacon_id"); beacon_id != "" {
+		sightings = append(sightings, database.BeaconSighting{BeaconID: beacon_id})
+	} else if r.ContentLength != 0 {
+		var request PositionRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid request body"})
+			return
+		}
+		sightings = request.Sightings
+	}
+
+	status, err := rt.db.UpdateUserPosition(user_id, sightings)
 
 	if err == nil {
+		rt.publishUserUpdate(user_id, status)
 		_ = json.NewEncoder(w).Encode(status)
 	}
-}
\ No newline at end of file
+}
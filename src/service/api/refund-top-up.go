@@ -0,0 +1,44 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ami-sc/DajeTrains/service/api/reqcontext"
+	"github.com/ami-sc/DajeTrains/service/database"
+	"github.com/ami-sc/DajeTrains/service/payments"
+	"github.com/julienschmidt/httprouter"
+)
+
+// refund a top up of the wallet of a user
+func (rt *_router) refundTopUp(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	user_id := ps.ByName("user_id")
+	top_up_id := ps.ByName("top_up_id")
+
+	topUp, err := rt.db.RefundTopUp(user_id, top_up_id)
+
+	if errors.Is(err, database.ErrTopUpNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if errors.Is(err, database.ErrTopUpNotRefundable) || errors.Is(err, database.ErrInsufficientFunds) {
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if errors.Is(err, payments.ErrDeclined) || errors.Is(err, payments.ErrTimeout) ||
+		errors.Is(err, payments.ErrInvalidState) || errors.Is(err, payments.ErrUnknownAuthorization) {
+		// the payment provider refused the refund, or didn't answer
+		w.WriteHeader(http.StatusBadGateway)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't refund the top up")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(topUp)
+}
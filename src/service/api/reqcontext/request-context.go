@@ -18,4 +18,10 @@ type RequestContext struct {
 
 	// Logger is a custom field logger for the request
 	Logger logrus.FieldLogger
+
+	// UserID is the authenticated user. It's empty for anonymous requests.
+	UserID string
+
+	// Role is the role of the authenticated user. It's empty for anonymous requests.
+	Role string
 }
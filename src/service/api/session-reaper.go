@@ -0,0 +1,44 @@
+package api
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runSessionReaper closes, at every interval, the in-train sessions of the passengers whose phone went silent on
+// board, until the router is closed
+func (rt *_router) runSessionReaper(interval time.Duration) {
+	defer rt.background.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rt.done:
+			return
+		case now := <-ticker.C:
+			rt.dbLock.Lock()
+			sessions, err := rt.db.ReapStaleSessions(now)
+			for _, session := range sessions {
+				rt.publishUserUpdate(session.Trip.UserID, session.Response)
+			}
+			rt.dbLock.Unlock()
+
+			if err != nil {
+				rt.baseLogger.WithError(err).Error("can't close the stale sessions")
+				continue
+			}
+
+			for _, session := range sessions {
+				rt.baseLogger.WithFields(logrus.Fields{
+					"user":   session.Trip.UserID,
+					"train":  session.Trip.TrainID,
+					"reason": session.Trip.EndReason,
+					"status": session.Trip.Status,
+				}).Info("stale session closed")
+			}
+		}
+	}
+}
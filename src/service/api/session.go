@@ -0,0 +1,144 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ami-sc/DajeTrains/service/api/reqcontext"
+	"github.com/ami-sc/DajeTrains/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+// register a new user
+func (rt *_router) registerUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	var credentials Credentials
+
+	err := json.NewDecoder(r.Body).Decode(&credentials)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid request body"})
+		return
+	}
+
+	// the password is hashed without holding the database lock, since hashing is slow by design
+	hash, salt, err := database.HashPassword(credentials.Password)
+
+	var user *database.User
+	if err == nil {
+		rt.dbLock.Lock()
+		user, err = rt.db.CreateUser(credentials.Username, hash, salt)
+		rt.dbLock.Unlock()
+	}
+
+	if errors.Is(err, database.ErrUserExists) {
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if errors.Is(err, database.ErrInvalidUsername) || errors.Is(err, database.ErrInvalidPassword) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't register the user")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(&UserResponse{
+		Username: user.Username,
+		Role:     user.Role,
+		Lines:    user.Lines,
+	})
+}
+
+// log in, getting a bearer token
+func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	var credentials Credentials
+
+	err := json.NewDecoder(r.Body).Decode(&credentials)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid request body"})
+		return
+	}
+
+	rt.dbLock.Lock()
+	user, err := rt.db.GetUser(credentials.Username)
+	rt.dbLock.Unlock()
+
+	// the password is checked without holding the database lock, since hashing is slow by design
+	if err == nil {
+		err = database.CheckPassword(*user, credentials.Password)
+	}
+
+	if errors.Is(err, database.ErrUserNotFound) || errors.Is(err, database.ErrInvalidCredentials) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: database.ErrInvalidCredentials.Error()})
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't check the user password")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	token, expiresAt, err := rt.issueToken(user.Username)
+
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't issue the token")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(&LoginResponse{
+		Token:     token,
+		ExpiresAt: expiresAt.Format(time.RFC3339),
+	})
+}
+
+// set the role of a user
+func (rt *_router) setUserRole(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	var request RoleRequest
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid request body"})
+		return
+	}
+
+	user, err := rt.db.SetUserRole(ps.ByName("username"), request.Role, request.Lines)
+
+	if errors.Is(err, database.ErrUserNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if errors.Is(err, database.ErrInvalidRole) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't set the user role")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	ctx.Logger.WithField("target", user.Username).Infof("role set to %s", user.Role)
+
+	_ = json.NewEncoder(w).Encode(&UserResponse{
+		Username: user.Username,
+		Role:     user.Role,
+		Lines:    user.Lines,
+	})
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"time"
+
+	"github.com/ami-sc/DajeTrains/service/api/reqcontext"
+	"github.com/ami-sc/DajeTrains/service/database"
+	"github.com/ami-sc/DajeTrains/service/qrcode"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Layout of the sheet of the station codes, in millimeters: an A4 page (growing taller for the stations with many
+// platforms) with two codes per row
+const (
+	sheetWidth     = 210.0
+	sheetHeight    = 297.0
+	sheetMargin    = 15.0
+	sheetHeader    = 35.0
+	sheetColumns   = 2
+	sheetRowHeight = 115.0
+	sheetCodeSize  = 75.0
+)
+
+// generate the signed codes of a station, reading the options from the query string. On errors, the response has
+// already been written.
+func (rt *_router) stationCodes(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) ([]database.StationCode, bool) {
+
+	from, err := parseDateParam(r, "from")
+	if err != nil {
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid from date"})
+		return nil, false
+	}
+	if from.IsZero() {
+		from = time.Now()
+	}
+
+	codes, err := rt.db.GetStationCodes(ps.ByName("name"), r.URL.Query().Get("platforms") == "true", from)
+
+	if errors.Is(err, database.ErrStationNotFound) {
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return nil, false
+	} else if errors.Is(err, database.ErrNoStationCodeKey) {
+		ctx.Logger.WithError(err).Warning("can't sign the station codes")
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return nil, false
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't sign the station codes")
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return codes, true
+}
+
+// get the signed codes of a station (and of its platforms)
+func (rt *_router) getStationCodes(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+
+	codes, ok := rt.stationCodes(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(codes)
+}
+
+// render the codes of a station (and of its platforms) as a printable SVG sheet
+func (rt *_router) getStationCodeSheet(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+
+	codes, ok := rt.stationCodes(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	sheet, err := renderStationCodeSheet(codes)
+
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the station QR codes")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "image/svg+xml")
+	_, _ = w.Write(sheet)
+}
+
+// render the codes of a station as an SVG sheet, sized in millimeters so that it prints at the right scale
+func renderStationCodeSheet(codes []database.StationCode) ([]byte, error) {
+	rows := (len(codes) + sheetColumns - 1) / sheetColumns
+	height := sheetHeader + float64(rows)*sheetRowHeight + sheetMargin
+	if height < sheetHeight {
+		height = sheetHeight
+	}
+	cellWidth := (sheetWidth - 2*sheetMargin) / sheetColumns
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" version="1.1" width="%gmm" height="%gmm" viewBox="0 0 %g %g" font-family="sans-serif" text-anchor="middle">`+"\n", sheetWidth, height, sheetWidth, height)
+	fmt.Fprintf(&buf, `<rect width="100%%" height="100%%" fill="#FFFFFF"/>`+"\n")
+
+	if len(codes) > 0 {
+		fmt.Fprintf(&buf, `<text x="%g" y="%g" font-size="9" font-weight="bold">%s</text>`+"\n", sheetWidth/2, sheetMargin+7, html.EscapeString(codes[0].Station))
+		fmt.Fprintf(&buf, `<text x="%g" y="%g" font-size="4">Scan with the DajeTrains app to check in and out</text>`+"\n", sheetWidth/2, sheetMargin+15)
+	}
+
+	for i, code := range codes {
+		qr, err := qrcode.Encode([]byte(code.Code), qrcode.Medium)
+		if err != nil {
+			return nil, err
+		}
+
+		// center of the cell
+		x := sheetMargin + (float64(i%sheetColumns)+0.5)*cellWidth
+		y := sheetHeader + float64(i/sheetColumns)*sheetRowHeight
+
+		scale := sheetCodeSize / float64(qr.Width())
+		fmt.Fprintf(&buf, `<g transform="translate(%g,%g) scale(%g)" shape-rendering="crispEdges"><path fill="#000000" d="%s"/></g>`+"\n", x-sheetCodeSize/2, y, scale, qr.Path())
+
+		label := "Station"
+		if code.Platform > 0 {
+			label = fmt.Sprintf("Platform %d", code.Platform)
+		}
+		validUntil := code.ValidUntil
+		if t, err := time.Parse(time.RFC3339, code.ValidUntil); err == nil {
+			validUntil = t.Format("2006-01-02")
+		}
+
+		fmt.Fprintf(&buf, `<text x="%g" y="%g" font-size="6">%s</text>`+"\n", x, y+sheetCodeSize+8, label)
+		fmt.Fprintf(&buf, `<text x="%g" y="%g" font-size="3" fill="#555555">Valid until %s</text>`+"\n", x, y+sheetCodeSize+14, validUntil)
+	}
+
+	buf.WriteString("</svg>\n")
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ami-sc/DajeTrains/service/api/reqcontext"
+	"github.com/ami-sc/DajeTrains/service/database"
+	"github.com/ami-sc/DajeTrains/service/qrcode"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	defaultQRSize = 256
+	minQRSize     = 64
+	maxQRSize     = 2048
+)
+
+// encode the signed form of a ticket as a QR code, reading the size and the error-correction level from the query
+// string. On errors, the response has already been written.
+func (rt *_router) ticketQRCode(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) (*qrcode.Code, int, bool) {
+
+	size := defaultQRSize
+	if value := r.URL.Query().Get("size"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < minQRSize || parsed > maxQRSize {
+			w.Header().Set("content-type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "The size must be between 64 and 2048 pixels"})
+			return nil, 0, false
+		}
+		size = parsed
+	}
+
+	level := qrcode.Medium
+	if value := r.URL.Query().Get("ec"); value != "" {
+		parsed, err := qrcode.ParseLevel(value)
+		if err != nil {
+			w.Header().Set("content-type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "The error-correction level must be L, M, Q or H"})
+			return nil, 0, false
+		}
+		level = parsed
+	}
+
+	signedTicket, err := rt.db.GetSignedTicket(ps.ByName("ticket_code"))
+
+	if errors.Is(err, database.ErrTicketNotFound) || errors.Is(err, database.ErrInvalidTicketSignature) {
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: database.ErrTicketNotFound.Error()})
+		return nil, 0, false
+	} else if errors.Is(err, database.ErrTicketRevoked) || errors.Is(err, database.ErrTicketExpired) || errors.Is(err, database.ErrTicketCompleted) {
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusGone)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return nil, 0, false
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't sign the ticket")
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, 0, false
+	}
+
+	code, err := qrcode.Encode([]byte(signedTicket), level)
+
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the ticket QR code")
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, 0, false
+	}
+
+	return code, size, true
+}
+
+// render the QR code of a ticket as a PNG image
+func (rt *_router) getTicketQRCodePNG(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+
+	code, size, ok := rt.ticketQRCode(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	image, err := code.PNG(size)
+
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't render the ticket QR code")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "image/png")
+	_, _ = w.Write(image)
+}
+
+// render the QR code of a ticket as an SVG image
+func (rt *_router) getTicketQRCodeSVG(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+
+	code, size, ok := rt.ticketQRCode(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("content-type", "image/svg+xml")
+	_, _ = w.Write(code.SVG(size))
+}
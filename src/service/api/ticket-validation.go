@@ -2,30 +2,128 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/ami-sc/DajeTrains/service/api/reqcontext"
+	"github.com/ami-sc/DajeTrains/service/database"
 	"github.com/julienschmidt/httprouter"
 )
 
+// get the reason why a ticket is not valid
+func ticketInvalidReason(err error) string {
+	switch {
+	case errors.Is(err, database.ErrTicketNotFound):
+		return "TICKET_NOT_FOUND"
+	case errors.Is(err, database.ErrInvalidTicketSignature):
+		return "INVALID_SIGNATURE"
+	case errors.Is(err, database.ErrTicketRevoked):
+		return "TICKET_REVOKED"
+	case errors.Is(err, database.ErrTicketExpired):
+		return "TICKET_EXPIRED"
+	case errors.Is(err, database.ErrTicketCompleted):
+		return "TICKET_COMPLETED"
+	}
+	return ""
+}
+
 // validate a ticket
 func (rt *_router) validateTicket(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("content-type", "application/json")
 
-	ticket := ps.ByName("ticket_code")
+	code := ps.ByName("ticket_code")
+
+	ticket, err := rt.db.ValidateTicket(code)
+
+	reason := ticketInvalidReason(err)
+
+	if err != nil && reason == "" {
+		ctx.Logger.WithError(err).Error("can't validate the ticket")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := TicketValidationResponse{
+		TrainID: "TICKET_INVALID",
+		Valid:   err == nil,
+		Reason:  reason,
+		Ticket:  ticket,
+	}
 
-	trainID, err := rt.db.ValidateTicket(ticket)
+	if ticket != nil {
+		response.TrainID = ticket.TrainID
+	}
 
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(&TicketValidationResponse{
-			TrainID: "TICKET_INVALID",
-		})
+		_ = json.NewEncoder(w).Encode(&response)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(&response)
+}
+
+// revoke a ticket
+func (rt *_router) revokeTicket(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	err := rt.db.RevokeTicket(ps.ByName("ticket_code"))
+
+	if errors.Is(err, database.ErrTicketNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't revoke the ticket")
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(&TicketValidationResponse{
-		TrainID: trainID,
-	})
+	_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "TICKET_REVOKED"})
+}
+
+// get the list of the revoked tickets
+func (rt *_router) getRevokedTickets(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(rt.db.GetRevokedTickets())
+}
+
+// get the public key that verifies the signed tickets
+func (rt *_router) getTicketPublicKey(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(rt.db.GetTicketPublicKey())
+}
+
+// get the manifest of the valid tickets of a train, or the changes since a version
+func (rt *_router) getTicketManifest(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	since := int64(0)
+	if value := r.URL.Query().Get("since"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid since version"})
+			return
+		}
+		since = parsed
+	}
+
+	// the GET routes of the trains share the name parameter
+	manifest, err := rt.db.GetTicketManifest(ps.ByName("name"), since)
+
+	if errors.Is(err, database.ErrTrainNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't get the ticket manifest")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(manifest)
 }
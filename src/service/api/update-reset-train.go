@@ -3,12 +3,13 @@ package api
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 
 	"github.com/ami-sc/DajeTrains/service/api/reqcontext"
 	"github.com/julienschmidt/httprouter"
 )
 
-// update train position
+// update train position (and the platform at the station, if given), publishing the changes to the event streams
 func (rt *_router) updateTrainPosition(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("content-type", "application/json")
 
@@ -17,7 +18,19 @@ func (rt *_router) updateTrainPosition(w http.ResponseWriter, r *http.Request, p
 	status := r.URL.Query().Get("status")
 	time_string := r.URL.Query().Get("time")
 
-	err := rt.db.UpdateTrainPosition(train_id, station_id, status, time_string)
+	platform := 0
+	if platform_string := r.URL.Query().Get("platform"); platform_string != "" {
+		var err error
+		platform, err = strconv.Atoi(platform_string)
+
+		if err != nil || platform <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid platform"})
+			return
+		}
+	}
+
+	events, err := rt.db.UpdateTrainPosition(train_id, station_id, status, time_string, platform)
 
 	if err != nil {
 		// set status code to 400
@@ -25,6 +38,8 @@ func (rt *_router) updateTrainPosition(w http.ResponseWriter, r *http.Request, p
 		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
 		return
 	}
+	rt.publishTrainEvents(events)
+
 	_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "OK"})
 }
 
@@ -34,7 +49,7 @@ func (rt *_router) resetTrainPosition(w http.ResponseWriter, r *http.Request, ps
 
 	train_id := ps.ByName("train_id")
 
-	err := rt.db.ResetTrainPosition(train_id)
+	sessions, err := rt.db.ResetTrainPosition(train_id)
 
 	if err != nil {
 		// set status code to 404
@@ -42,5 +57,10 @@ func (rt *_router) resetTrainPosition(w http.ResponseWriter, r *http.Request, ps
 		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
 		return
 	}
+
+	// the passengers still on board have been moved off the train
+	for _, session := range sessions {
+		rt.publishUserUpdate(session.Trip.UserID, session.Response)
+	}
 	_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "OK"})
 }
\ No newline at end of file
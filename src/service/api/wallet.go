@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ami-sc/DajeTrains/service/api/reqcontext"
+	"github.com/ami-sc/DajeTrains/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+// get the wallet of a user
+func (rt *_router) getWallet(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	user_id := ps.ByName("user_id")
+
+	_ = json.NewEncoder(w).Encode(rt.db.GetWallet(user_id))
+}
+
+// top up the wallet of a user
+func (rt *_router) topUpWallet(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("content-type", "application/json")
+
+	user_id := ps.ByName("user_id")
+	idempotency_key := r.Header.Get("Idempotency-Key")
+
+	amount, err := strconv.ParseInt(r.URL.Query().Get("amount"), 10, 64)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: "Invalid amount"})
+		return
+	}
+
+	// the payment provider is called without holding the database lock, so that a slow provider doesn't hold up the
+	// other requests: the top up is stored as pending first, and completed afterwards
+	rt.dbLock.Lock()
+	topUp, started, err := rt.db.StartTopUp(user_id, amount, idempotency_key)
+	rt.dbLock.Unlock()
+
+	if err == nil && started {
+		charged := rt.db.ChargeTopUp(user_id, *topUp)
+
+		rt.dbLock.Lock()
+		topUp, err = rt.db.CompleteTopUp(user_id, charged)
+		rt.dbLock.Unlock()
+	}
+
+	if errors.Is(err, database.ErrInvalidAmount) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&UpdateResponse{UpdateStatus: err.Error()})
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't top up the wallet")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	switch topUp.Status {
+	case database.PaymentPending:
+		w.WriteHeader(http.StatusAccepted)
+	case database.PaymentFailed:
+		w.WriteHeader(http.StatusPaymentRequired)
+	}
+
+	_ = json.NewEncoder(w).Encode(topUp)
+}
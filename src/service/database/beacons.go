@@ -1,13 +1,368 @@
 package database
 
-// Get a list of all the beacons
-func (db *appdbimpl) GetBeaconList() *[]string {
-	beacons := make([]string, 0)
-	for _, station := range db.Stations {
-		beacons = append(beacons, station.BeaconID)
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+const (
+	BeaconStation  string = "station"
+	BeaconPlatform        = "platform"
+	BeaconTrainCar        = "train_car"
+	BeaconVehicle         = "vehicle"
+)
+
+const (
+	BeaconActive   string = "active"
+	BeaconInactive        = "inactive"
+	BeaconRetired         = "retired"
+)
+
+var (
+	// ErrBeaconNotFound is returned when the beacon is not in the registry
+	ErrBeaconNotFound = errors.New("Beacon not found")
+
+	// ErrBeaconExists is returned when registering a beacon ID that is already taken
+	ErrBeaconExists = errors.New("Beacon already registered")
+
+	// ErrBeaconRetired is returned when changing a retired beacon
+	ErrBeaconRetired = errors.New("Beacon retired")
+
+	// ErrInvalidBeaconType is returned when the type of the beacon is unknown
+	ErrInvalidBeaconType = errors.New("The beacon type must be station, platform, train_car or vehicle")
+
+	// ErrBeaconOwnerNotFound is returned when the station or the train owning the beacon does not exist
+	ErrBeaconOwnerNotFound = errors.New("Beacon owner not found")
+
+	// ErrInvalidBeaconPlacement is returned when a platform beacon has no platform, or a train car (or vehicle) beacon
+	// has no car
+	ErrInvalidBeaconPlacement = errors.New("Platform beacons need a platform, train car and vehicle beacons need a car")
+
+	// ErrInvalidBeaconStatus is returned when the new status of a beacon is not active or inactive
+	ErrInvalidBeaconStatus = errors.New("The beacon status must be active or inactive")
+
+	// ErrInvalidInstallDate is returned when the install date is not a valid date (YYYY-MM-DD)
+	ErrInvalidInstallDate = errors.New("Invalid install date")
+)
+
+// BeaconOwner is where a beacon is placed: a station (or one of its platforms), a car of a train or a car of a vehicle
+type BeaconOwner struct {
+	Type     string `json:"type"`
+	Owner    string `json:"owner"`
+	Car      int    `json:"car"`
+	Platform int    `json:"platform"`
+}
+
+type Beacon struct {
+	ID string `json:"id"`
+	BeaconOwner
+	Status      string `json:"status"`
+	Rotating    bool   `json:"rotating"`
+	InstalledAt string `json:"installed_at"`
+	RetiredAt   string `json:"retired_at"`
+}
+
+// BeaconFilter selects the beacons by type, owner and status. Empty fields match any beacon.
+type BeaconFilter struct {
+	Type   string
+	Owner  string
+	Status string
+}
+
+// Build the registry from the beacons of the stations and the trains, for databases created before the registry was
+// introduced
+func beaconsFromPositions(stations []Station, trains []Train) map[string]*Beacon {
+	beacons := make(map[string]*Beacon)
+	for _, station := range stations {
+		if station.BeaconID != "" {
+			beacons[station.BeaconID] = &Beacon{
+				ID:          station.BeaconID,
+				BeaconOwner: BeaconOwner{Type: BeaconStation, Owner: station.Name},
+				Status:      BeaconActive,
+			}
+		}
+	}
+	for _, train := range trains {
+		if train.BeaconID != "" {
+			beacons[train.BeaconID] = &Beacon{
+				ID:          train.BeaconID,
+				BeaconOwner: BeaconOwner{Type: BeaconTrainCar, Owner: train.ID, Car: 1},
+				Status:      BeaconActive,
+			}
+		}
+	}
+	return beacons
+}
+
+// Get the beacons of the registry, sorted by owner and ID
+func (db *appdbimpl) GetBeacons(filter BeaconFilter) []Beacon {
+	beacons := make([]Beacon, 0)
+	for _, beacon := range db.Beacons {
+		if filter.Type != "" && beacon.Type != filter.Type {
+			continue
+		}
+		if filter.Owner != "" && beacon.Owner != filter.Owner {
+			continue
+		}
+		if filter.Status != "" && beacon.Status != filter.Status {
+			continue
+		}
+		beacons = append(beacons, *beacon)
+	}
+
+	sort.Slice(beacons, func(i, j int) bool {
+		if beacons[i].Owner != beacons[j].Owner {
+			return beacons[i].Owner < beacons[j].Owner
+		}
+		return beacons[i].ID < beacons[j].ID
+	})
+
+	return beacons
+}
+
+// Get an active beacon of the registry, or nil if the beacon is unknown, inactive or retired
+func (db *appdbimpl) getActiveBeacon(beaconID string) *Beacon {
+	beacon, found := db.Beacons[beaconID]
+	if !found || beacon.Status != BeaconActive {
+		return nil
+	}
+	return beacon
+}
+
+// Check the placement of a beacon, replacing the owner with the name of the station or the ID of the train
+func (db *appdbimpl) checkBeaconOwner(owner *BeaconOwner) error {
+	switch owner.Type {
+	case BeaconStation, BeaconPlatform:
+		station, err := db.GetStationByID(owner.Owner)
+		if err != nil {
+			return ErrBeaconOwnerNotFound
+		}
+		owner.Owner = station.Name
+		owner.Car = 0
+		if owner.Type == BeaconStation {
+			owner.Platform = 0
+		} else if owner.Platform <= 0 {
+			return ErrInvalidBeaconPlacement
+		}
+	case BeaconTrainCar:
+		train, err := db.getTrainByID(owner.Owner)
+		if err != nil {
+			return ErrBeaconOwnerNotFound
+		}
+		owner.Owner = train.ID
+		owner.Platform = 0
+		if owner.Car <= 0 {
+			return ErrInvalidBeaconPlacement
+		}
+	case BeaconVehicle:
+		vehicle, found := db.Vehicles[owner.Owner]
+		if !found {
+			return ErrBeaconOwnerNotFound
+		}
+		owner.Platform = 0
+		if owner.Car <= 0 || (vehicle.Cars > 0 && owner.Car > vehicle.Cars) {
+			return ErrInvalidBeaconPlacement
+		}
+	default:
+		return ErrInvalidBeaconType
+	}
+	return nil
+}
+
+// Check an install date (YYYY-MM-DD), defaulting to today
+func checkInstallDate(date string) (string, error) {
+	if date == "" {
+		return time.Now().Format("2006-01-02"), nil
+	}
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		return "", ErrInvalidInstallDate
+	}
+	return date, nil
+}
+
+// Get the beacon shown to the clients for each station (and train), by type and owner: its oldest active station (or
+// train car) beacon
+func (db *appdbimpl) primaryBeacons() map[string]string {
+	beacons := db.GetBeacons(BeaconFilter{Status: BeaconActive})
+	sort.SliceStable(beacons, func(i, j int) bool {
+		if beacons[i].Car != beacons[j].Car {
+			return beacons[i].Car < beacons[j].Car
+		}
+		return beacons[i].InstalledAt < beacons[j].InstalledAt
+	})
+
+	primary := make(map[string]string)
+	for _, beacon := range beacons {
+		if beacon.Type == BeaconPlatform {
+			continue
+		}
+		if _, found := primary[beacon.Type+"/"+beacon.Owner]; !found {
+			primary[beacon.Type+"/"+beacon.Owner] = beacon.ID
+		}
 	}
-	for _, train := range db.Trains {
-		beacons = append(beacons, train.BeaconID)
+
+	return primary
+}
+
+// Fill the beacon IDs stored on the stations and the trains, and on their copies in the trips, in the positions of
+// the users and in the payments, with their primary beacons in the registry. The stored IDs are only read to build the
+// registry of the databases created before it: the registry is the source of truth, and the stored IDs are filled in
+// again after every change of the registry, so that every response shows the same beacon for a station (or a train).
+func (db *appdbimpl) syncPositionBeacons() {
+	primary := db.primaryBeacons()
+	syncStation := func(station *Station) {
+		if station != nil {
+			station.BeaconID = primary[BeaconStation+"/"+station.Name]
+		}
+	}
+	syncTrain := func(train *Train) {
+		if train == nil {
+			return
+		}
+		train.BeaconID = primary[BeaconTrainCar+"/"+train.ID]
+		if train.Trip != nil {
+			for j := range *train.Trip {
+				syncStation((*train.Trip)[j].Station)
+			}
+		}
+	}
+
+	for i := range db.Stations {
+		syncStation(&db.Stations[i])
 	}
-	return &beacons
-}
\ No newline at end of file
+	for i := range db.Trains {
+		syncTrain(&db.Trains[i])
+	}
+	for _, state := range db.UserStates {
+		syncTrain(state.Train)
+		syncStation(state.Station)
+	}
+	for _, history := range db.PaymentHistory {
+		for i := range history {
+			syncStation(history[i].FromStation)
+			syncStation(history[i].ToStation)
+		}
+	}
+}
+
+// Save the registry after a change
+func (db *appdbimpl) writeBeacon(beacon *Beacon) (*Beacon, error) {
+	db.syncPositionBeacons()
+
+	err := db.Write()
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := *beacon
+	return &result, nil
+}
+
+// Register a new beacon. If no ID is given, a new one is generated.
+func (db *appdbimpl) CreateBeacon(beaconID string, owner BeaconOwner, installedAt string) (*Beacon, error) {
+	if beaconID == "" {
+		id, err := uuid.NewV4()
+		if err != nil {
+			return nil, err
+		}
+		beaconID = id.String()
+	}
+
+	if _, found := db.Beacons[beaconID]; found {
+		return nil, ErrBeaconExists
+	}
+
+	if err := db.checkBeaconOwner(&owner); err != nil {
+		return nil, err
+	}
+
+	installedAt, err := checkInstallDate(installedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	beacon := &Beacon{
+		ID:          beaconID,
+		BeaconOwner: owner,
+		Status:      BeaconActive,
+		InstalledAt: installedAt,
+	}
+	db.Beacons[beaconID] = beacon
+
+	return db.writeBeacon(beacon)
+}
+
+// Get a beacon that can be changed
+func (db *appdbimpl) getChangeableBeacon(beaconID string) (*Beacon, error) {
+	beacon, found := db.Beacons[beaconID]
+	if !found {
+		return nil, ErrBeaconNotFound
+	}
+	if beacon.Status == BeaconRetired {
+		return nil, ErrBeaconRetired
+	}
+	return beacon, nil
+}
+
+// Update the status (active or inactive) and the install date of a beacon. Empty fields are left unchanged.
+func (db *appdbimpl) UpdateBeacon(beaconID string, status string, installedAt string) (*Beacon, error) {
+	beacon, err := db.getChangeableBeacon(beaconID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
+	case "", BeaconActive, BeaconInactive:
+	default:
+		return nil, ErrInvalidBeaconStatus
+	}
+
+	if installedAt != "" {
+		if installedAt, err = checkInstallDate(installedAt); err != nil {
+			return nil, err
+		}
+		beacon.InstalledAt = installedAt
+	}
+	if status != "" {
+		beacon.Status = status
+	}
+
+	return db.writeBeacon(beacon)
+}
+
+// Move a beacon to another station, platform or train car
+func (db *appdbimpl) ReassignBeacon(beaconID string, owner BeaconOwner) (*Beacon, error) {
+	beacon, err := db.getChangeableBeacon(beaconID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.checkBeaconOwner(&owner); err != nil {
+		return nil, err
+	}
+
+	beacon.BeaconOwner = owner
+
+	return db.writeBeacon(beacon)
+}
+
+// Retire a beacon for good. A retired beacon doesn't locate anyone anymore, and it can't be changed.
+func (db *appdbimpl) RetireBeacon(beaconID string) (*Beacon, error) {
+	beacon, err := db.getChangeableBeacon(beaconID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	beacon.Status = BeaconRetired
+	beacon.RetiredAt = time.Now().Format(time.RFC3339)
+
+	return db.writeBeacon(beacon)
+}
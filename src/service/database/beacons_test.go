@@ -0,0 +1,55 @@
+package database
+
+import "testing"
+
+// positionBeacons gets the beacon IDs of Napoli Centrale and FR9422 in every copy that is sent to the clients: the
+// stations, the trains and their trips, and the position of alice
+func positionBeacons(t *testing.T, db *appdbimpl) []string {
+	t.Helper()
+
+	state := db.GetUserPosition("alice")
+	if state.Train == nil || state.Station == nil {
+		t.Fatalf("position = %+v, want alice on FR9422 in Napoli Centrale", state)
+	}
+
+	return []string{
+		(*db.GetStations("Napoli Centrale"))[0].BeaconID,
+		(*(*db.GetTrains("FR9422"))[0].Trip)[0].Station.BeaconID,
+		(*state.Train.Trip)[0].Station.BeaconID,
+		state.Station.BeaconID,
+	}
+}
+
+func TestSyncPositionBeacons(t *testing.T) {
+	db := boardedAndLoaded(t, nil)
+
+	station := testBeacon(t, db, BeaconStation, "Napoli Centrale")
+	for i, got := range positionBeacons(t, db) {
+		if got != station {
+			t.Errorf("beacon %d = %q, want the one of the registry %q", i, got, station)
+		}
+	}
+	if got, want := db.GetUserPosition("alice").Train.BeaconID, testBeacon(t, db, BeaconTrainCar, "FR9422"); got != want {
+		t.Errorf("train beacon = %q, want the one of the registry %q", got, want)
+	}
+
+	// the copies follow the changes of the registry
+	if _, err := db.UpdateBeacon(station, BeaconInactive, ""); err != nil {
+		t.Fatalf("UpdateBeacon() error = %v", err)
+	}
+	for i, got := range positionBeacons(t, db) {
+		if got != "" {
+			t.Errorf("beacon %d = %q after the beacon is disabled, want none", i, got)
+		}
+	}
+
+	owner := BeaconOwner{Type: BeaconStation, Owner: "Napoli Centrale"}
+	if _, err := db.CreateBeacon("replacement", owner, ""); err != nil {
+		t.Fatalf("CreateBeacon() error = %v", err)
+	}
+	for i, got := range positionBeacons(t, db) {
+		if got != "replacement" {
+			t.Errorf("beacon %d = %q after the beacon is replaced, want %q", i, got, "replacement")
+		}
+	}
+}
@@ -31,11 +31,15 @@ Then you can initialize the AppDatabase and pass it to the api package.
 package database
 
 import (
+	"crypto/ed25519"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"time"
+
+	"github.com/ami-sc/DajeTrains/service/payments"
 )
 
 // AppDatabase is the high level interface for the DB
@@ -43,30 +47,150 @@ type AppDatabase interface {
 	GetStations(filter string) *[]Station
 	GetTrains(filter string) *[]Train
 	GetStationByBeaconID(beaconID string) *Station
-	GetTrainByBeaconID(beaconID string) *Train
-	UpdateUserPosition(userID string, beaconID string) (*UpdateUserPositionResponse, error)
+	GetTrainByBeaconID(beaconID string, at time.Time) *Train
+	UpdateUserPosition(userID string, sightings []BeaconSighting) (*UpdateUserPositionResponse, error)
 	GetUserPosition(userID string) *UserState
+	GetTrips(userID string) []Trip
+	GetTripProgress(userID string) *TripProgress
+	ReapStaleSessions(now time.Time) ([]ClosedSession, error)
+	ProcessPositionEvents(userID string, events []PositionEvent) (*PositionEventsResponse, error)
+	CheckIn(userID string, trainID string, declaration StationDeclaration) (*UpdateUserPositionResponse, error)
+	CheckOut(userID string, declaration StationDeclaration) (*UpdateUserPositionResponse, error)
+
+	UpdateTrainPosition(trainID string, stationID string, status string, time_string string, platform int) ([]TrainEvent, error)
+	ResetTrainPosition(trainID string) ([]ClosedSession, error)
+	GetPaymentHistory(userID string, filter PaymentHistoryFilter) ([]PaymentResponse, string, error)
+	RefundPayment(userID string, paymentID string) (*PaymentResponse, error)
+	WriteOffPayment(userID string, paymentID string) (*PaymentResponse, error)
+	GetReceipt(userID string, receiptNumber string) (*PaymentResponse, error)
+
+	GetWallet(userID string) *WalletResponse
+	StartTopUp(userID string, amount int64, idempotencyKey string) (*TopUp, bool, error)
+	ChargeTopUp(userID string, topUp TopUp) TopUp
+	CompleteTopUp(userID string, topUp TopUp) (*TopUp, error)
+	RefundTopUp(userID string, topUpID string) (*TopUp, error)
 
-	UpdateTrainPosition(trainID string, stationID string, status string, time_string string) error
-	ResetTrainPosition(trainID string) error
-	GetPaymentHistory(userID string) ([]PaymentResponse, error)
+	GetJournal(from time.Time, to time.Time) []JournalEntry
+	GetTrialBalance() *TrialBalance
 
-	ValidateTicket(ticketID string) (string, error)
+	ValidateTicket(code string) (*Ticket, error)
+	RevokeTicket(ticketID string) error
+	GetRevokedTickets() []RevokedTicket
+	GetTicketPublicKey() *TicketPublicKey
+	GetSignedTicket(code string) (string, error)
+	IsTicketHolder(userID string, code string) bool
+	GetTicketManifest(trainID string, since int64) (*TicketManifest, error)
+
+	InspectTicket(inspector string, trainID string, code string) (*Inspection, error)
+	IssueFine(inspectionID string, passengerID string) (*PaymentResponse, error)
+	GetInspectionStats(from time.Time, to time.Time) []TrainInspectionStats
 
 	GetStationDepartures(stationID string) (*[]StationTimetableItem, error)
 	GetStationArrivals(stationID string) (*[]StationTimetableItem, error)
+	GetStationCodes(stationID string, platforms bool, from time.Time) ([]StationCode, error)
+
+	GetBeacons(filter BeaconFilter) []Beacon
+	CreateBeacon(beaconID string, owner BeaconOwner, installedAt string) (*Beacon, error)
+	UpdateBeacon(beaconID string, status string, installedAt string) (*Beacon, error)
+	ReassignBeacon(beaconID string, owner BeaconOwner) (*Beacon, error)
+	RetireBeacon(beaconID string) (*Beacon, error)
+	GetVehicles() []Vehicle
+	CreateVehicle(vehicle Vehicle) (*Vehicle, error)
+	GetVehicleAssignments(date string) ([]VehicleAssignment, error)
+	AssignVehicles(date string, trainID string, vehicles []string) (*VehicleAssignment, error)
+
+	GetSuspiciousEvents(status string) []SuspiciousEvent
+	ReviewSuspiciousEvent(eventID string, reviewer string, status string, note string) (*SuspiciousEvent, error)
+	EnableBeaconRotation(beaconID string) (*BeaconKey, error)
+	DisableBeaconRotation(beaconID string) (*Beacon, error)
+
+	CreateUser(username string, passwordHash string, passwordSalt string) (*User, error)
+	GetUser(username string) (*User, error)
+	SetUserRole(username string, role string, lines []string) (*User, error)
+	IsOperatorOfTrain(username string, trainID string) bool
+}
+
+// Config is used to provide dependencies and configuration to the Load and NewDatabase functions.
+type Config struct {
+	// PaymentProvider is the provider used to charge the passengers when they top up their wallet
+	PaymentProvider payments.Provider
+
+	// PaymentTimeout is the maximum duration of a single call to the payment provider
+	PaymentTimeout time.Duration
+
+	// VATRate is the VAT rate included in the fares (e.g., 0.10 for 10%)
+	VATRate float64
 
-	GetBeaconList() *[]string
+	// TicketSigningKey is the key used to sign the tickets, so that they can be verified offline
+	TicketSigningKey ed25519.PrivateKey
+
+	// TicketSigningKeyGenerated tells that TicketSigningKey has been generated at startup instead of being configured.
+	// Station codes are not issued with such a key: they are printed, and would be invalid after a restart.
+	TicketSigningKeyGenerated bool
+
+	// FineAmount is the amount (in euro cents) of the fine for travelling without a valid ticket
+	FineAmount int64
+
+	// Positioning are the rules used to resolve the position of a user from the beacons heard by their phone
+	Positioning PositioningRules
+
+	// BeaconRotationPeriod is how long a rotating beacon broadcasts the same ID
+	BeaconRotationPeriod time.Duration
+
+	// BeaconClockSkew is the clock skew tolerated between the server and the rotating beacons. A negative value
+	// tolerates none.
+	BeaconClockSkew time.Duration
+
+	// Fraud are the thresholds of the checks on the movements of the passengers
+	Fraud FraudRules
+
+	// Sessions are the rules used to close the in-train sessions of the passengers whose phone went silent
+	Sessions SessionRules
+
+	// StationCodeValidity is how long the signed codes printed in the stations are valid
+	StationCodeValidity time.Duration
 }
 
 // JSON database implementation
 type appdbimpl struct {
 	filename       string
+	cfg            Config
 	Stations       []Station
 	Trains         []Train
 	UserStates     map[string]*UserState
 	PaymentHistory map[string][]PaymentResponse
-	ValidTickets   map[string]string
+	Tickets        map[string]*Ticket
+	TicketVersion  int64
+	Wallets        map[string]*Wallet
+	Journal        []JournalEntry
+	LastReceipt    int64
+	Users          map[string]*User
+	Inspections    []Inspection
+	EventCursors   map[string]*EventCursor
+	Beacons        map[string]*Beacon
+	BeaconKeys     map[string]string
+	Vehicles       map[string]*Vehicle
+	Assignments    []VehicleAssignment
+
+	SuspiciousEvents []SuspiciousEvent
+	Trips            map[string][]Trip
+
+	// scans are the recent beacon scans of each user. They are only kept in memory.
+	scans map[string][]beaconScan
+
+	// pending are the moves of each user that are not committed yet. They are only kept in memory.
+	pending map[string]*pendingMove
+
+	// fixes are the last station each user has been seen in. They are only kept in memory: after a restart, the speed
+	// of the first move of each user is not checked.
+	fixes map[string]stationFix
+
+	// charging are the IDs of the top ups being charged through the payment provider. They are only kept in memory.
+	charging map[string]bool
+
+	// ValidTickets and RevokedTickets are only read from databases created before the tickets were introduced
+	ValidTickets   map[string]string        `json:",omitempty"`
+	RevokedTickets map[string]RevokedTicket `json:",omitempty"`
 }
 
 type Location struct {
@@ -93,6 +217,7 @@ type TrainTripItem struct {
 type Train struct {
 	ID        string           `json:"id"`
 	BeaconID  string           `json:"beacon_id"`
+	Line      string           `json:"line"`
 	LastDelay int              `json:"last_delay"`
 	Trip      *[]TrainTripItem `json:"trip"`
 }
@@ -104,28 +229,69 @@ const (
 )
 
 type UserState struct {
-	Status  string   `json:"status"`
-	Train   *Train   `json:"train"`
-	Station *Station `json:"station"`
+	Status   string   `json:"status"`
+	Train    *Train   `json:"train"`
+	Station  *Station `json:"station"`
+	TicketID string   `json:"ticket_id"`
+	Source   string   `json:"source"`
+}
+
+const (
+	PaymentPending    string = "pending"
+	PaymentCaptured          = "captured"
+	PaymentFailed            = "failed"
+	PaymentRefunded          = "refunded"
+	PaymentUnpaid            = "unpaid"
+	PaymentWrittenOff        = "written_off"
+)
+
+// VATBreakdown splits the amount of a payment in net amount and VAT. Amounts are in euro cents.
+type VATBreakdown struct {
+	Rate  float64 `json:"rate"`
+	Net   int64   `json:"net"`
+	VAT   int64   `json:"vat"`
+	Gross int64   `json:"gross"`
 }
 
 type PaymentResponse struct {
-	Cost                   float64  `json:"cost"`
-	TrainID                string   `json:"train_id"`
-	FromStation            *Station `json:"from_station"`
-	ToStation              *Station `json:"to_station"`
-	DepartureTime          string   `json:"departure_time"`
-	ArrivalTime            string   `json:"arrival_time"`
-	ScheduledDepartureTime string   `json:"scheduled_departure_time"`
-	ScheduledArrivalTime   string   `json:"scheduled_arrival_time"`
-	Date                   string   `json:"date"`
+	ID                     string        `json:"id"`
+	Type                   string        `json:"type"`
+	ReceiptNumber          string        `json:"receipt_number"`
+	Status                 string        `json:"status"`
+	IdempotencyKey         string        `json:"idempotency_key"`
+	AuthorizationID        string        `json:"authorization_id"`
+	FailureReason          string        `json:"failure_reason"`
+	Cost                   float64       `json:"cost"`
+	TrainID                string        `json:"train_id"`
+	FromStation            *Station      `json:"from_station"`
+	ToStation              *Station      `json:"to_station"`
+	DepartureTime          string        `json:"departure_time"`
+	ArrivalTime            string        `json:"arrival_time"`
+	ScheduledDepartureTime string        `json:"scheduled_departure_time"`
+	ScheduledArrivalTime   string        `json:"scheduled_arrival_time"`
+	Date                   string        `json:"date"`
+	VAT                    *VATBreakdown `json:"vat"`
 }
 
+const (
+	PaymentTypeFare string = "fare"
+	PaymentTypeFine        = "fine"
+)
+
+const (
+	WarningUnpaidDebt string = "unpaid_debt"
+)
+
 type UpdateUserPositionResponse struct {
 	Status          string           `json:"status"`
 	ID              string           `json:"id"`
 	PaymentResponse *PaymentResponse `json:"payment_response"`
 	TicketCode      string           `json:"ticket_code"`
+	SignedTicket    string           `json:"signed_ticket"`
+	Warning         string           `json:"warning"`
+	Debt            int64            `json:"debt"`
+	PendingStatus   string           `json:"pending_status"`
+	Rejected        int              `json:"rejected"`
 }
 
 type StationTimetableItem struct {
@@ -139,10 +305,16 @@ type StationTimetableItem struct {
 }
 
 // Load loads a database from a file
-func Load(file string) (AppDatabase, error) {
+func Load(file string, cfg Config) (AppDatabase, error) {
 	if file == "" {
 		return nil, errors.New("No file path provided")
 	}
+	if cfg.PaymentProvider == nil {
+		return nil, errors.New("payment provider is required")
+	}
+	if len(cfg.TicketSigningKey) != ed25519.PrivateKeySize {
+		return nil, errors.New("a valid ticket signing key is required")
+	}
 
 	// read file
 	jsonFile, err := os.Open(file)
@@ -168,10 +340,99 @@ func Load(file string) (AppDatabase, error) {
 	}
 
 	db.filename = file
+	db.cfg = cfg
+
+	// databases created before wallets, the ledger, the users, the tickets, the inspections, the position events, the beacon registry, the vehicles, the fraud checks and the trips were introduced
+	if db.Tickets == nil {
+		db.Tickets = make(map[string]*Ticket)
+	}
+	for ticketID, trainID := range db.ValidTickets {
+		db.Tickets[ticketID] = &Ticket{ID: ticketID, TrainID: trainID, Status: TicketActive}
+	}
+	for ticketID, revoked := range db.RevokedTickets {
+		db.Tickets[ticketID] = &Ticket{ID: ticketID, TrainID: revoked.TrainID, Status: TicketRevoked, StatusChangedAt: revoked.RevokedAt}
+	}
+	db.ValidTickets = nil
+	db.RevokedTickets = nil
+	// the migrated tickets, and the ones stored before the manifests were introduced, get a version so that the full
+	// manifests list them
+	for _, ticket := range db.Tickets {
+		if ticket.Version == 0 {
+			db.touchTicket(ticket)
+		}
+	}
+	for _, history := range db.PaymentHistory {
+		for i := range history {
+			if history[i].Type == "" {
+				history[i].Type = PaymentTypeFare
+			}
+		}
+	}
+	if db.Inspections == nil {
+		db.Inspections = make([]Inspection, 0)
+	}
+	if db.Wallets == nil {
+		db.Wallets = make(map[string]*Wallet)
+	}
+	if db.Journal == nil {
+		db.Journal = make([]JournalEntry, 0)
+	}
+	if db.Users == nil {
+		db.Users = make(map[string]*User)
+	}
+	if db.EventCursors == nil {
+		db.EventCursors = make(map[string]*EventCursor)
+	}
+	if db.Beacons == nil {
+		db.Beacons = beaconsFromPositions(db.Stations, db.Trains)
+	}
+	if db.BeaconKeys == nil {
+		db.BeaconKeys = make(map[string]string)
+	}
+	if db.Vehicles == nil {
+		db.Vehicles = make(map[string]*Vehicle)
+	}
+	if db.Assignments == nil {
+		db.Assignments = make([]VehicleAssignment, 0)
+	}
+	if db.SuspiciousEvents == nil {
+		db.SuspiciousEvents = make([]SuspiciousEvent, 0)
+	}
+	if db.Trips == nil {
+		db.Trips = make(map[string][]Trip)
+	}
+	db.syncPositionBeacons()
+
+	// databases created before the lines were introduced
+	err = db.backfillTrainLines()
+
+	if err != nil {
+		return nil, err
+	}
 
 	return &db, nil
 }
 
+// Fill the missing lines of the trains with the ones of the fake data. A train without a line can't be updated by any
+// operator, so it is an error.
+func (db *appdbimpl) backfillTrainLines() error {
+	lines := make(map[string]string)
+	for _, train := range NewDatabase(db.filename, db.cfg).Trains {
+		lines[train.ID] = train.Line
+	}
+
+	for i := range db.Trains {
+		if db.Trains[i].Line == "" {
+			db.Trains[i].Line = lines[db.Trains[i].ID]
+		}
+		if db.Trains[i].Line == "" {
+			return fmt.Errorf("train %s has no line: set it in the database file", db.Trains[i].ID)
+		}
+	}
+
+	return nil
+}
+
 // Write changes to the database file
 func (db *appdbimpl) Write() error {
 
@@ -192,8 +453,44 @@ func (db *appdbimpl) Write() error {
 	return nil
 }
 
+// Encode the state of the database that is written to the file, so that a change failing halfway can be undone
+func (db *appdbimpl) snapshot() ([]byte, error) {
+	byteValue, err := json.Marshal(db)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error marshalling json: %w", err)
+	}
+
+	return byteValue, nil
+}
+
+// Undo a change of a user that failed halfway, going back to a snapshot of the database. The scans, the pending moves
+// and the last station of the user are only kept in memory: they are forgotten. It returns the error the change failed
+// with.
+func (db *appdbimpl) rollback(snapshot []byte, userID string, cause error) error {
+	var restored appdbimpl
+
+	if err := json.Unmarshal(snapshot, &restored); err != nil {
+		return fmt.Errorf("%w (can't undo the change: %v)", cause, err)
+	}
+
+	restored.filename, restored.cfg = db.filename, db.cfg
+	restored.scans, restored.pending, restored.fixes, restored.charging = db.scans, db.pending, db.fixes, db.charging
+	*db = restored
+
+	delete(db.scans, userID)
+	delete(db.pending, userID)
+	delete(db.fixes, userID)
+
+	if err := db.Write(); err != nil {
+		return fmt.Errorf("%w (can't write the undone change: %v)", cause, err)
+	}
+
+	return cause
+}
+
 // Creates a new database with fake data
-func NewDatabase(file string) *appdbimpl {
+func NewDatabase(file string, cfg Config) *appdbimpl {
 
 	stations := []Station{
 		{
@@ -334,13 +631,15 @@ func NewDatabase(file string) *appdbimpl {
 		},
 	}
 
-	return &appdbimpl{
+	db := &appdbimpl{
 		filename: file,
+		cfg:      cfg,
 		Stations: stations,
 		Trains: []Train{
 			{
 				ID:        "FR9422",
 				BeaconID:  "c29ce823-e67a-4e71-bff2-abaa32e77a98",
+				Line:      "AV Napoli-Bologna",
 				LastDelay: 0,
 				Trip: &[]TrainTripItem{
 					{
@@ -383,6 +682,7 @@ func NewDatabase(file string) *appdbimpl {
 			{
 				ID:       "IC774",
 				BeaconID: "d2d1fc1d-ec6e-4be2-bb0b-9f55956efac0",
+				Line:     "Ferrara-Venezia",
 				Trip: &[]TrainTripItem{
 					{
 						Station:                &stations[5],
@@ -417,6 +717,7 @@ func NewDatabase(file string) *appdbimpl {
 			{
 				ID:       "R18271",
 				BeaconID: "a50f90e0-1b9b-47bd-a89b-c6e5f0bd07d7",
+				Line:     "Ferrara-Venezia",
 				Trip: &[]TrainTripItem{
 					{
 						Station:                &stations[8],
@@ -451,6 +752,7 @@ func NewDatabase(file string) *appdbimpl {
 			{
 				ID:       "R18272",
 				BeaconID: "5737c92a-670d-40cf-a550-6a29335ed7f3",
+				Line:     "Ferrara-Venezia",
 				Trip: &[]TrainTripItem{
 					{
 						Station:                &stations[8],
@@ -485,6 +787,7 @@ func NewDatabase(file string) *appdbimpl {
 			{
 				ID:       "R19572",
 				BeaconID: "3b931c98-3a77-4add-b0a6-8c3087315fdf",
+				Line:     "Ferrara-Venezia",
 				Trip: &[]TrainTripItem{
 					{
 						Station:                &stations[8],
@@ -519,6 +822,7 @@ func NewDatabase(file string) *appdbimpl {
 			{
 				ID:       "R18273",
 				BeaconID: "358474d2-a0bb-4abc-8bcf-b018a0cd7c3c",
+				Line:     "Ferrara-Venezia",
 				Trip: &[]TrainTripItem{
 					{
 						Station:                &stations[8],
@@ -553,6 +857,7 @@ func NewDatabase(file string) *appdbimpl {
 			{
 				ID:       "R18274",
 				BeaconID: "a8980213-9cae-401f-96c2-fdaeb39d7d25",
+				Line:     "Ferrara-Venezia",
 				Trip: &[]TrainTripItem{
 					{
 						Station:                &stations[8],
@@ -588,6 +893,7 @@ func NewDatabase(file string) *appdbimpl {
 			{
 				ID:       "R12655",
 				BeaconID: "0a31fec3-37ed-452d-bbe7-e79327ad2a7b",
+				Line:     "FL7 Roma-Formia",
 				Trip: &[]TrainTripItem{
 					{
 						Station:                &stations[1],
@@ -657,6 +963,7 @@ func NewDatabase(file string) *appdbimpl {
 			{
 				ID:       "R12656",
 				BeaconID: "6fd2134d-6111-4bfb-86a1-9f8125c7737f",
+				Line:     "FL7 Roma-Formia",
 				Trip: &[]TrainTripItem{
 					{
 						Station:                &stations[1],
@@ -726,6 +1033,7 @@ func NewDatabase(file string) *appdbimpl {
 			{
 				ID:       "R12657",
 				BeaconID: "e1d58361-44b7-474b-a931-ccce764fa4de",
+				Line:     "FL7 Roma-Formia",
 				Trip: &[]TrainTripItem{
 					{
 						Station:                &stations[1],
@@ -795,6 +1103,7 @@ func NewDatabase(file string) *appdbimpl {
 			{
 				ID:       "R12658",
 				BeaconID: "78896392-ac2a-49cf-b588-dd2895e81233",
+				Line:     "FL7 Roma-Formia",
 				Trip: &[]TrainTripItem{
 					{
 						Station:                &stations[1],
@@ -864,6 +1173,7 @@ func NewDatabase(file string) *appdbimpl {
 			{
 				ID:       "R12659",
 				BeaconID: "12300685-ec19-46c1-ad75-f1da38d27523",
+				Line:     "FL7 Roma-Formia",
 				Trip: &[]TrainTripItem{
 					{
 						Station:                &stations[1],
@@ -933,6 +1243,7 @@ func NewDatabase(file string) *appdbimpl {
 			{
 				ID:       "R12660",
 				BeaconID: "b33e3f28-ab77-4e01-a472-587892cd3cc3",
+				Line:     "FL7 Roma-Formia",
 				Trip: &[]TrainTripItem{
 					{
 						Station:                &stations[1],
@@ -1002,6 +1313,7 @@ func NewDatabase(file string) *appdbimpl {
 			{
 				ID:       "R12661",
 				BeaconID: "9465c69f-5eca-4967-a609-d18eba98722c",
+				Line:     "FL7 Roma-Formia",
 				Trip: &[]TrainTripItem{
 					{
 						Station:                &stations[1],
@@ -1071,6 +1383,7 @@ func NewDatabase(file string) *appdbimpl {
 			{
 				ID:       "R12662",
 				BeaconID: "0da70b41-eee6-465e-8573-709b1d825b6a",
+				Line:     "FL7 Roma-Formia",
 				Trip: &[]TrainTripItem{
 					{
 						Station:                &stations[1],
@@ -1140,6 +1453,7 @@ func NewDatabase(file string) *appdbimpl {
 			{
 				ID:       "R12675",
 				BeaconID: "73195d6c-710b-4848-872e-c5eb88fe03dc",
+				Line:     "FL7 Roma-Formia",
 				Trip: &[]TrainTripItem{
 					{
 						Station:                &stations[1],
@@ -1181,6 +1495,7 @@ func NewDatabase(file string) *appdbimpl {
 			{
 				ID:       "R12676",
 				BeaconID: "a380a811-809b-4198-96a3-80b05201768a",
+				Line:     "FL7 Roma-Formia",
 				Trip: &[]TrainTripItem{
 					{
 						Station:                &stations[1],
@@ -1222,6 +1537,7 @@ func NewDatabase(file string) *appdbimpl {
 			{
 				ID:       "R12697",
 				BeaconID: "7f1f4c5a-e3a3-40dd-b983-ec54f70f1be5",
+				Line:     "FL7 Roma-Formia",
 				Trip: &[]TrainTripItem{
 					{
 						Station:                &stations[1],
@@ -1263,6 +1579,7 @@ func NewDatabase(file string) *appdbimpl {
 			{
 				ID:       "R12677",
 				BeaconID: "c64b7c7b-ddca-4bc9-a1d1-40d7dc0e5559",
+				Line:     "FL7 Roma-Formia",
 				Trip: &[]TrainTripItem{
 					{
 						Station:                &stations[1],
@@ -1304,6 +1621,7 @@ func NewDatabase(file string) *appdbimpl {
 			{
 				ID:       "R12678",
 				BeaconID: "9a5e1634-f656-453b-a7a1-be230ee30223",
+				Line:     "FL7 Roma-Formia",
 				Trip: &[]TrainTripItem{
 					{
 						Station:                &stations[1],
@@ -1345,6 +1663,7 @@ func NewDatabase(file string) *appdbimpl {
 			{
 				ID:       "R12679",
 				BeaconID: "cb06e49a-d2ea-4fa3-8dd8-1d14b02ffd43",
+				Line:     "FL7 Roma-Formia",
 				Trip: &[]TrainTripItem{
 					{
 						Station:                &stations[1],
@@ -1386,6 +1705,20 @@ func NewDatabase(file string) *appdbimpl {
 		},
 		UserStates:     make(map[string]*UserState),
 		PaymentHistory: make(map[string][]PaymentResponse),
-		ValidTickets:   make(map[string]string),
+		Tickets:        make(map[string]*Ticket),
+		Wallets:        make(map[string]*Wallet),
+		Journal:        make([]JournalEntry, 0),
+		Users:          make(map[string]*User),
+		Inspections:    make([]Inspection, 0),
+		EventCursors:   make(map[string]*EventCursor),
 	}
+	db.Beacons = beaconsFromPositions(db.Stations, db.Trains)
+	db.syncPositionBeacons()
+	db.BeaconKeys = make(map[string]string)
+	db.Vehicles = make(map[string]*Vehicle)
+	db.Assignments = make([]VehicleAssignment, 0)
+	db.SuspiciousEvents = make([]SuspiciousEvent, 0)
+	db.Trips = make(map[string][]Trip)
+
+	return db
 }
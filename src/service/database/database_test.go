@@ -0,0 +1,152 @@
+package database
+
+import (
+	"crypto/ed25519"
+	"path/filepath"
+	"testing"
+
+	"github.com/ami-sc/DajeTrains/service/payments"
+)
+
+// newTestDatabase creates a database with the fake data, stored in a temporary directory, charging through the given
+// payment provider (an approving fake one if nil)
+func newTestDatabase(t *testing.T, provider payments.Provider) *appdbimpl {
+	t.Helper()
+
+	if provider == nil {
+		fake, err := payments.NewFake(payments.FakeConfig{Mode: payments.FakeApprove})
+		if err != nil {
+			t.Fatalf("can't create the payment provider: %v", err)
+		}
+		provider = fake
+	}
+
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("can't generate the ticket signing key: %v", err)
+	}
+
+	return NewDatabase(filepath.Join(t.TempDir(), "db.json"), Config{
+		PaymentProvider:  provider,
+		TicketSigningKey: key,
+	})
+}
+
+// testTrain gets a train of the fake data, failing the test if it does not exist
+func testTrain(t *testing.T, db *appdbimpl, trainID string) *Train {
+	t.Helper()
+
+	train, err := db.getTrainByID(trainID)
+	if err != nil {
+		t.Fatalf("can't get train %s: %v", trainID, err)
+	}
+	return train
+}
+
+// testStation gets a station of the fake data, failing the test if it does not exist
+func testStation(t *testing.T, db *appdbimpl, name string) *Station {
+	t.Helper()
+
+	station, err := db.GetStationByID(name)
+	if err != nil {
+		t.Fatalf("can't get station %s: %v", name, err)
+	}
+	return station
+}
+
+// testBeacon gets the ID of the first beacon of a station or a train of the fake data, failing the test if there is none
+func testBeacon(t *testing.T, db *appdbimpl, beaconType string, owner string) string {
+	t.Helper()
+
+	beacons := db.GetBeacons(BeaconFilter{Type: beaconType, Owner: owner})
+	if len(beacons) == 0 {
+		t.Fatalf("%s %s has no beacon", beaconType, owner)
+	}
+	return beacons[0].ID
+}
+
+// moveTestTrain updates the position of a train of the fake data, failing the test if the update is refused
+func moveTestTrain(t *testing.T, db *appdbimpl, trainID string, station string, status string, at string) {
+	t.Helper()
+
+	if _, err := db.UpdateTrainPosition(trainID, station, status, at, 0); err != nil {
+		t.Fatalf("can't move train %s (%s %s): %v", trainID, status, station, err)
+	}
+}
+
+func TestLoadBackfillsTrainLines(t *testing.T) {
+	db := newTestDatabase(t, nil)
+
+	// a database created before the lines were introduced
+	for i := range db.Trains {
+		db.Trains[i].Line = ""
+	}
+	if err := db.Write(); err != nil {
+		t.Fatalf("can't write the database: %v", err)
+	}
+
+	loaded, err := Load(db.filename, db.cfg)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	trains := loaded.(*appdbimpl).Trains
+	for _, train := range trains {
+		if train.Line == "" {
+			t.Errorf("train %s has no line", train.ID)
+		}
+	}
+	if trains[0].Line != "AV Napoli-Bologna" {
+		t.Errorf("train %s line = %q, want %q", trains[0].ID, trains[0].Line, "AV Napoli-Bologna")
+	}
+
+	// a train that is not in the fake data can't be given a line
+	db.Trains = append(db.Trains, Train{ID: "R99999", Trip: db.Trains[0].Trip})
+	if err := db.Write(); err != nil {
+		t.Fatalf("can't write the database: %v", err)
+	}
+
+	if _, err := Load(db.filename, db.cfg); err == nil {
+		t.Errorf("Load() accepted a train without a line")
+	}
+}
+
+func TestLoadVersionsMigratedTickets(t *testing.T) {
+	db := newTestDatabase(t, nil)
+
+	// a database created before the tickets were introduced
+	db.ValidTickets = map[string]string{"valid-ticket": "FR9422"}
+	db.RevokedTickets = map[string]RevokedTicket{"revoked-ticket": {TrainID: "FR9422"}}
+	if err := db.Write(); err != nil {
+		t.Fatalf("can't write the database: %v", err)
+	}
+
+	loaded, err := Load(db.filename, db.cfg)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	tickets := loaded.(*appdbimpl).Tickets
+	if len(tickets) != 2 {
+		t.Fatalf("got %d tickets, want 2", len(tickets))
+	}
+	for id, ticket := range tickets {
+		if ticket.Version == 0 {
+			t.Errorf("ticket %s has no version", id)
+		}
+	}
+	if a, b := tickets["valid-ticket"].Version, tickets["revoked-ticket"].Version; a == b {
+		t.Errorf("the tickets have the same version %d", a)
+	}
+
+	manifest, err := loaded.GetTicketManifest("FR9422", 0)
+	if err != nil {
+		t.Fatalf("GetTicketManifest() error = %v", err)
+	}
+	if len(manifest.Tickets) != 1 || manifest.Tickets[0].ID != "valid-ticket" {
+		t.Errorf("manifest tickets = %+v, want the valid ticket", manifest.Tickets)
+	}
+	if manifest.Version == 0 {
+		t.Errorf("the manifest has no version")
+	}
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Default rotation of the beacon IDs, used when no rotation has been configured
+const (
+	defaultBeaconRotationPeriod time.Duration = 15 * time.Minute
+	defaultBeaconClockSkew      time.Duration = 2 * time.Minute
+)
+
+// beaconKeySize is the size (in bytes) of the secret key of a rotating beacon
+const beaconKeySize = 32
+
+// ErrBeaconNotRotating is returned when disabling the rotation of a beacon that broadcasts its static ID
+var ErrBeaconNotRotating = errors.New("The beacon does not rotate its ID")
+
+// BeaconKey is the secret key of a rotating beacon, to be loaded in the beacon. The beacon broadcasts the ID derived
+// from the key and the current time window, which lasts Period seconds.
+type BeaconKey struct {
+	BeaconID string `json:"beacon_id"`
+	Key      string `json:"key"`
+	Period   int64  `json:"period"`
+}
+
+// Get the duration of the time windows of the rotating beacons
+func (db *appdbimpl) beaconRotationPeriod() time.Duration {
+	if db.cfg.BeaconRotationPeriod < time.Second {
+		return defaultBeaconRotationPeriod
+	}
+	return db.cfg.BeaconRotationPeriod
+}
+
+// Get the clock skew tolerated between the server and the rotating beacons
+func (db *appdbimpl) beaconClockSkew() time.Duration {
+	if db.cfg.BeaconClockSkew < 0 {
+		return 0
+	} else if db.cfg.BeaconClockSkew == 0 {
+		return defaultBeaconClockSkew
+	}
+	return db.cfg.BeaconClockSkew
+}
+
+// Derive the ID broadcast by a rotating beacon in a time window: the first 16 bytes of the HMAC-SHA256 of the window
+// number (big endian, 8 bytes), formatted as a UUID
+func rotatedBeaconID(key []byte, window int64) string {
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], uint64(window))
+
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(message[:])
+
+	id, _ := uuid.FromBytes(mac.Sum(nil)[:16])
+	return id.String()
+}
+
+// Get the ID of the physical beacon heard at the given time, and received by the server at the given time. Static IDs
+// are only accepted for the beacons that don't rotate, since the static IDs of the rotating beacons are public. Rotated
+// IDs are accepted for the time windows within the clock skew of the time the beacon was heard, which can't be later
+// than the time it was received: a phone can't claim to have heard the IDs of the windows that haven't started yet.
+// Returns false if the ID is unknown or stale.
+func (db *appdbimpl) physicalBeaconID(beaconID string, at time.Time, received time.Time) (string, bool) {
+	if beacon, found := db.Beacons[beaconID]; found {
+		return beaconID, !beacon.Rotating
+	}
+
+	if at.After(received) {
+		at = received
+	}
+
+	period, skew := db.beaconRotationPeriod(), db.beaconClockSkew()
+	first := at.Add(-skew).Unix() / int64(period.Seconds())
+	last := at.Add(skew).Unix() / int64(period.Seconds())
+
+	for id, encoded := range db.BeaconKeys {
+		if beacon, found := db.Beacons[id]; !found || !beacon.Rotating {
+			continue
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+
+		for window := first; window <= last; window++ {
+			if hmac.Equal([]byte(rotatedBeaconID(key, window)), []byte(beaconID)) {
+				return id, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// Give a beacon a new secret key, so that it broadcasts rotating IDs. The previous key of the beacon, if any, stops
+// working at once.
+func (db *appdbimpl) EnableBeaconRotation(beaconID string) (*BeaconKey, error) {
+	beacon, err := db.getChangeableBeacon(beaconID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key := make([]byte, beaconKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	beacon.Rotating = true
+	db.BeaconKeys[beaconID] = base64.StdEncoding.EncodeToString(key)
+
+	err = db.Write()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &BeaconKey{
+		BeaconID: beaconID,
+		Key:      db.BeaconKeys[beaconID],
+		Period:   int64(db.beaconRotationPeriod().Seconds()),
+	}, nil
+}
+
+// Make a beacon broadcast its static ID again, forgetting its secret key
+func (db *appdbimpl) DisableBeaconRotation(beaconID string) (*Beacon, error) {
+	beacon, err := db.getChangeableBeacon(beaconID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !beacon.Rotating {
+		return nil, ErrBeaconNotRotating
+	}
+
+	beacon.Rotating = false
+	delete(db.BeaconKeys, beaconID)
+
+	return db.writeBeacon(beacon)
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestRotatedBeaconID(t *testing.T) {
+	key := make([]byte, beaconKeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+
+	// the first 16 bytes of HMAC-SHA256(key, window), as computed by the firmware of the beacons
+	tests := []struct {
+		window int64
+		want   string
+	}{
+		{0, "9f0cd9b9-4097-fe49-2991-8d2b8942b344"},
+		{1, "c432e059-c378-eef7-fe2f-1181a4050836"},
+		{1000, "6d115ce8-1391-5233-4760-7ae47f5b3013"},
+	}
+
+	for _, tt := range tests {
+		if got := rotatedBeaconID(key, tt.window); got != tt.want {
+			t.Errorf("rotatedBeaconID(%d) = %q, want %q", tt.window, got, tt.want)
+		}
+	}
+
+	key[0]++
+	if got := rotatedBeaconID(key, 0); got == tests[0].want {
+		t.Errorf("rotatedBeaconID() = %q with another key, want a different ID", got)
+	}
+}
+
+func TestPhysicalBeaconID(t *testing.T) {
+	const period = 15 * time.Minute
+
+	// the server receives the sightings one minute into a time window
+	window := int64(1000)
+	received := time.Unix(window*int64(period.Seconds()), 0).Add(time.Minute)
+	hour := int64(time.Hour / period)
+
+	tests := []struct {
+		name      string
+		skew      time.Duration
+		id        func(rotating string, static string, key []byte) string
+		at        time.Time
+		wantValid bool
+	}{
+		{name: "current window", at: received, wantValid: true,
+			id: func(_, _ string, key []byte) string { return rotatedBeaconID(key, window) }},
+		{name: "previous window within the skew", at: received, wantValid: true,
+			id: func(_, _ string, key []byte) string { return rotatedBeaconID(key, window-1) }},
+		{name: "previous window without skew", skew: -1, at: received,
+			id: func(_, _ string, key []byte) string { return rotatedBeaconID(key, window-1) }},
+		{name: "next window beyond the skew", at: received,
+			id: func(_, _ string, key []byte) string { return rotatedBeaconID(key, window+1) }},
+		{name: "stale window", at: received,
+			id: func(_, _ string, key []byte) string { return rotatedBeaconID(key, window-2) }},
+		{name: "window of an event uploaded later", at: received.Add(-time.Hour), wantValid: true,
+			id: func(_, _ string, key []byte) string { return rotatedBeaconID(key, window-hour) }},
+		{name: "current window for an event uploaded later", at: received.Add(-time.Hour),
+			id: func(_, _ string, key []byte) string { return rotatedBeaconID(key, window) }},
+		{name: "window after the upload", at: received.Add(time.Hour),
+			id: func(_, _ string, key []byte) string { return rotatedBeaconID(key, window+hour) }},
+		{name: "static ID of a rotating beacon", at: received,
+			id: func(rotating, _ string, _ []byte) string { return rotating }},
+		{name: "static ID of a beacon that doesn't rotate", at: received, wantValid: true,
+			id: func(_, static string, _ []byte) string { return static }},
+		{name: "unknown ID", at: received,
+			id: func(_, _ string, _ []byte) string { return "unknown" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t, nil)
+			db.cfg.BeaconRotationPeriod = period
+			db.cfg.BeaconClockSkew = tt.skew
+
+			rotating := testBeacon(t, db, BeaconStation, "Napoli Centrale")
+			static := testBeacon(t, db, BeaconStation, "Roma Termini")
+
+			beaconKey, err := db.EnableBeaconRotation(rotating)
+			if err != nil {
+				t.Fatalf("EnableBeaconRotation() error = %v", err)
+			}
+			key, err := base64.StdEncoding.DecodeString(beaconKey.Key)
+			if err != nil {
+				t.Fatalf("can't decode the key: %v", err)
+			}
+
+			heard := tt.id(rotating, static, key)
+			id, valid := db.physicalBeaconID(heard, tt.at, received)
+			if valid != tt.wantValid {
+				t.Fatalf("physicalBeaconID() valid = %v, want %v", valid, tt.wantValid)
+			}
+
+			want := rotating
+			if heard == static {
+				want = static
+			}
+			if valid && id != want {
+				t.Errorf("physicalBeaconID() = %q, want %q", id, want)
+			}
+		})
+	}
+}
+
+func TestBeaconScanIgnoresSightingTimestamps(t *testing.T) {
+	db := newTestDatabase(t, nil)
+	rotating := testBeacon(t, db, BeaconStation, "Napoli Centrale")
+
+	beaconKey, err := db.EnableBeaconRotation(rotating)
+	if err != nil {
+		t.Fatalf("EnableBeaconRotation() error = %v", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(beaconKey.Key)
+	if err != nil {
+		t.Fatalf("can't decode the key: %v", err)
+	}
+
+	// a sighting that claims to be from tomorrow, with the ID of tomorrow
+	now := time.Now()
+	tomorrow := now.Add(24 * time.Hour)
+	period := int64(db.beaconRotationPeriod().Seconds())
+	sightings := []BeaconSighting{{BeaconID: rotatedBeaconID(key, tomorrow.Unix()/period), RSSI: -60, Timestamp: tomorrow}}
+
+	scan := db.newBeaconScan(sightings, db.positioningRules(), now, now)
+	if scan.Rejected != 1 || len(scan.Stations) != 0 {
+		t.Errorf("scan = %+v, want the ID of tomorrow rejected", scan)
+	}
+
+	// the ID of the current window is accepted, whatever the timestamp
+	sightings[0].BeaconID = rotatedBeaconID(key, now.Unix()/period)
+	scan = db.newBeaconScan(sightings, db.positioningRules(), now, now)
+	if scan.Rejected != 0 || scan.Beacons["Napoli Centrale"] != rotating {
+		t.Errorf("scan = %+v, want the beacon of Napoli Centrale", scan)
+	}
+}
@@ -0,0 +1,230 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// PositioningStrongest picks the strongest beacon of the last scan
+	PositioningStrongest string = "strongest"
+
+	// PositioningConsistent prefers a train beacon over a station beacon only if the train beacon has been the
+	// strongest one for several consecutive scans
+	PositioningConsistent = "consistent"
+)
+
+// Default positioning rules, used when no rule has been configured
+const (
+	defaultMinRSSI         int           = -90
+	defaultMaxSightingAge  time.Duration = 30 * time.Second
+	defaultConsistentScans int           = 3
+	defaultScanWindow      time.Duration = time.Minute
+)
+
+// PositioningRules are the rules used to resolve the position of a user from the beacons heard by their phone
+type PositioningRules struct {
+	// Strategy is either PositioningStrongest or PositioningConsistent
+	Strategy string
+
+	// MinRSSI is the weakest signal (in dBm) taken into account. Weaker sightings are ignored.
+	MinRSSI int
+
+	// MaxSightingAge is the maximum age of a sighting. Older sightings are ignored.
+	MaxSightingAge time.Duration
+
+	// ConsistentScans is the number of consecutive scans in which a train beacon must be stronger than all the
+	// station beacons to be preferred over them
+	ConsistentScans int
+
+	// ScanWindow is how long the scans of a user are remembered
+	ScanWindow time.Duration
+
+	// Dwell is how long a user must be seen in a new station or train before being moved there. Zero moves the user
+	// at once.
+	Dwell time.Duration
+
+	// Grace is how long a user must be away from every beacon before leaving the station or the train. Zero moves
+	// the user at once.
+	Grace time.Duration
+}
+
+// BeaconSighting is a beacon heard by the phone of a user. The RSSI is in dBm: the closer to zero, the stronger. A
+// sighting with a station code is the scan of the QR code printed in a station: it is heard as a beacon of the
+// station, and the RSSI is usually left to zero (the strongest signal).
+type BeaconSighting struct {
+	BeaconID    string    `json:"beacon_id"`
+	RSSI        int       `json:"rssi"`
+	Timestamp   time.Time `json:"timestamp"`
+	StationCode string    `json:"station_code"`
+}
+
+// A scan is the strongest signal of each station and train heard in a single position update, along with the beacon
+// it came from
+type beaconScan struct {
+	Time     time.Time
+	Stations map[string]int
+	Trains   map[string]int
+	Beacons  map[string]string
+	Rejected int
+}
+
+// Get the positioning rules, filling the missing ones with the defaults
+func (db *appdbimpl) positioningRules() PositioningRules {
+	rules := db.cfg.Positioning
+	if rules.Strategy == "" {
+		rules.Strategy = PositioningConsistent
+	}
+	if rules.MinRSSI == 0 {
+		rules.MinRSSI = defaultMinRSSI
+	}
+	if rules.MaxSightingAge <= 0 {
+		rules.MaxSightingAge = defaultMaxSightingAge
+	}
+	if rules.ConsistentScans <= 0 {
+		rules.ConsistentScans = defaultConsistentScans
+	}
+	if rules.ScanWindow <= 0 {
+		rules.ScanWindow = defaultScanWindow
+	}
+	return rules
+}
+
+// Build a scan from the sightings, ignoring the weak or old sightings and the inactive beacons. The unknown or stale
+// beacon IDs (and the station codes that are not valid) are rejected. A sighting without a timestamp is considered as
+// heard at the time of the scan. The rotating IDs and the station codes are checked at the time of the scan, never at
+// the timestamps of the sightings, that are up to the phone.
+func (db *appdbimpl) newBeaconScan(sightings []BeaconSighting, rules PositioningRules, now time.Time, received time.Time) beaconScan {
+	scan := beaconScan{
+		Time:     now,
+		Stations: make(map[string]int),
+		Trains:   make(map[string]int),
+		Beacons:  make(map[string]string),
+	}
+
+	for _, sighting := range sightings {
+		if sighting.RSSI < rules.MinRSSI {
+			continue
+		}
+		if !sighting.Timestamp.IsZero() && now.Sub(sighting.Timestamp) > rules.MaxSightingAge {
+			continue
+		}
+
+		heardAt := sighting.Timestamp
+		if heardAt.IsZero() {
+			heardAt = now
+		}
+		var beaconID string
+		if sighting.StationCode != "" {
+			station, err := db.verifyStationCode(sighting.StationCode, now)
+			if err != nil {
+				scan.Rejected++
+				continue
+			}
+			beaconID = stationCodeBeacon(station)
+		} else {
+			var valid bool
+			if beaconID, valid = db.physicalBeaconID(sighting.BeaconID, now, received); !valid {
+				scan.Rejected++
+				continue
+			}
+		}
+
+		var signals map[string]int
+		var owner string
+		if station := db.GetStationByBeaconID(beaconID); station != nil {
+			signals, owner = scan.Stations, station.Name
+		} else if train := db.GetTrainByBeaconID(beaconID, heardAt); train != nil {
+			signals, owner = scan.Trains, train.ID
+		} else {
+			continue
+		}
+
+		if rssi, found := signals[owner]; !found || sighting.RSSI > rssi {
+			signals[owner] = sighting.RSSI
+			scan.Beacons[owner] = beaconID
+		}
+	}
+
+	return scan
+}
+
+// Get the station (or train) with the strongest signal, or an empty string if there are none. Ties are broken by
+// name, so that the result doesn't depend on the order of the sightings.
+func strongestSignal(signals map[string]int) (string, int) {
+	owners := make([]string, 0, len(signals))
+	for owner := range signals {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+
+	best, bestRSSI := "", 0
+	for _, owner := range owners {
+		if best == "" || signals[owner] > bestRSSI {
+			best, bestRSSI = owner, signals[owner]
+		}
+	}
+	return best, bestRSSI
+}
+
+// Remember the scan of a user, forgetting the scans that are too old or too many to matter. Returns the scans of the
+// user, from the oldest to the newest.
+func (db *appdbimpl) recordBeaconScan(userID string, scan beaconScan, rules PositioningRules) []beaconScan {
+	if db.scans == nil {
+		db.scans = make(map[string][]beaconScan)
+	}
+
+	scans := make([]beaconScan, 0, rules.ConsistentScans)
+	for _, previous := range db.scans[userID] {
+		if scan.Time.Sub(previous.Time) <= rules.ScanWindow {
+			scans = append(scans, previous)
+		}
+	}
+	scans = append(scans, scan)
+	if len(scans) > rules.ConsistentScans {
+		scans = scans[len(scans)-rules.ConsistentScans:]
+	}
+
+	db.scans[userID] = scans
+	return scans
+}
+
+// Resolve the most likely position of a user from the beacons heard by their phone, and received by the server at the
+// given time. Returns the beacon ID of the station or the train the user is in (or an empty string if the user is away
+// from any beacon), and the scan built from the sightings.
+func (db *appdbimpl) resolveBeacon(userID string, sightings []BeaconSighting, now time.Time, received time.Time) (string, beaconScan) {
+	rules := db.positioningRules()
+	scan := db.newBeaconScan(sightings, rules, now, received)
+	scans := db.recordBeaconScan(userID, scan, rules)
+
+	station, stationRSSI := strongestSignal(scan.Stations)
+	train, trainRSSI := strongestSignal(scan.Trains)
+
+	switch {
+	case train == "":
+		return scan.Beacons[station], scan
+	case station == "":
+		return scan.Beacons[train], scan
+	case rules.Strategy == PositioningStrongest:
+		if trainRSSI > stationRSSI {
+			return scan.Beacons[train], scan
+		}
+		return scan.Beacons[station], scan
+	}
+
+	// the phone hears both a train and a station (e.g., the train is standing in the station): the user is on the
+	// train only if it has been stronger than all the stations in each of the last scans
+	if len(scans) < rules.ConsistentScans {
+		return scan.Beacons[station], scan
+	}
+	for _, previous := range scans {
+		rssi, found := previous.Trains[train]
+		if !found {
+			return scan.Beacons[station], scan
+		}
+		if _, strongest := strongestSignal(previous.Stations); len(previous.Stations) > 0 && strongest >= rssi {
+			return scan.Beacons[station], scan
+		}
+	}
+	return scan.Beacons[train], scan
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	PositionSourceBeacon string = "beacon"
+	PositionSourceManual        = "manual"
+	PositionSourceQRCode        = "qr_code"
+	PositionSourceServer        = "server"
+)
+
+var (
+	// ErrStationNotServed is returned when checking in (or out) at a station that is not on the route of the train
+	// (after the boarding station, when checking out)
+	ErrStationNotServed = errors.New("The train does not stop at the station")
+
+	// ErrTrainNotAtStation is returned when checking in (or out) at a station the train is not at: the passenger can't
+	// be boarding (or getting off) there
+	ErrTrainNotAtStation = errors.New("The train is not at the station")
+
+	// ErrAlreadyCheckedIn is returned when checking in on the train the user is already on
+	ErrAlreadyCheckedIn = errors.New("Already on the train")
+
+	// ErrNotCheckedIn is returned when checking out a user who is not on a train
+	ErrNotCheckedIn = errors.New("Not on a train")
+)
+
+// StationDeclaration is a station declared by a user checking in or out without beacons: either its name, or the
+// signed code printed (as a QR code) in the station
+type StationDeclaration struct {
+	Station     string
+	StationCode string
+}
+
+// Check if the user is on a train they checked in by hand (or with a QR code). Those users may have Bluetooth off, or
+// ride a train with a broken beacon: not hearing any beacon doesn't mean that they got off.
+func checkedIn(state *UserState) bool {
+	return state != nil && state.Status == InTrain && (state.Source == PositionSourceManual || state.Source == PositionSourceQRCode)
+}
+
+// Get the station declared by a user, and how it was declared
+func (db *appdbimpl) declaredStation(declaration StationDeclaration) (*Station, string, error) {
+	if declaration.StationCode == "" {
+		station, err := db.GetStationByID(declaration.Station)
+		return station, PositionSourceManual, err
+	}
+
+	station, err := db.verifyStationCode(declaration.StationCode, time.Now())
+	return station, PositionSourceQRCode, err
+}
+
+// Check if a passenger can be boarding (or getting off) a train at a station at the given time: the train must be at
+// the last station it reached, or it must have left it for that station, in case its arrival has not been recorded
+// yet. Otherwise a passenger could declare another station, and pay for a shorter trip than the one they made.
+func trainAtStation(train Train, station Station, at time.Time) bool {
+	current := indexStation(*trainStationAt(train, at), train)
+	declared := indexStation(station, train)
+
+	if declared == current {
+		return true
+	}
+	return declared == current+1 && (*train.Trip)[current].DepartureTime != ""
+}
+
+// Check a user in on a train at the declared station, without beacons. The trip, the ticket and the fare are the
+// same as when the beacons are heard.
+func (db *appdbimpl) CheckIn(userID string, trainID string, declaration StationDeclaration) (*UpdateUserPositionResponse, error) {
+	train, err := db.getTrainByID(trainID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	station, source, err := db.declaredStation(declaration)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if indexStation(*station, *train) == -1 {
+		return nil, ErrStationNotServed
+	}
+
+	now := time.Now()
+
+	if !trainAtStation(*train, *station, now) {
+		return nil, ErrTrainNotAtStation
+	}
+
+	if state := db.GetUserPosition(userID); state != nil && state.Status == InTrain && state.Train != nil && state.Train.ID == train.ID {
+		return nil, ErrAlreadyCheckedIn
+	}
+
+	// the declaration replaces any move seen by the beacons in the meantime
+	delete(db.pending, userID)
+
+	db.eventCursor(userID).LastEventTime = now.Format(time.RFC3339)
+
+	return db.applyMove(userID, &UserState{Status: InTrain, Train: train, Station: station, Source: source}, now)
+}
+
+// Check a user out of their train at the declared station, without beacons
+func (db *appdbimpl) CheckOut(userID string, declaration StationDeclaration) (*UpdateUserPositionResponse, error) {
+	state := db.GetUserPosition(userID)
+
+	if state == nil || state.Status != InTrain || state.Train == nil || state.Station == nil {
+		return nil, ErrNotCheckedIn
+	}
+
+	station, source, err := db.declaredStation(declaration)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the position of the user has a copy of the train, taken when they boarded
+	train, err := db.getTrainByID(state.Train.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if indexStation(*station, *train) < indexStation(*state.Station, *train) {
+		return nil, ErrStationNotServed
+	}
+
+	now := time.Now()
+
+	if !trainAtStation(*train, *station, now) {
+		return nil, ErrTrainNotAtStation
+	}
+
+	delete(db.pending, userID)
+
+	db.eventCursor(userID).LastEventTime = now.Format(time.RFC3339)
+
+	return db.applyMove(userID, &UserState{Status: InStation, Station: station, Source: source}, now)
+}
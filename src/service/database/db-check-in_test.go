@@ -0,0 +1,79 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckOutStation(t *testing.T) {
+	// trainMove is an update of the position of FR9422, after the user checked in at Napoli Centrale
+	type trainMove struct {
+		station string
+		status  string
+		at      string
+	}
+
+	departed := []trainMove{{"Napoli Centrale", "departed", "08:02"}}
+	inRoma := append(departed, trainMove{"Roma Termini", "arrived", "09:10"})
+
+	tests := []struct {
+		name     string
+		moves    []trainMove
+		station  string
+		wantErr  error
+		wantCost float64
+	}{
+		{name: "train still at the boarding station", station: "Napoli Centrale", wantCost: 0},
+		{name: "boarding station after the departure", moves: inRoma, station: "Napoli Centrale",
+			wantErr: ErrTrainNotAtStation},
+		{name: "station the train is at", moves: inRoma, station: "Roma Termini", wantCost: 5.5},
+		{name: "station the train is heading to", moves: departed, station: "Roma Termini", wantCost: 5.5},
+		{name: "station the train has not reached", moves: inRoma, station: "Firenze S.M.N.",
+			wantErr: ErrTrainNotAtStation},
+		{name: "station not served", moves: inRoma, station: "Ferrara", wantErr: ErrStationNotServed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t, nil)
+			moveTestTrain(t, db, "FR9422", "Napoli Centrale", "arrived", "08:00")
+
+			if _, err := db.CheckIn("alice", "FR9422", StationDeclaration{Station: "Napoli Centrale"}); err != nil {
+				t.Fatalf("CheckIn() error = %v", err)
+			}
+			for _, move := range tt.moves {
+				moveTestTrain(t, db, "FR9422", move.station, move.status, move.at)
+			}
+
+			response, err := db.CheckOut("alice", StationDeclaration{Station: tt.station})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CheckOut() error = %v, want %v", err, tt.wantErr)
+			}
+
+			trips := db.GetTrips("alice")
+			if len(trips) != 1 {
+				t.Fatalf("got %d trips, want 1", len(trips))
+			}
+
+			if tt.wantErr != nil {
+				if state := db.GetUserPosition("alice"); state.Status != InTrain {
+					t.Errorf("user status = %q, want the user still on the train", state.Status)
+				}
+				if trips[0].Status != TripBoarded {
+					t.Errorf("trip status = %q, want %q", trips[0].Status, TripBoarded)
+				}
+				return
+			}
+
+			if response.Status != InStation {
+				t.Errorf("response status = %q, want %q", response.Status, InStation)
+			}
+			if trips[0].Status != TripAlighted || trips[0].AlightingStation != tt.station {
+				t.Errorf("trip = %+v, want alighted in %s", trips[0], tt.station)
+			}
+			if trips[0].Cost != tt.wantCost {
+				t.Errorf("trip cost = %v, want %v", trips[0].Cost, tt.wantCost)
+			}
+		})
+	}
+}
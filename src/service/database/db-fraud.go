@@ -0,0 +1,291 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+const (
+	RuleImpossibleSpeed  string = "impossible_speed"
+	RuleStationNotServed        = "station_not_served"
+	RuleTicketChurn             = "ticket_churn"
+)
+
+const (
+	SuspiciousOpen      string = "open"
+	SuspiciousConfirmed        = "confirmed"
+	SuspiciousDismissed        = "dismissed"
+)
+
+// Default fraud rules, used when no rule has been configured
+const (
+	defaultMaxSpeed          float64       = 350
+	defaultMinDistance       float64       = 5
+	defaultMinElapsed        time.Duration = time.Minute
+	defaultTicketChurnLimit  int           = 4
+	defaultTicketChurnWindow time.Duration = time.Hour
+)
+
+// earthRadius is the mean radius of the Earth, in kilometers
+const earthRadius = 6371.0
+
+var (
+	// ErrSuspiciousEventNotFound is returned when the suspicious event does not exist
+	ErrSuspiciousEventNotFound = errors.New("Suspicious event not found")
+
+	// ErrInvalidReviewStatus is returned when a suspicious event is reviewed with a status other than confirmed or
+	// dismissed
+	ErrInvalidReviewStatus = errors.New("The review status must be confirmed or dismissed")
+
+	// ErrAlreadyReviewed is returned when reviewing a suspicious event that has already been reviewed
+	ErrAlreadyReviewed = errors.New("Suspicious event already reviewed")
+)
+
+// FraudRules are the thresholds of the checks on the movements of the passengers
+type FraudRules struct {
+	// MaxSpeed is the highest plausible speed (in km/h) between two stations
+	MaxSpeed float64
+
+	// MinDistance is the shortest move (in km) checked against MaxSpeed: a phone can hear the beacons of nearby
+	// stations at once, and jump from one to the other. A negative value checks every move.
+	MinDistance float64
+
+	// MinElapsed is the shortest time a move between two stations is counted as, so that the jitter of the scans
+	// doesn't turn a short move into an impossible speed. A negative value counts the actual time.
+	MinElapsed time.Duration
+
+	// TicketChurnLimit is the highest plausible number of tickets issued to a passenger within TicketChurnWindow
+	TicketChurnLimit  int
+	TicketChurnWindow time.Duration
+}
+
+// SuspiciousEvent is a movement of a passenger that breaks a fraud rule. Passengers are never blocked: the events
+// wait in a queue for an admin to review them.
+type SuspiciousEvent struct {
+	ID         string `json:"id"`
+	UserID     string `json:"user_id"`
+	Rule       string `json:"rule"`
+	Time       string `json:"time"`
+	TrainID    string `json:"train_id"`
+	Station    string `json:"station"`
+	Details    string `json:"details"`
+	Status     string `json:"status"`
+	ReviewedBy string `json:"reviewed_by"`
+	ReviewedAt string `json:"reviewed_at"`
+	Note       string `json:"note"`
+}
+
+// The last station a passenger has been seen in
+type stationFix struct {
+	Station *Station
+	At      time.Time
+}
+
+// Get the fraud rules, filling the missing ones with the defaults
+func (db *appdbimpl) fraudRules() FraudRules {
+	rules := db.cfg.Fraud
+	if rules.MaxSpeed <= 0 {
+		rules.MaxSpeed = defaultMaxSpeed
+	}
+	if rules.MinDistance < 0 {
+		rules.MinDistance = 0
+	} else if rules.MinDistance == 0 {
+		rules.MinDistance = defaultMinDistance
+	}
+	if rules.MinElapsed < 0 {
+		rules.MinElapsed = 0
+	} else if rules.MinElapsed == 0 {
+		rules.MinElapsed = defaultMinElapsed
+	}
+	if rules.TicketChurnLimit <= 0 {
+		rules.TicketChurnLimit = defaultTicketChurnLimit
+	}
+	if rules.TicketChurnWindow <= 0 {
+		rules.TicketChurnWindow = defaultTicketChurnWindow
+	}
+	return rules
+}
+
+// Get the great-circle distance between two locations, in kilometers
+func distance(from Location, to Location) float64 {
+	lat1, lat2 := from.Latitutde*math.Pi/180, to.Latitutde*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (to.Longitude - from.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// Record a suspicious event, unless the same event is already waiting for review. The caller is responsible for
+// writing the database.
+func (db *appdbimpl) flagSuspicious(event SuspiciousEvent) error {
+	for _, previous := range db.SuspiciousEvents {
+		if previous.Status == SuspiciousOpen && previous.UserID == event.UserID && previous.Rule == event.Rule &&
+			previous.TrainID == event.TrainID && previous.Station == event.Station {
+			return nil
+		}
+	}
+
+	eventID, err := uuid.NewV4()
+
+	if err != nil {
+		return err
+	}
+
+	event.ID = eventID.String()
+	event.Status = SuspiciousOpen
+	db.SuspiciousEvents = append(db.SuspiciousEvents, event)
+
+	return nil
+}
+
+// Check a scan of a passenger against the physical plausibility rules: the passenger can't travel between stations
+// faster than the fastest train, and the stations heard along with a train must be served by it. The last station of
+// each passenger is only kept in memory: after a restart, the first station a passenger is heard in is not checked.
+// The caller is responsible for writing the database.
+func (db *appdbimpl) checkMovement(userID string, scan beaconScan, at time.Time) error {
+	rules := db.fraudRules()
+
+	if db.fixes == nil {
+		db.fixes = make(map[string]stationFix)
+	}
+
+	stationName, _ := strongestSignal(scan.Stations)
+	station := db.GetStationByBeaconID(scan.Beacons[stationName])
+
+	if station != nil {
+		if fix, found := db.fixes[userID]; found && fix.Station.Name != station.Name && at.After(fix.At) {
+			km := distance(fix.Station.Location, station.Location)
+			elapsed := at.Sub(fix.At)
+			if elapsed < rules.MinElapsed {
+				elapsed = rules.MinElapsed
+			}
+			if speed := km / elapsed.Hours(); km >= rules.MinDistance && speed > rules.MaxSpeed {
+				err := db.flagSuspicious(SuspiciousEvent{
+					UserID:  userID,
+					Rule:    RuleImpossibleSpeed,
+					Time:    at.Format(time.RFC3339),
+					Station: station.Name,
+					Details: fmt.Sprintf("From %s to %s (%.0f km) in %s, at %.0f km/h", fix.Station.Name, station.Name, km, at.Sub(fix.At).Round(time.Second), speed),
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		db.fixes[userID] = stationFix{Station: station, At: at}
+	}
+
+	for trainID := range scan.Trains {
+		train := db.GetTrainByBeaconID(scan.Beacons[trainID], at)
+		if train == nil {
+			continue
+		}
+
+		for name := range scan.Stations {
+			served, err := db.GetStationByID(name)
+			if err != nil || indexStation(*served, *train) != -1 {
+				continue
+			}
+
+			err = db.flagSuspicious(SuspiciousEvent{
+				UserID:  userID,
+				Rule:    RuleStationNotServed,
+				Time:    at.Format(time.RFC3339),
+				TrainID: train.ID,
+				Station: name,
+				Details: fmt.Sprintf("Train %s heard along with %s, which is not on its route", train.ID, name),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Check the tickets issued to a passenger, after a new one: too many tickets in a short time mean that the passenger
+// keeps getting on and off trains (or spoofing beacons). The caller is responsible for writing the database.
+func (db *appdbimpl) checkTicketChurn(userID string, at time.Time) error {
+	rules := db.fraudRules()
+
+	since := at.Add(-rules.TicketChurnWindow)
+	count := 0
+	for _, ticket := range db.Tickets {
+		if ticket.UserID != userID {
+			continue
+		}
+		if issuedAt, err := time.Parse(time.RFC3339, ticket.IssuedAt); err == nil && !issuedAt.Before(since) {
+			count++
+		}
+	}
+
+	if count <= rules.TicketChurnLimit {
+		return nil
+	}
+
+	return db.flagSuspicious(SuspiciousEvent{
+		UserID:  userID,
+		Rule:    RuleTicketChurn,
+		Time:    at.Format(time.RFC3339),
+		Details: fmt.Sprintf("%d tickets issued in %s", count, rules.TicketChurnWindow),
+	})
+}
+
+// Get the suspicious events with the given status (or all of them), from the newest to the oldest
+func (db *appdbimpl) GetSuspiciousEvents(status string) []SuspiciousEvent {
+	events := make([]SuspiciousEvent, 0)
+	for _, event := range db.SuspiciousEvents {
+		if status == "" || event.Status == status {
+			events = append(events, event)
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time > events[j].Time
+	})
+
+	return events
+}
+
+// Review a suspicious event, confirming or dismissing it
+func (db *appdbimpl) ReviewSuspiciousEvent(eventID string, reviewer string, status string, note string) (*SuspiciousEvent, error) {
+	switch status {
+	case SuspiciousConfirmed, SuspiciousDismissed:
+	default:
+		return nil, ErrInvalidReviewStatus
+	}
+
+	for i := range db.SuspiciousEvents {
+		event := &db.SuspiciousEvents[i]
+		if event.ID != eventID {
+			continue
+		}
+
+		if event.Status != SuspiciousOpen {
+			return nil, ErrAlreadyReviewed
+		}
+
+		event.Status = status
+		event.ReviewedBy = reviewer
+		event.ReviewedAt = time.Now().Format(time.RFC3339)
+		event.Note = note
+
+		err := db.Write()
+
+		if err != nil {
+			return nil, err
+		}
+
+		result := *event
+		return &result, nil
+	}
+
+	return nil, ErrSuspiciousEventNotFound
+}
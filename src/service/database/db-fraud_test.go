@@ -0,0 +1,197 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// stationScan builds a scan that hears the given stations (and trains), with their first beacons
+func stationScan(t *testing.T, db *appdbimpl, at time.Time, stations []string, trains []string) beaconScan {
+	t.Helper()
+
+	scan := beaconScan{
+		Time:     at,
+		Stations: make(map[string]int),
+		Trains:   make(map[string]int),
+		Beacons:  make(map[string]string),
+	}
+	for _, name := range stations {
+		scan.Stations[name] = -60
+		scan.Beacons[name] = testBeacon(t, db, BeaconStation, name)
+	}
+	for _, trainID := range trains {
+		scan.Trains[trainID] = -70
+		scan.Beacons[trainID] = testBeacon(t, db, BeaconTrainCar, trainID)
+	}
+	return scan
+}
+
+func TestImpossibleSpeed(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     string
+		to       string
+		elapsed  time.Duration
+		rules    FraudRules
+		wantFlag bool
+	}{
+		{name: "plausible speed", from: "Napoli Centrale", to: "Roma Termini", elapsed: time.Hour},
+		{name: "faster than the fastest train", from: "Napoli Centrale", to: "Roma Termini", elapsed: 20 * time.Minute,
+			wantFlag: true},
+		{name: "far move within the minimum time", from: "Napoli Centrale", to: "Roma Termini", elapsed: 10 * time.Second,
+			wantFlag: true},
+		{name: "same station", from: "Roma Termini", to: "Roma Termini", elapsed: time.Second},
+		{name: "nearby stations", from: "Roma Termini", to: "Roma Tiburtina", elapsed: 10 * time.Second,
+			rules: FraudRules{MinElapsed: -1}},
+		{name: "nearby stations within the minimum time", from: "Roma Termini", to: "Roma Tiburtina",
+			elapsed: 10 * time.Second, rules: FraudRules{MinDistance: -1}},
+		{name: "nearby stations without floors", from: "Roma Termini", to: "Roma Tiburtina", elapsed: 10 * time.Second,
+			rules: FraudRules{MinDistance: -1, MinElapsed: -1}, wantFlag: true},
+		{name: "scans out of order", from: "Napoli Centrale", to: "Roma Termini", elapsed: -time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t, nil)
+			db.cfg.Fraud = tt.rules
+
+			at := time.Now()
+			for _, scan := range []beaconScan{
+				stationScan(t, db, at, []string{tt.from}, nil),
+				stationScan(t, db, at.Add(tt.elapsed), []string{tt.to}, nil),
+			} {
+				if err := db.checkMovement("alice", scan, scan.Time); err != nil {
+					t.Fatalf("checkMovement() error = %v", err)
+				}
+			}
+
+			events := db.GetSuspiciousEvents(SuspiciousOpen)
+			if flagged := len(events) != 0; flagged != tt.wantFlag {
+				t.Fatalf("flagged = %v (%+v), want %v", flagged, events, tt.wantFlag)
+			}
+			if tt.wantFlag && (events[0].Rule != RuleImpossibleSpeed || events[0].Station != tt.to) {
+				t.Errorf("event = %+v, want %s in %s", events[0], RuleImpossibleSpeed, tt.to)
+			}
+		})
+	}
+}
+
+func TestStationNotServed(t *testing.T) {
+	tests := []struct {
+		station  string
+		wantFlag bool
+	}{
+		{"Roma Termini", false},
+		{"Ferrara", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.station, func(t *testing.T) {
+			db := newTestDatabase(t, nil)
+
+			scan := stationScan(t, db, time.Now(), []string{tt.station}, []string{"FR9422"})
+			if err := db.checkMovement("alice", scan, scan.Time); err != nil {
+				t.Fatalf("checkMovement() error = %v", err)
+			}
+
+			events := db.GetSuspiciousEvents("")
+			if flagged := len(events) != 0; flagged != tt.wantFlag {
+				t.Fatalf("flagged = %v (%+v), want %v", flagged, events, tt.wantFlag)
+			}
+			if tt.wantFlag && (events[0].Rule != RuleStationNotServed || events[0].TrainID != "FR9422") {
+				t.Errorf("event = %+v, want %s on FR9422", events[0], RuleStationNotServed)
+			}
+		})
+	}
+}
+
+func TestFlagSuspiciousOnce(t *testing.T) {
+	db := newTestDatabase(t, nil)
+	event := SuspiciousEvent{UserID: "alice", Rule: RuleStationNotServed, TrainID: "FR9422", Station: "Ferrara"}
+
+	// the same event is flagged again only once the previous one has been reviewed
+	for i, wantEvents := range []int{1, 1} {
+		if err := db.flagSuspicious(event); err != nil {
+			t.Fatalf("flagSuspicious() error = %v", err)
+		}
+		if len(db.SuspiciousEvents) != wantEvents {
+			t.Errorf("try %d: got %d events, want %d", i, len(db.SuspiciousEvents), wantEvents)
+		}
+	}
+
+	if _, err := db.ReviewSuspiciousEvent(db.SuspiciousEvents[0].ID, "admin", SuspiciousDismissed, ""); err != nil {
+		t.Fatalf("ReviewSuspiciousEvent() error = %v", err)
+	}
+	if err := db.flagSuspicious(event); err != nil {
+		t.Fatalf("flagSuspicious() error = %v", err)
+	}
+	if len(db.SuspiciousEvents) != 2 {
+		t.Errorf("got %d events after the review, want 2", len(db.SuspiciousEvents))
+	}
+}
+
+func TestTicketChurn(t *testing.T) {
+	db := newTestDatabase(t, nil)
+	db.cfg.Fraud = FraudRules{TicketChurnLimit: 2, TicketChurnWindow: time.Hour}
+
+	now := time.Now()
+	issue := func(ticketID string, userID string, issuedAt time.Time) {
+		db.Tickets[ticketID] = &Ticket{ID: ticketID, UserID: userID, IssuedAt: issuedAt.Format(time.RFC3339)}
+	}
+	issue("t1", "alice", now.Add(-2*time.Hour))
+	issue("t2", "alice", now.Add(-30*time.Minute))
+	issue("t3", "alice", now)
+	issue("t4", "bob", now)
+
+	// the ticket issued before the window doesn't count
+	if err := db.checkTicketChurn("alice", now); err != nil {
+		t.Fatalf("checkTicketChurn() error = %v", err)
+	}
+	if len(db.SuspiciousEvents) != 0 {
+		t.Fatalf("flagged %+v with 2 tickets in the window, want nothing", db.SuspiciousEvents)
+	}
+
+	issue("t5", "alice", now)
+	if err := db.checkTicketChurn("alice", now); err != nil {
+		t.Fatalf("checkTicketChurn() error = %v", err)
+	}
+	if len(db.SuspiciousEvents) != 1 || db.SuspiciousEvents[0].Rule != RuleTicketChurn {
+		t.Errorf("events = %+v, want a %s event", db.SuspiciousEvents, RuleTicketChurn)
+	}
+}
+
+func TestReviewSuspiciousEvent(t *testing.T) {
+	db := newTestDatabase(t, nil)
+	if err := db.flagSuspicious(SuspiciousEvent{UserID: "alice", Rule: RuleTicketChurn}); err != nil {
+		t.Fatalf("flagSuspicious() error = %v", err)
+	}
+	eventID := db.SuspiciousEvents[0].ID
+
+	tests := []struct {
+		name    string
+		eventID string
+		status  string
+		wantErr error
+	}{
+		{"invalid status", eventID, SuspiciousOpen, ErrInvalidReviewStatus},
+		{"unknown event", "unknown", SuspiciousConfirmed, ErrSuspiciousEventNotFound},
+		{"confirmed", eventID, SuspiciousConfirmed, nil},
+		{"already reviewed", eventID, SuspiciousDismissed, ErrAlreadyReviewed},
+	}
+
+	// the cases run in order, on the same event
+	for _, tt := range tests {
+		event, err := db.ReviewSuspiciousEvent(tt.eventID, "admin", tt.status, "note")
+		if !errors.Is(err, tt.wantErr) {
+			t.Fatalf("%s: ReviewSuspiciousEvent() error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if err == nil && (event.Status != tt.status || event.ReviewedBy != "admin" || event.Note != "note") {
+			t.Errorf("%s: event = %+v, want %s by admin", tt.name, event, tt.status)
+		}
+	}
+
+	if status := db.SuspiciousEvents[0].Status; status != SuspiciousConfirmed {
+		t.Errorf("stored status = %q, want %q", status, SuspiciousConfirmed)
+	}
+}
@@ -24,6 +24,7 @@ func (db *appdbimpl) processPayment(userID string, train Train, from_station Sta
 	}
 
 	payment := PaymentResponse{
+		Type:                   PaymentTypeFare,
 		Cost:                   total_cost,
 		TrainID:                train.ID,
 		FromStation:            &from_station,
@@ -35,11 +36,9 @@ func (db *appdbimpl) processPayment(userID string, train Train, from_station Sta
 		Date:                   time.Now().Format("01/02/2006"),
 	}
 
+	// zero-cost trips (e.g., the user got off at the boarding station) are not charged
 	if total_cost > 0.0 {
-		if db.PaymentHistory[userID] == nil {
-			db.PaymentHistory[userID] = make([]PaymentResponse, 0)
-		}
-		db.PaymentHistory[userID] = append(db.PaymentHistory[userID], payment)
+		return db.chargePayment(userID, payment)
 	}
 
 	return &payment, nil
@@ -62,10 +61,51 @@ func findLastStation(train Train) (*Station, error) {
 	return (*train.Trip)[len(*train.Trip)-1].Station, nil
 }
 
-// Find the position of a station in a train's trip
+// liveEventAge is the age under which a position event is considered live, so that the live position of the train is
+// used instead of the arrival times of its trip
+const liveEventAge = time.Minute
+
+// Find the last station the train had arrived to at the given time
+func findStationAt(train Train, at time.Time) (*Station, error) {
+	if time.Since(at) < liveEventAge {
+		return findLastStation(train)
+	}
+
+	for k, v := range *train.Trip {
+		arrival, err := time.Parse("15:04", v.ArrivalTime)
+
+		if err != nil || clockDistance(at, arrival) < 0 {
+			if k == 0 {
+				return nil, errors.New("Train is not in a station")
+			}
+
+			return (*train.Trip)[k-1].Station, nil
+		}
+	}
+
+	// Train was in the last station
+	return (*train.Trip)[len(*train.Trip)-1].Station, nil
+}
+
+// Get the minutes from the clock time to the given time, between -12 and +12 hours, so that trips running over
+// midnight are handled. The clock times of the trips are local times, while the given time may be in any location
+// (e.g., the UTC timestamp of a position event).
+func clockDistance(at time.Time, clock time.Time) int {
+	at = at.In(time.Local)
+	distance := (at.Hour()*60 + at.Minute()) - (clock.Hour()*60 + clock.Minute())
+	if distance >= 12*60 {
+		distance -= 24 * 60
+	} else if distance < -12*60 {
+		distance += 24 * 60
+	}
+	return distance
+}
+
+// Find the position of a station in a train's trip. Stations are compared by name, since the trips hold their own
+// copies of the stations.
 func indexStation(station Station, train Train) int {
 	for k, v := range *train.Trip {
-		if station == *v.Station {
+		if station.Name == v.Station.Name {
 			return k
 		}
 	}
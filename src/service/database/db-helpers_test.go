@@ -0,0 +1,83 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// inLocalZone sets the local time zone for the rest of the test
+func inLocalZone(t *testing.T, zone *time.Location) {
+	t.Helper()
+
+	local := time.Local
+	time.Local = zone
+	t.Cleanup(func() { time.Local = local })
+}
+
+func TestClockDistance(t *testing.T) {
+	inLocalZone(t, time.FixedZone("CEST", 2*60*60))
+
+	clock := func(value string) time.Time {
+		parsed, err := time.Parse("15:04", value)
+		if err != nil {
+			t.Fatalf("can't parse %s: %v", value, err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name  string
+		at    time.Time
+		clock string
+		want  int
+	}{
+		{"local time", time.Date(2026, 10, 19, 9, 30, 0, 0, time.Local), "09:00", 30},
+		{"UTC time", time.Date(2026, 10, 19, 7, 30, 0, 0, time.UTC), "09:00", 30},
+		{"before the clock time", time.Date(2026, 10, 19, 6, 45, 0, 0, time.UTC), "09:00", -15},
+		{"after midnight", time.Date(2026, 10, 18, 22, 10, 0, 0, time.UTC), "23:50", 20},
+		{"before midnight", time.Date(2026, 10, 19, 21, 50, 0, 0, time.UTC), "00:10", -20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clockDistance(tt.at, clock(tt.clock)); got != tt.want {
+				t.Errorf("clockDistance(%v, %s) = %d, want %d", tt.at, tt.clock, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindStationAt(t *testing.T) {
+	inLocalZone(t, time.FixedZone("CEST", 2*60*60))
+
+	db := newTestDatabase(t, nil)
+	moveTestTrain(t, db, "FR9422", "Napoli Centrale", "arrived", "08:00")
+	moveTestTrain(t, db, "FR9422", "Napoli Centrale", "departed", "08:02")
+	moveTestTrain(t, db, "FR9422", "Roma Termini", "arrived", "09:10")
+	train := testTrain(t, db, "FR9422")
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want string
+	}{
+		{"local time", time.Date(2026, 10, 18, 8, 30, 0, 0, time.Local), "Napoli Centrale"},
+		{"UTC time", time.Date(2026, 10, 18, 6, 30, 0, 0, time.UTC), "Napoli Centrale"},
+		{"UTC time after the arrival", time.Date(2026, 10, 18, 7, 30, 0, 0, time.UTC), "Roma Termini"},
+		{"UTC time before the run", time.Date(2026, 10, 18, 5, 30, 0, 0, time.UTC), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			station, err := findStationAt(*train, tt.at)
+
+			got := ""
+			if err == nil {
+				got = station.Name
+			}
+			if got != tt.want {
+				t.Errorf("findStationAt(%v) = %q (error %v), want %q", tt.at, got, err, tt.want)
+			}
+		})
+	}
+}
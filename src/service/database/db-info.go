@@ -6,6 +6,14 @@ import (
 	"time"
 )
 
+var (
+	// ErrTrainNotFound is returned when the train does not exist
+	ErrTrainNotFound = errors.New("Train not found")
+
+	// ErrStationNotFound is returned when the station does not exist
+	ErrStationNotFound = errors.New("Station not found")
+)
+
 // Get stations by name
 func (db *appdbimpl) GetStations(filter string) *[]Station {
 	stations := make([]Station, 0)
@@ -35,7 +43,7 @@ func (db *appdbimpl) getTrainByID(ID string) (*Train, error) {
 			return &db.Trains[i], nil
 		}
 	}
-	return nil, errors.New("Train not found")
+	return nil, ErrTrainNotFound
 }
 
 // Get station by ID
@@ -45,27 +53,47 @@ func (db *appdbimpl) GetStationByID(ID string) (*Station, error) {
 			return &db.Stations[i], nil
 		}
 	}
-	return nil, errors.New("Station not found")
+	return nil, ErrStationNotFound
 }
 
-// Get station by beacon ID
+// Get station by beacon ID, through the station and platform beacons of the registry (or the virtual beacons of the
+// station codes)
 func (db *appdbimpl) GetStationByBeaconID(beaconID string) *Station {
-	for i, station := range db.Stations {
-		if station.BeaconID == beaconID {
-			return &db.Stations[i]
-		}
+	if station := db.getStationByCodeBeacon(beaconID); station != nil {
+		return station
+	}
+
+	beacon := db.getActiveBeacon(beaconID)
+	if beacon == nil || (beacon.Type != BeaconStation && beacon.Type != BeaconPlatform) {
+		return nil
 	}
-	return nil
+
+	station, err := db.GetStationByID(beacon.Owner)
+	if err != nil {
+		return nil
+	}
+	return station
 }
 
-// Get train by beacon ID
-func (db *appdbimpl) GetTrainByBeaconID(beaconID string) *Train {
-	for i, train := range db.Trains {
-		if train.BeaconID == beaconID {
-			return &db.Trains[i]
-		}
+// Get train by beacon ID, through the train car beacons of the registry, or through the vehicle assignment in force
+// at the given time for the vehicle beacons
+func (db *appdbimpl) GetTrainByBeaconID(beaconID string, at time.Time) *Train {
+	beacon := db.getActiveBeacon(beaconID)
+	if beacon == nil {
+		return nil
+	}
+	if beacon.Type == BeaconVehicle {
+		return db.getTrainByVehicle(beacon.Owner, at)
+	}
+	if beacon.Type != BeaconTrainCar {
+		return nil
+	}
+
+	train, err := db.getTrainByID(beacon.Owner)
+	if err != nil {
+		return nil
 	}
-	return nil
+	return train
 }
 
 // Get the departure timetable for a station
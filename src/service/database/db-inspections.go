@@ -0,0 +1,299 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+const (
+	InspectionValid            string = "valid"
+	InspectionNoTicket                = "no_ticket"
+	InspectionUnknownTicket           = "unknown_ticket"
+	InspectionInvalidSignature        = "invalid_signature"
+	InspectionRevoked                 = "revoked"
+	InspectionExpired                 = "expired"
+	InspectionCompleted               = "completed"
+	InspectionWrongTrain              = "wrong_train"
+)
+
+// defaultFineAmount is used when no fine amount has been configured
+const defaultFineAmount int64 = 5000
+
+var (
+	// ErrInspectorNotOnTrain is returned when inspecting a ticket while the inspector is not on a train
+	ErrInspectorNotOnTrain = errors.New("The inspector is not on a train")
+
+	// ErrInspectorOnOtherTrain is returned when the train of an inspection is not the one the inspector is on
+	ErrInspectorOnOtherTrain = errors.New("The inspector is not on this train")
+
+	// ErrInspectionNotFound is returned when the inspection does not exist
+	ErrInspectionNotFound = errors.New("Inspection not found")
+
+	// ErrFineNotAllowed is returned when fining a passenger whose ticket was valid
+	ErrFineNotAllowed = errors.New("A passenger with a valid ticket can't be fined")
+
+	// ErrFineAlreadyIssued is returned when fining a passenger twice for the same inspection
+	ErrFineAlreadyIssued = errors.New("A fine has already been issued for this inspection")
+
+	// ErrFinePassengerMismatch is returned when fining someone else than the owner of the scanned ticket
+	ErrFinePassengerMismatch = errors.New("The passenger of the inspection can't be changed")
+
+	// ErrPassengerNotOnTrain is returned when fining a passenger that is not on the train of the inspection
+	ErrPassengerNotOnTrain = errors.New("The passenger is not on the train of the inspection")
+)
+
+// Inspection is the record of a ticket check made by an inspector on board
+type Inspection struct {
+	ID          string `json:"id"`
+	Inspector   string `json:"inspector"`
+	TrainID     string `json:"train_id"`
+	RunDate     string `json:"run_date"`
+	Station     string `json:"station"`
+	Time        string `json:"time"`
+	TicketID    string `json:"ticket_id"`
+	PassengerID string `json:"passenger_id"`
+	Outcome     string `json:"outcome"`
+	FineID      string `json:"fine_id"`
+}
+
+type TrainInspectionStats struct {
+	TrainID        string         `json:"train_id"`
+	Inspections    int            `json:"inspections"`
+	Valid          int            `json:"valid"`
+	Invalid        int            `json:"invalid"`
+	Outcomes       map[string]int `json:"outcomes"`
+	Fines          int            `json:"fines"`
+	FinesAmount    int64          `json:"fines_amount"`
+	Inspectors     int            `json:"inspectors"`
+	LastInspection string         `json:"last_inspection"`
+}
+
+// Get the outcome of an inspection from the result of the ticket validation
+func inspectionOutcome(err error) (string, bool) {
+	switch {
+	case err == nil:
+		return InspectionValid, true
+	case errors.Is(err, ErrTicketNotFound):
+		return InspectionUnknownTicket, true
+	case errors.Is(err, ErrInvalidTicketSignature):
+		return InspectionInvalidSignature, true
+	case errors.Is(err, ErrTicketRevoked):
+		return InspectionRevoked, true
+	case errors.Is(err, ErrTicketExpired):
+		return InspectionExpired, true
+	case errors.Is(err, ErrTicketCompleted):
+		return InspectionCompleted, true
+	}
+	return "", false
+}
+
+// Check a ticket on board and record the inspection. The train is always the one the inspector is on: if a train is
+// given, it must be that one. An empty code means that the passenger has no ticket at all.
+func (db *appdbimpl) InspectTicket(inspector string, trainID string, code string) (*Inspection, error) {
+
+	state := db.GetUserPosition(inspector)
+
+	if state == nil || state.Status != InTrain || state.Train == nil {
+		return nil, ErrInspectorNotOnTrain
+	}
+
+	if trainID != "" && !strings.EqualFold(trainID, state.Train.ID) {
+		return nil, ErrInspectorOnOtherTrain
+	}
+
+	train, err := db.getTrainByID(state.Train.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	inspectionID, err := uuid.NewV4()
+
+	if err != nil {
+		return nil, err
+	}
+
+	station, err := findLastStation(*train)
+
+	if err != nil {
+		// the train hasn't arrived to any station: we suppose that it is on the first station
+		station = (*train.Trip)[0].Station
+	}
+
+	now := time.Now()
+
+	inspection := Inspection{
+		ID:        inspectionID.String(),
+		Inspector: inspector,
+		TrainID:   train.ID,
+		RunDate:   now.Format("2006-01-02"),
+		Station:   station.Name,
+		Time:      now.Format(time.RFC3339),
+		Outcome:   InspectionNoTicket,
+	}
+
+	if code != "" {
+		ticket, err := db.ValidateTicket(code)
+
+		outcome, ok := inspectionOutcome(err)
+
+		if !ok {
+			return nil, err
+		}
+
+		inspection.Outcome = outcome
+
+		if ticket != nil {
+			inspection.TicketID = ticket.ID
+			inspection.PassengerID = ticket.UserID
+
+			// a valid ticket for another train is not valid here
+			if outcome == InspectionValid && ticket.TrainID != train.ID {
+				inspection.Outcome = InspectionWrongTrain
+			}
+		}
+	}
+
+	db.Inspections = append(db.Inspections, inspection)
+
+	err = db.Write()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &inspection, nil
+}
+
+// Fine a passenger found without a valid ticket. The passenger is the owner of the scanned ticket: it can only be given
+// when no ticket was scanned, and the given passenger must be on the inspected train.
+// The fine goes into the payment history of the passenger, and is charged to the wallet like a fare.
+func (db *appdbimpl) IssueFine(inspectionID string, passengerID string) (*PaymentResponse, error) {
+
+	inspection_idx := -1
+	for k, v := range db.Inspections {
+		if v.ID == inspectionID {
+			inspection_idx = k
+			break
+		}
+	}
+
+	if inspection_idx == -1 {
+		return nil, ErrInspectionNotFound
+	}
+
+	inspection := db.Inspections[inspection_idx]
+
+	if inspection.Outcome == InspectionValid {
+		return nil, ErrFineNotAllowed
+	}
+
+	if inspection.FineID != "" {
+		return nil, ErrFineAlreadyIssued
+	}
+
+	// the passenger can only be given when no ticket was scanned, and must be on the train that was inspected
+	if inspection.PassengerID != "" {
+		if passengerID != "" && passengerID != inspection.PassengerID {
+			return nil, ErrFinePassengerMismatch
+		}
+		passengerID = inspection.PassengerID
+	} else if _, err := db.GetUser(passengerID); err != nil {
+		return nil, ErrUserNotFound
+	} else if state := db.GetUserPosition(passengerID); state == nil || state.Status != InTrain || state.Train == nil ||
+		!strings.EqualFold(state.Train.ID, inspection.TrainID) {
+		return nil, ErrPassengerNotOnTrain
+	}
+
+	amount := db.cfg.FineAmount
+	if amount <= 0 {
+		amount = defaultFineAmount
+	}
+
+	payment, err := db.chargePayment(passengerID, PaymentResponse{
+		Type:           PaymentTypeFine,
+		IdempotencyKey: "fine/" + inspection.ID,
+		Cost:           float64(amount) / 100,
+		TrainID:        inspection.TrainID,
+		Date:           time.Now().Format("01/02/2006"),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	db.Inspections[inspection_idx].PassengerID = passengerID
+	db.Inspections[inspection_idx].FineID = payment.ID
+
+	err = db.Write()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+// Get the inspection statistics of each train, for the inspections made between from and to (zero values mean no
+// limit)
+func (db *appdbimpl) GetInspectionStats(from time.Time, to time.Time) []TrainInspectionStats {
+	trains := make(map[string]*TrainInspectionStats)
+	inspectors := make(map[string]map[string]bool)
+
+	for _, inspection := range db.Inspections {
+		inspectionTime, err := time.Parse(time.RFC3339, inspection.Time)
+		if err != nil {
+			continue
+		}
+		if !from.IsZero() && inspectionTime.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !inspectionTime.Before(to) {
+			continue
+		}
+
+		stats, ok := trains[inspection.TrainID]
+		if !ok {
+			stats = &TrainInspectionStats{
+				TrainID:  inspection.TrainID,
+				Outcomes: make(map[string]int),
+			}
+			trains[inspection.TrainID] = stats
+			inspectors[inspection.TrainID] = make(map[string]bool)
+		}
+
+		stats.Inspections++
+		stats.Outcomes[inspection.Outcome]++
+		if inspection.Outcome == InspectionValid {
+			stats.Valid++
+		} else {
+			stats.Invalid++
+		}
+
+		if inspection.FineID != "" {
+			stats.Fines++
+			if payment_idx := db.indexPaymentByID(inspection.PassengerID, inspection.FineID); payment_idx != -1 {
+				stats.FinesAmount += toCents(db.PaymentHistory[inspection.PassengerID][payment_idx].Cost)
+			}
+		}
+
+		inspectors[inspection.TrainID][inspection.Inspector] = true
+
+		// inspections are stored in chronological order
+		stats.LastInspection = inspection.Time
+	}
+
+	result := make([]TrainInspectionStats, 0, len(trains))
+	for trainID, stats := range trains {
+		stats.Inspectors = len(inspectors[trainID])
+		result = append(result, *stats)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].TrainID < result[j].TrainID })
+
+	return result
+}
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestInspectTicketTrain(t *testing.T) {
+	tests := []struct {
+		name      string
+		inspector *UserState
+		trainID   string
+		wantTrain string
+		wantErr   error
+	}{
+		{name: "train of the inspector", inspector: &UserState{Status: InTrain}, wantTrain: "FR9422"},
+		{name: "same train given", inspector: &UserState{Status: InTrain}, trainID: "fr9422", wantTrain: "FR9422"},
+		{name: "other train given", inspector: &UserState{Status: InTrain}, trainID: "IC774", wantErr: ErrInspectorOnOtherTrain},
+		{name: "inspector in a station", inspector: &UserState{Status: InStation}, trainID: "FR9422", wantErr: ErrInspectorNotOnTrain},
+		{name: "inspector away", inspector: &UserState{Status: Away}, wantErr: ErrInspectorNotOnTrain},
+		{name: "inspector never seen", trainID: "FR9422", wantErr: ErrInspectorNotOnTrain},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t, nil)
+
+			if tt.inspector != nil {
+				switch tt.inspector.Status {
+				case InTrain:
+					tt.inspector.Train = testTrain(t, db, "FR9422")
+					tt.inspector.Station = testStation(t, db, "Napoli Centrale")
+				case InStation:
+					tt.inspector.Station = testStation(t, db, "Napoli Centrale")
+				}
+				db.UserStates["bob"] = tt.inspector
+			}
+
+			inspection, err := db.InspectTicket("bob", tt.trainID, "")
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("InspectTicket() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if len(db.Inspections) != 0 {
+					t.Errorf("the refused inspection was recorded: %+v", db.Inspections)
+				}
+				return
+			}
+
+			if inspection.TrainID != tt.wantTrain {
+				t.Errorf("inspection train = %q, want %q", inspection.TrainID, tt.wantTrain)
+			}
+			if inspection.Outcome != InspectionNoTicket {
+				t.Errorf("inspection outcome = %q, want %q", inspection.Outcome, InspectionNoTicket)
+			}
+		})
+	}
+}
+
+func TestIssueFinePassenger(t *testing.T) {
+	tests := []struct {
+		name      string
+		ticket    bool
+		status    string
+		train     string
+		given     string
+		wantErr   error
+		wantFined string
+	}{
+		{name: "owner of the ticket", ticket: true, wantFined: "alice"},
+		{name: "owner of the ticket given", ticket: true, given: "alice", wantFined: "alice"},
+		{name: "other passenger than the owner of the ticket", ticket: true, status: InTrain, train: "FR9422",
+			given: "carol", wantErr: ErrFinePassengerMismatch},
+		{name: "passenger on the train", status: InTrain, train: "FR9422", given: "carol", wantFined: "carol"},
+		{name: "passenger on another train", status: InTrain, train: "IC774", given: "carol",
+			wantErr: ErrPassengerNotOnTrain},
+		{name: "passenger in a station", status: InStation, given: "carol", wantErr: ErrPassengerNotOnTrain},
+		{name: "passenger never seen", given: "carol", wantErr: ErrPassengerNotOnTrain},
+		{name: "no passenger", wantErr: ErrUserNotFound},
+		{name: "unknown passenger", given: "dave", wantErr: ErrUserNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t, nil)
+			for _, username := range []string{"alice", "carol"} {
+				db.Users[username] = &User{Username: username, Role: RolePassenger}
+			}
+
+			// the inspector is on FR9422, carol is where the test puts her
+			db.UserStates["bob"] = &UserState{
+				Status:  InTrain,
+				Train:   testTrain(t, db, "FR9422"),
+				Station: testStation(t, db, "Napoli Centrale"),
+			}
+			if tt.status != "" {
+				state := &UserState{Status: tt.status, Station: testStation(t, db, "Napoli Centrale")}
+				if tt.train != "" {
+					state.Train = testTrain(t, db, tt.train)
+				}
+				db.UserStates["carol"] = state
+			}
+
+			// a revoked ticket of alice, or no ticket at all
+			code := ""
+			if tt.ticket {
+				ticket, _, err := db.generateTicket("alice", "FR9422", testStation(t, db, "Napoli Centrale"))
+				if err != nil {
+					t.Fatalf("can't generate the ticket: %v", err)
+				}
+				db.setTicketStatus(ticket, TicketRevoked)
+				code = ticket.ID
+			}
+
+			inspection, err := db.InspectTicket("bob", "", code)
+			if err != nil {
+				t.Fatalf("InspectTicket() error = %v", err)
+			}
+
+			fine, err := db.IssueFine(inspection.ID, tt.given)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("IssueFine() error = %v, want %v", err, tt.wantErr)
+			}
+
+			for _, username := range []string{"alice", "carol"} {
+				fined := len(db.PaymentHistory[username]) != 0
+				if want := username == tt.wantFined; fined != want {
+					t.Errorf("%s fined = %v, want %v", username, fined, want)
+				}
+			}
+			if err == nil && db.Inspections[0].FineID != fine.ID {
+				t.Errorf("inspection fine = %q, want %q", db.Inspections[0].FineID, fine.ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gofrs/uuid"
+)
+
+const (
+	JournalFare        string = "fare"
+	JournalTopUp              = "top_up"
+	JournalTopUpRefund        = "top_up_refund"
+	JournalRefund             = "refund"
+	JournalWriteOff           = "write_off"
+	JournalFine               = "fine"
+)
+
+const (
+	// AccountPassengerPrefix is the prefix of the passenger accounts. A passenger account holds the stored value of the
+	// passenger: a credit balance is money owed to the passenger, a debit balance is a debt of the passenger.
+	AccountPassengerPrefix = "passenger:"
+
+	// AccountRevenuePrefix is the prefix of the operator revenue accounts, one for each train category
+	AccountRevenuePrefix = "revenue:"
+
+	// AccountFines is the revenue account of the fines issued on board
+	AccountFines = "fines"
+
+	// AccountRefunds collects the fares given back to the passengers
+	AccountRefunds = "refunds"
+
+	// AccountWriteOffs collects the debts that will never be collected
+	AccountWriteOffs = "write_offs"
+
+	// AccountProviderClearing is the money collected through the payment provider
+	AccountProviderClearing = "provider_clearing"
+)
+
+// ErrPaymentNotWritable is returned when writing off a payment that is not a debt
+var ErrPaymentNotWritable = errors.New("Only unpaid payments can be written off")
+
+// JournalLine is a line of a journal entry. Only one of Debit and Credit is set. Amounts are in euro cents.
+type JournalLine struct {
+	Account string `json:"account"`
+	Debit   int64  `json:"debit"`
+	Credit  int64  `json:"credit"`
+}
+
+// JournalEntry is a balanced entry of the ledger: the sum of the debits is equal to the sum of the credits
+type JournalEntry struct {
+	ID          string        `json:"id"`
+	Time        string        `json:"time"`
+	Type        string        `json:"type"`
+	Reference   string        `json:"reference"`
+	Description string        `json:"description"`
+	Lines       []JournalLine `json:"lines"`
+}
+
+type TrialBalanceItem struct {
+	Account string `json:"account"`
+	Debit   int64  `json:"debit"`
+	Credit  int64  `json:"credit"`
+	Balance int64  `json:"balance"`
+}
+
+type TrialBalance struct {
+	Accounts    []TrialBalanceItem `json:"accounts"`
+	TotalDebit  int64              `json:"total_debit"`
+	TotalCredit int64              `json:"total_credit"`
+}
+
+// Get the account of a passenger
+func passengerAccount(userID string) string {
+	return AccountPassengerPrefix + userID
+}
+
+// Get the revenue account of a train: the category is the alphabetic prefix of the train ID (e.g., FR, IC, R)
+func revenueAccount(trainID string) string {
+	category := strings.TrimRightFunc(strings.ToUpper(trainID), unicode.IsDigit)
+	if category == "" {
+		category = "OTHER"
+	}
+	return AccountRevenuePrefix + category
+}
+
+// Get a journal line that debits the account
+func debit(account string, amount int64) JournalLine {
+	return JournalLine{Account: account, Debit: amount}
+}
+
+// Get a journal line that credits the account
+func credit(account string, amount int64) JournalLine {
+	return JournalLine{Account: account, Credit: amount}
+}
+
+// Post an entry to the ledger, after checking that it is balanced. The caller is responsible for writing the database.
+func (db *appdbimpl) postJournalEntry(entryType string, reference string, description string, lines ...JournalLine) error {
+
+	total := int64(0)
+	for _, line := range lines {
+		if line.Debit < 0 || line.Credit < 0 {
+			return errors.New("Journal lines can't have negative amounts")
+		}
+		total += line.Debit - line.Credit
+	}
+
+	if total != 0 {
+		return fmt.Errorf("Unbalanced journal entry: debits and credits differ by %d", total)
+	}
+
+	entryID, err := uuid.NewV4()
+
+	if err != nil {
+		return err
+	}
+
+	db.Journal = append(db.Journal, JournalEntry{
+		ID:          entryID.String(),
+		Time:        time.Now().Format(time.RFC3339),
+		Type:        entryType,
+		Reference:   reference,
+		Description: description,
+		Lines:       lines,
+	})
+
+	return nil
+}
+
+// Get the ledger entries posted between from and to (zero values mean no limit)
+func (db *appdbimpl) GetJournal(from time.Time, to time.Time) []JournalEntry {
+	entries := make([]JournalEntry, 0)
+	for _, entry := range db.Journal {
+		entryTime, err := time.Parse(time.RFC3339, entry.Time)
+		if err != nil {
+			continue
+		}
+		if !from.IsZero() && entryTime.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !entryTime.Before(to) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Get the trial balance of the ledger: the total debits and credits of each account
+func (db *appdbimpl) GetTrialBalance() *TrialBalance {
+	accounts := make(map[string]*TrialBalanceItem)
+
+	for _, entry := range db.Journal {
+		for _, line := range entry.Lines {
+			item, ok := accounts[line.Account]
+			if !ok {
+				item = &TrialBalanceItem{Account: line.Account}
+				accounts[line.Account] = item
+			}
+			item.Debit += line.Debit
+			item.Credit += line.Credit
+		}
+	}
+
+	trialBalance := TrialBalance{
+		Accounts: make([]TrialBalanceItem, 0, len(accounts)),
+	}
+
+	for _, item := range accounts {
+		item.Balance = item.Debit - item.Credit
+		trialBalance.Accounts = append(trialBalance.Accounts, *item)
+		trialBalance.TotalDebit += item.Debit
+		trialBalance.TotalCredit += item.Credit
+	}
+
+	sort.Slice(trialBalance.Accounts, func(i, j int) bool {
+		return trialBalance.Accounts[i].Account < trialBalance.Accounts[j].Account
+	})
+
+	return &trialBalance
+}
+
+// Write off an unpaid payment: the debt is moved from the passenger account to the write-offs account
+func (db *appdbimpl) WriteOffPayment(userID string, paymentID string) (*PaymentResponse, error) {
+
+	payment_idx := db.indexPaymentByID(userID, paymentID)
+
+	if payment_idx == -1 {
+		return nil, ErrPaymentNotFound
+	}
+
+	payment := db.PaymentHistory[userID][payment_idx]
+
+	if payment.Status != PaymentUnpaid {
+		return nil, ErrPaymentNotWritable
+	}
+
+	amount := toCents(payment.Cost)
+
+	err := db.postJournalEntry(JournalWriteOff, payment.ID, "Write-off: "+paymentDescription(payment),
+		debit(AccountWriteOffs, amount), credit(passengerAccount(userID), amount))
+
+	if err != nil {
+		return nil, err
+	}
+
+	payment.Status = PaymentWrittenOff
+	db.PaymentHistory[userID][payment_idx] = payment
+
+	err = db.Write()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &payment, nil
+}
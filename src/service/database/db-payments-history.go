@@ -1,12 +1,142 @@
 package database
 
-import "errors"
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
 
-func (db *appdbimpl) GetPaymentHistory(userID string) ([]PaymentResponse, error) {
+const (
+	SortByDate     string = "date"
+	SortByDateDesc        = "-date"
+	SortByCost            = "cost"
+	SortByCostDesc        = "-cost"
+)
 
-	if db.PaymentHistory[userID] == nil {
-		return nil, errors.New("User has no payment history")
+var (
+	// ErrInvalidCursor is returned when the pagination cursor does not refer to a payment of the filtered history
+	ErrInvalidCursor = errors.New("Invalid cursor")
+
+	// ErrInvalidSort is returned when the sort order is unknown
+	ErrInvalidSort = errors.New("Invalid sort order")
+)
+
+// PaymentHistoryFilter selects and orders the payments of a user. Zero values mean "no filter".
+type PaymentHistoryFilter struct {
+	// From and To select the payments of trips made between the two dates (both included)
+	From time.Time
+	To   time.Time
+
+	// TrainID selects the payments for a train
+	TrainID string
+
+	// Station selects the payments of trips starting or ending in a station
+	Station string
+
+	// Sort is one of SortByDate (default), SortByDateDesc, SortByCost, SortByCostDesc
+	Sort string
+
+	// Cursor is the cursor returned with the previous page
+	Cursor string
+
+	// Limit is the maximum number of payments in a page
+	Limit int
+}
+
+// Get the time of the trip paid by a payment
+func paymentTime(payment PaymentResponse) time.Time {
+	date, err := time.ParseInLocation("01/02/2006 15:04", payment.Date+" "+payment.ScheduledDepartureTime, time.Local)
+	if err != nil {
+		date, _ = time.ParseInLocation("01/02/2006", payment.Date, time.Local)
+	}
+	return date
+}
+
+// Get the day of the trip paid by a payment
+func paymentDay(payment PaymentResponse) time.Time {
+	date, _ := time.ParseInLocation("01/02/2006", payment.Date, time.Local)
+	return date
+}
+
+func (filter PaymentHistoryFilter) matches(payment PaymentResponse) bool {
+	day := paymentDay(payment)
+
+	if !filter.From.IsZero() && day.Before(filter.From) {
+		return false
+	}
+	if !filter.To.IsZero() && day.After(filter.To) {
+		return false
+	}
+	if filter.TrainID != "" && !strings.EqualFold(payment.TrainID, filter.TrainID) {
+		return false
+	}
+	if filter.Station != "" {
+		from := payment.FromStation != nil && strings.EqualFold(payment.FromStation.Name, filter.Station)
+		to := payment.ToStation != nil && strings.EqualFold(payment.ToStation.Name, filter.Station)
+		if !from && !to {
+			return false
+		}
+	}
+	return true
+}
+
+// Get the payment history of a user, filtered, sorted and paginated. The second value returned is the cursor of the
+// next page, empty if this is the last one. A user without trips has an empty history.
+func (db *appdbimpl) GetPaymentHistory(userID string, filter PaymentHistoryFilter) ([]PaymentResponse, string, error) {
+
+	history := make([]PaymentResponse, 0)
+	for _, payment := range db.PaymentHistory[userID] {
+		if filter.matches(payment) {
+			history = append(history, payment)
+		}
+	}
+
+	var less func(i, j int) bool
+	switch filter.Sort {
+	case "", SortByDate:
+		less = func(i, j int) bool { return paymentTime(history[i]).Before(paymentTime(history[j])) }
+	case SortByDateDesc:
+		less = func(i, j int) bool { return paymentTime(history[i]).After(paymentTime(history[j])) }
+	case SortByCost:
+		less = func(i, j int) bool { return history[i].Cost < history[j].Cost }
+	case SortByCostDesc:
+		less = func(i, j int) bool { return history[i].Cost > history[j].Cost }
+	default:
+		return nil, "", ErrInvalidSort
+	}
+
+	// payments are stored in chronological order: a stable sort keeps it for ties
+	sort.SliceStable(history, less)
+
+	// the cursor is the (encoded) ID of the last payment of the previous page
+	if filter.Cursor != "" {
+		lastID, err := base64.RawURLEncoding.DecodeString(filter.Cursor)
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+
+		start := -1
+		for k, v := range history {
+			if v.ID == string(lastID) {
+				start = k + 1
+				break
+			}
+		}
+
+		if start == -1 {
+			return nil, "", ErrInvalidCursor
+		}
+
+		history = history[start:]
+	}
+
+	next := ""
+	if filter.Limit > 0 && len(history) > filter.Limit {
+		history = history[:filter.Limit]
+		next = base64.RawURLEncoding.EncodeToString([]byte(history[len(history)-1].ID))
 	}
 
-	return db.PaymentHistory[userID], nil
+	return history, next, nil
 }
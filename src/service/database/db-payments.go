@@ -0,0 +1,219 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ami-sc/DajeTrains/service/payments"
+	"github.com/gofrs/uuid"
+)
+
+// defaultPaymentTimeout is used when no timeout has been configured
+const defaultPaymentTimeout = 5 * time.Second
+
+var (
+	// ErrPaymentNotFound is returned when the payment does not exist in the user payment history
+	ErrPaymentNotFound = errors.New("Payment not found")
+
+	// ErrPaymentNotRefundable is returned when refunding a payment that has not been captured
+	ErrPaymentNotRefundable = errors.New("Only captured payments can be refunded")
+
+	// ErrInsufficientFunds is used when the wallet balance can't cover a fare, or the refund of a top up
+	ErrInsufficientFunds = errors.New("Insufficient funds")
+)
+
+// Convert an amount in euros to euro cents
+func toCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// Build the idempotency key of a trip: the same user can't pay twice for the same segment of the same train run
+func tripIdempotencyKey(userID string, payment PaymentResponse) string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s", userID, payment.TrainID, payment.FromStation.Name, payment.ToStation.Name, payment.Date)
+}
+
+// Describe a payment in the ledger, e.g. "Fare FR9422 Roma Termini - Firenze S. M. Novella"
+func paymentDescription(payment PaymentResponse) string {
+	if payment.Type == PaymentTypeFine {
+		return fmt.Sprintf("Fine %s", payment.TrainID)
+	}
+	return fmt.Sprintf("Fare %s %s - %s", payment.TrainID, payment.FromStation.Name, payment.ToStation.Name)
+}
+
+// Get a context for a call to the payment provider
+func (db *appdbimpl) paymentContext() (context.Context, context.CancelFunc) {
+	timeout := db.cfg.PaymentTimeout
+	if timeout <= 0 {
+		timeout = defaultPaymentTimeout
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// Find a payment in the user history by idempotency key
+func (db *appdbimpl) indexPaymentByKey(userID string, key string) int {
+	for k, v := range db.PaymentHistory[userID] {
+		if v.IdempotencyKey == key {
+			return k
+		}
+	}
+	return -1
+}
+
+// Find a payment in the user history by ID
+func (db *appdbimpl) indexPaymentByID(userID string, paymentID string) int {
+	for k, v := range db.PaymentHistory[userID] {
+		if v.ID == paymentID {
+			return k
+		}
+	}
+	return -1
+}
+
+// Charge a fare (or a fine) to the user wallet and store it in the user history, along with its state. If the balance
+// can't cover the amount, the payment is stored as unpaid and becomes a debt of the user. Fares are identified by the
+// trip, fines must come with their own idempotency key.
+func (db *appdbimpl) chargePayment(userID string, payment PaymentResponse) (*PaymentResponse, error) {
+
+	if payment.IdempotencyKey == "" {
+		payment.IdempotencyKey = tripIdempotencyKey(userID, payment)
+	}
+
+	payment_idx := db.indexPaymentByKey(userID, payment.IdempotencyKey)
+
+	if payment_idx != -1 {
+		// the trip has already been charged (or it is already a debt)
+		previous := db.PaymentHistory[userID][payment_idx]
+		return &previous, nil
+	}
+
+	paymentID, err := uuid.NewV4()
+
+	if err != nil {
+		return nil, err
+	}
+
+	payment.ID = paymentID.String()
+
+	amount := toCents(payment.Cost)
+	wallet := db.getWallet(userID)
+
+	// fines are penalties, not services: no VAT is due
+	entryType, revenue, movementType, vatRate := JournalFare, revenueAccount(payment.TrainID), WalletFare, db.cfg.VATRate
+	if payment.Type == PaymentTypeFine {
+		entryType, revenue, movementType, vatRate = JournalFine, AccountFines, WalletFine, 0
+	}
+
+	payment.VAT = vatBreakdown(amount, vatRate)
+
+	// the fare is revenue of the operator even when the passenger can't pay it (yet): in that case, the passenger
+	// account goes into debit
+	err = db.postJournalEntry(entryType, payment.ID, paymentDescription(payment),
+		debit(passengerAccount(userID), amount), credit(revenue, amount))
+
+	if err != nil {
+		return nil, err
+	}
+
+	// a receipt is issued once the payment is collected: debts get theirs when they are settled
+	if wallet.Balance >= amount {
+		db.addWalletMovement(wallet, movementType, -amount, payment.ID)
+		payment.Status = PaymentCaptured
+		payment.ReceiptNumber = db.nextReceiptNumber()
+	} else {
+		payment.Status = PaymentUnpaid
+		payment.FailureReason = ErrInsufficientFunds.Error()
+	}
+
+	if db.PaymentHistory[userID] == nil {
+		db.PaymentHistory[userID] = make([]PaymentResponse, 0)
+	}
+	db.PaymentHistory[userID] = append(db.PaymentHistory[userID], payment)
+
+	err = db.Write()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &payment, nil
+}
+
+// Charge an amount through the payment provider, following the authorize/capture lifecycle. It returns the state of
+// the charge, the authorization ID (if any) and the reason of the failure (if any).
+func (db *appdbimpl) collectPayment(req payments.AuthorizationRequest) (string, string, string) {
+
+	ctx, cancel := db.paymentContext()
+	defer cancel()
+
+	auth, err := db.cfg.PaymentProvider.Authorize(ctx, req)
+
+	if err != nil {
+		return PaymentFailed, "", err.Error()
+	}
+
+	// a charge that is tried again gets the authorization of the previous try, which may have been captured (or
+	// voided) since
+	if auth.Voided {
+		return PaymentFailed, auth.ID, payments.ErrInvalidState.Error()
+	} else if auth.Captured >= req.Amount {
+		return PaymentCaptured, auth.ID, ""
+	}
+
+	err = db.cfg.PaymentProvider.Capture(ctx, auth.ID, req.Amount)
+
+	if errors.Is(err, payments.ErrTimeout) {
+		// we don't know whether the amount has been captured or not:
+		// the charge stays pending, so that it is not collected again
+		return PaymentPending, auth.ID, err.Error()
+	} else if err != nil {
+		// release the reserved amount
+		voidCtx, voidCancel := db.paymentContext()
+		_ = db.cfg.PaymentProvider.Void(voidCtx, auth.ID)
+		voidCancel()
+
+		return PaymentFailed, auth.ID, err.Error()
+	}
+
+	return PaymentCaptured, auth.ID, ""
+}
+
+// Refund a captured payment to the user wallet
+func (db *appdbimpl) RefundPayment(userID string, paymentID string) (*PaymentResponse, error) {
+
+	payment_idx := db.indexPaymentByID(userID, paymentID)
+
+	if payment_idx == -1 {
+		return nil, ErrPaymentNotFound
+	}
+
+	payment := db.PaymentHistory[userID][payment_idx]
+
+	if payment.Status != PaymentCaptured {
+		return nil, ErrPaymentNotRefundable
+	}
+
+	amount := toCents(payment.Cost)
+
+	err := db.postJournalEntry(JournalRefund, payment.ID, "Refund: "+paymentDescription(payment),
+		debit(AccountRefunds, amount), credit(passengerAccount(userID), amount))
+
+	if err != nil {
+		return nil, err
+	}
+
+	db.addWalletMovement(db.getWallet(userID), WalletRefund, amount, payment.ID)
+
+	payment.Status = PaymentRefunded
+	db.PaymentHistory[userID][payment_idx] = payment
+
+	err = db.Write()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &payment, nil
+}
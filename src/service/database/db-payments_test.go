@@ -0,0 +1,628 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ami-sc/DajeTrains/service/payments"
+)
+
+// captureFailingProvider is a fake provider whose captures fail with the given error (if any), recording the voided
+// authorizations. If captured is set, the amount is captured anyway, as when the answer of the provider is lost.
+type captureFailingProvider struct {
+	*payments.Fake
+	err      error
+	captured bool
+	voided   []string
+}
+
+func (p *captureFailingProvider) Capture(ctx context.Context, authorizationID string, amount int64) error {
+	if p.err == nil || p.captured {
+		if err := p.Fake.Capture(ctx, authorizationID, amount); err != nil {
+			return err
+		}
+	}
+	return p.err
+}
+
+func (p *captureFailingProvider) Void(ctx context.Context, authorizationID string) error {
+	p.voided = append(p.voided, authorizationID)
+	return p.Fake.Void(ctx, authorizationID)
+}
+
+// newTestFake creates a fake provider, failing the test if the configuration is refused
+func newTestFake(t *testing.T, mode string) *payments.Fake {
+	t.Helper()
+
+	fake, err := payments.NewFake(payments.FakeConfig{Mode: mode})
+	if err != nil {
+		t.Fatalf("can't create the payment provider: %v", err)
+	}
+	return fake
+}
+
+func TestCollectPayment(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider func(t *testing.T) payments.Provider
+
+		wantStatus        string
+		wantAuthorization bool
+		wantFailure       bool
+		wantVoid          bool
+	}{
+		{
+			name:              "captured",
+			provider:          func(t *testing.T) payments.Provider { return newTestFake(t, payments.FakeApprove) },
+			wantStatus:        PaymentCaptured,
+			wantAuthorization: true,
+		},
+		{
+			name:        "declined",
+			provider:    func(t *testing.T) payments.Provider { return newTestFake(t, payments.FakeDecline) },
+			wantStatus:  PaymentFailed,
+			wantFailure: true,
+		},
+		{
+			name:        "authorization timed out",
+			provider:    func(t *testing.T) payments.Provider { return newTestFake(t, payments.FakeTimeout) },
+			wantStatus:  PaymentFailed,
+			wantFailure: true,
+		},
+		{
+			// the amount may have been captured: the charge must not be collected again
+			name: "capture timed out",
+			provider: func(t *testing.T) payments.Provider {
+				return &captureFailingProvider{Fake: newTestFake(t, payments.FakeApprove), err: payments.ErrTimeout}
+			},
+			wantStatus:        PaymentPending,
+			wantAuthorization: true,
+			wantFailure:       true,
+		},
+		{
+			name: "capture refused",
+			provider: func(t *testing.T) payments.Provider {
+				return &captureFailingProvider{Fake: newTestFake(t, payments.FakeApprove), err: payments.ErrInvalidState}
+			},
+			wantStatus:        PaymentFailed,
+			wantAuthorization: true,
+			wantFailure:       true,
+			wantVoid:          true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := tt.provider(t)
+			db := newTestDatabase(t, provider)
+			db.cfg.PaymentTimeout = 20 * time.Millisecond
+
+			status, authorizationID, failure := db.collectPayment(payments.AuthorizationRequest{
+				IdempotencyKey: "k",
+				CustomerID:     "alice",
+				Amount:         500,
+			})
+
+			if status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", status, tt.wantStatus)
+			}
+			if got := authorizationID != ""; got != tt.wantAuthorization {
+				t.Errorf("authorization = %q, want one: %v", authorizationID, tt.wantAuthorization)
+			}
+			if got := failure != ""; got != tt.wantFailure {
+				t.Errorf("failure reason = %q, want one: %v", failure, tt.wantFailure)
+			}
+
+			voided := false
+			if failing, ok := provider.(*captureFailingProvider); ok {
+				voided = len(failing.voided) == 1 && failing.voided[0] == authorizationID
+			}
+			if voided != tt.wantVoid {
+				t.Errorf("authorization voided = %v, want %v", voided, tt.wantVoid)
+			}
+		})
+	}
+}
+
+func TestTopUpStates(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		wantStatus  string
+		wantBalance int64
+	}{
+		{"approved", payments.FakeApprove, PaymentCaptured, 1000},
+		{"declined", payments.FakeDecline, PaymentFailed, 0},
+		{"timed out", payments.FakeTimeout, PaymentFailed, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t, newTestFake(t, tt.mode))
+			db.cfg.PaymentTimeout = 20 * time.Millisecond
+
+			started, charge, err := db.StartTopUp("alice", 1000, "k")
+			if err != nil || !charge {
+				t.Fatalf("StartTopUp() = %v, %v, want a top up to charge", charge, err)
+			}
+			if started.Status != PaymentPending {
+				t.Errorf("started top up status = %q, want %q", started.Status, PaymentPending)
+			}
+
+			topUp, err := db.CompleteTopUp("alice", db.ChargeTopUp("alice", *started))
+			if err != nil {
+				t.Fatalf("CompleteTopUp() error = %v", err)
+			}
+			if topUp.Status != tt.wantStatus {
+				t.Errorf("top up status = %q, want %q", topUp.Status, tt.wantStatus)
+			}
+			if balance := db.GetWallet("alice").Balance; balance != tt.wantBalance {
+				t.Errorf("balance = %d, want %d", balance, tt.wantBalance)
+			}
+
+			// a retry with the same key is charged again only if the top up failed
+			retry, charge, err := db.StartTopUp("alice", 1000, "k")
+			if err != nil {
+				t.Fatalf("StartTopUp() retry error = %v", err)
+			}
+			if wantCharge := tt.wantStatus == PaymentFailed; charge != wantCharge {
+				t.Errorf("retry charged = %v, want %v", charge, wantCharge)
+			}
+			if !charge && retry.ID != topUp.ID {
+				t.Errorf("retry = %+v, want the previous top up %+v", retry, topUp)
+			}
+		})
+	}
+}
+
+// switchingProvider is a payment provider that can be replaced between the tries of a top up
+type switchingProvider struct {
+	payments.Provider
+}
+
+// topUp charges a top up of 1000 of alice through the whole lifecycle, as the API does
+func topUp(t *testing.T, db *appdbimpl, key string) (*TopUp, bool) {
+	t.Helper()
+
+	started, charge, err := db.StartTopUp("alice", 1000, key)
+	if err != nil {
+		t.Fatalf("StartTopUp() error = %v", err)
+	}
+	if !charge {
+		return started, false
+	}
+
+	completed, err := db.CompleteTopUp("alice", db.ChargeTopUp("alice", *started))
+	if err != nil {
+		t.Fatalf("CompleteTopUp() error = %v", err)
+	}
+	return completed, true
+}
+
+func TestTopUpRetries(t *testing.T) {
+	approve := func(t *testing.T) payments.Provider { return newTestFake(t, payments.FakeApprove) }
+
+	tests := []struct {
+		name string
+
+		// first is the provider of the first try; the retry is made with then, or with the same provider changed by
+		// fix if then is nil
+		first func(t *testing.T) payments.Provider
+		then  func(t *testing.T) payments.Provider
+		fix   func(p payments.Provider)
+
+		// restart tells whether the server stops between the tries, before completing the first one
+		restart bool
+
+		wantFirst   string
+		wantCharged bool
+		wantRetry   string
+		wantBalance int64
+	}{
+		{
+			name:        "declined",
+			first:       func(t *testing.T) payments.Provider { return newTestFake(t, payments.FakeDecline) },
+			then:        approve,
+			wantFirst:   PaymentFailed,
+			wantCharged: true,
+			wantRetry:   PaymentCaptured,
+			wantBalance: 1000,
+		},
+		{
+			name:        "authorization timed out",
+			first:       func(t *testing.T) payments.Provider { return newTestFake(t, payments.FakeTimeout) },
+			then:        approve,
+			wantFirst:   PaymentFailed,
+			wantCharged: true,
+			wantRetry:   PaymentCaptured,
+			wantBalance: 1000,
+		},
+		{
+			// the authorization of the first try has been voided: the retry needs a new one
+			name: "capture refused",
+			first: func(t *testing.T) payments.Provider {
+				return &captureFailingProvider{Fake: newTestFake(t, payments.FakeApprove), err: payments.ErrInvalidState}
+			},
+			fix:         func(p payments.Provider) { p.(*captureFailingProvider).err = nil },
+			wantFirst:   PaymentFailed,
+			wantCharged: true,
+			wantRetry:   PaymentCaptured,
+			wantBalance: 1000,
+		},
+		{
+			name: "capture timed out",
+			first: func(t *testing.T) payments.Provider {
+				return &captureFailingProvider{Fake: newTestFake(t, payments.FakeApprove), err: payments.ErrTimeout}
+			},
+			fix:         func(p payments.Provider) { p.(*captureFailingProvider).err = nil },
+			wantFirst:   PaymentPending,
+			wantCharged: true,
+			wantRetry:   PaymentCaptured,
+			wantBalance: 1000,
+		},
+		{
+			// the amount is credited once, without capturing it again
+			name: "capture timed out after capturing",
+			first: func(t *testing.T) payments.Provider {
+				return &captureFailingProvider{Fake: newTestFake(t, payments.FakeApprove), err: payments.ErrTimeout, captured: true}
+			},
+			fix:         func(p payments.Provider) { p.(*captureFailingProvider).err = payments.ErrInvalidState },
+			wantFirst:   PaymentPending,
+			wantCharged: true,
+			wantRetry:   PaymentCaptured,
+			wantBalance: 1000,
+		},
+		{
+			name:        "server stopped while charging",
+			first:       approve,
+			fix:         func(p payments.Provider) {},
+			restart:     true,
+			wantFirst:   PaymentPending,
+			wantCharged: true,
+			wantRetry:   PaymentCaptured,
+			wantBalance: 1000,
+		},
+		{
+			name:        "captured",
+			first:       approve,
+			fix:         func(p payments.Provider) {},
+			wantFirst:   PaymentCaptured,
+			wantCharged: false,
+			wantRetry:   PaymentCaptured,
+			wantBalance: 1000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &switchingProvider{Provider: tt.first(t)}
+			db := newTestDatabase(t, provider)
+			db.cfg.PaymentTimeout = 20 * time.Millisecond
+
+			var first *TopUp
+			if tt.restart {
+				started, _, err := db.StartTopUp("alice", 1000, "k")
+				if err != nil {
+					t.Fatalf("StartTopUp() error = %v", err)
+				}
+				first = started
+
+				loaded, err := Load(db.filename, db.cfg)
+				if err != nil {
+					t.Fatalf("Load() error = %v", err)
+				}
+				db = loaded.(*appdbimpl)
+			} else {
+				first, _ = topUp(t, db, "k")
+			}
+			if first.Status != tt.wantFirst {
+				t.Fatalf("first top up status = %q, want %q", first.Status, tt.wantFirst)
+			}
+
+			if tt.then != nil {
+				provider.Provider = tt.then(t)
+			} else {
+				tt.fix(provider.Provider)
+			}
+
+			retry, charged := topUp(t, db, "k")
+			if charged != tt.wantCharged {
+				t.Errorf("retry charged = %v, want %v", charged, tt.wantCharged)
+			}
+			if retry.Status != tt.wantRetry {
+				t.Errorf("retry status = %q (%s), want %q", retry.Status, retry.FailureReason, tt.wantRetry)
+			}
+			if balance := db.GetWallet("alice").Balance; balance != tt.wantBalance {
+				t.Errorf("balance = %d, want %d", balance, tt.wantBalance)
+			}
+
+			// a pending top up is charged again as the same top up, a failed one as a new top up
+			if wantSame := tt.wantFirst != PaymentFailed; (retry.ID == first.ID) != wantSame {
+				t.Errorf("retry top up %s, first top up %s, want the same: %v", retry.ID, first.ID, wantSame)
+			}
+		})
+	}
+
+	t.Run("retry while charging", func(t *testing.T) {
+		db := newTestDatabase(t, nil)
+
+		started, charge, err := db.StartTopUp("alice", 1000, "k")
+		if err != nil || !charge {
+			t.Fatalf("StartTopUp() = %v, %v, want a top up to charge", charge, err)
+		}
+
+		// the first request is still waiting for the provider
+		retry, charge, err := db.StartTopUp("alice", 1000, "k")
+		if err != nil || charge || retry.ID != started.ID {
+			t.Errorf("StartTopUp() retry = %+v, %v, %v, want the pending top up, not to charge", retry, charge, err)
+		}
+
+		// a late completion doesn't change a completed top up
+		charged := db.ChargeTopUp("alice", *started)
+		if _, err := db.CompleteTopUp("alice", charged); err != nil {
+			t.Fatalf("CompleteTopUp() error = %v", err)
+		}
+		charged.Status = PaymentFailed
+		if completed, err := db.CompleteTopUp("alice", charged); err != nil || completed.Status != PaymentCaptured {
+			t.Errorf("CompleteTopUp() again = %+v, %v, want the captured top up", completed, err)
+		}
+		if balance := db.GetWallet("alice").Balance; balance != 1000 {
+			t.Errorf("balance = %d, want 1000", balance)
+		}
+	})
+}
+
+func TestCompleteUnknownTopUp(t *testing.T) {
+	db := newTestDatabase(t, nil)
+
+	_, err := db.CompleteTopUp("alice", TopUp{ID: "unknown", Status: PaymentCaptured, Amount: 1000})
+	if !errors.Is(err, ErrTopUpNotFound) {
+		t.Errorf("CompleteTopUp() error = %v, want %v", err, ErrTopUpNotFound)
+	}
+	if balance := db.GetWallet("alice").Balance; balance != 0 {
+		t.Errorf("balance = %d, want 0", balance)
+	}
+}
+
+// fare builds a fare of FR9422 from Napoli Centrale to Roma Termini
+func fare(t *testing.T, db *appdbimpl, cost float64) PaymentResponse {
+	t.Helper()
+
+	return PaymentResponse{
+		Type:        PaymentTypeFare,
+		Cost:        cost,
+		TrainID:     "FR9422",
+		FromStation: testStation(t, db, "Napoli Centrale"),
+		ToStation:   testStation(t, db, "Roma Termini"),
+		Date:        "10/19/2026",
+	}
+}
+
+func TestChargePaymentStates(t *testing.T) {
+	tests := []struct {
+		name        string
+		balance     int64
+		wantStatus  string
+		wantBalance int64
+		wantDebt    int64
+		wantReceipt bool
+	}{
+		{"paid by the wallet", 1000, PaymentCaptured, 450, 0, true},
+		{"paid with the exact balance", 550, PaymentCaptured, 0, 0, true},
+		{"unpaid", 500, PaymentUnpaid, 500, 550, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t, nil)
+			db.addWalletMovement(db.getWallet("alice"), WalletTopUp, tt.balance, "")
+
+			payment, err := db.chargePayment("alice", fare(t, db, 5.5))
+			if err != nil {
+				t.Fatalf("chargePayment() error = %v", err)
+			}
+			if payment.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", payment.Status, tt.wantStatus)
+			}
+			if hasReceipt := payment.ReceiptNumber != ""; hasReceipt != tt.wantReceipt {
+				t.Errorf("receipt number %q, want a receipt %v", payment.ReceiptNumber, tt.wantReceipt)
+			}
+			if wallet := db.GetWallet("alice"); wallet.Balance != tt.wantBalance || wallet.Debt != tt.wantDebt {
+				t.Errorf("wallet = %d balance, %d debt, want %d, %d", wallet.Balance, wallet.Debt, tt.wantBalance, tt.wantDebt)
+			}
+
+			// the same trip is charged once
+			again, err := db.chargePayment("alice", fare(t, db, 5.5))
+			if err != nil {
+				t.Fatalf("chargePayment() retry error = %v", err)
+			}
+			if again.ID != payment.ID || len(db.PaymentHistory["alice"]) != 1 {
+				t.Errorf("the retry charged the trip again: %+v", db.PaymentHistory["alice"])
+			}
+		})
+	}
+}
+
+func TestSettleDebtsIssuesReceipts(t *testing.T) {
+	db := newTestDatabase(t, nil)
+
+	first, err := db.chargePayment("alice", fare(t, db, 5.5))
+	if err != nil {
+		t.Fatalf("chargePayment() error = %v", err)
+	}
+	second, err := db.chargePayment("alice", PaymentResponse{Type: PaymentTypeFine, IdempotencyKey: "fine/1", Cost: 50})
+	if err != nil {
+		t.Fatalf("chargePayment() error = %v", err)
+	}
+	if first.ReceiptNumber != "" || second.ReceiptNumber != "" {
+		t.Fatalf("receipt numbers %q, %q issued to debts", first.ReceiptNumber, second.ReceiptNumber)
+	}
+
+	// the balance covers the first debt only
+	db.addWalletMovement(db.getWallet("alice"), WalletTopUp, 1000, "")
+	db.settleDebts("alice")
+
+	history := db.PaymentHistory["alice"]
+	if history[0].Status != PaymentCaptured || history[0].ReceiptNumber != "DT-00000001" {
+		t.Errorf("first payment = %s with receipt %q, want captured with DT-00000001",
+			history[0].Status, history[0].ReceiptNumber)
+	}
+	if history[1].Status != PaymentUnpaid || history[1].ReceiptNumber != "" {
+		t.Errorf("second payment = %s with receipt %q, want unpaid without receipt",
+			history[1].Status, history[1].ReceiptNumber)
+	}
+
+	if receipt, err := db.GetReceipt("alice", "DT-00000001"); err != nil || receipt.ID != first.ID {
+		t.Errorf("GetReceipt() = %+v, %v, want the first payment", receipt, err)
+	}
+}
+
+func TestRefundPaymentStates(t *testing.T) {
+	tests := []struct {
+		name        string
+		balance     int64
+		refunds     int
+		wantErr     error
+		wantStatus  string
+		wantBalance int64
+	}{
+		{"captured", 1000, 1, nil, PaymentRefunded, 1000},
+		{"already refunded", 1000, 2, ErrPaymentNotRefundable, PaymentRefunded, 1000},
+		{"unpaid", 0, 1, ErrPaymentNotRefundable, PaymentUnpaid, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t, nil)
+			db.addWalletMovement(db.getWallet("alice"), WalletTopUp, tt.balance, "")
+
+			payment, err := db.chargePayment("alice", fare(t, db, 5.5))
+			if err != nil {
+				t.Fatalf("chargePayment() error = %v", err)
+			}
+
+			for i := 0; i < tt.refunds; i++ {
+				_, err = db.RefundPayment("alice", payment.ID)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("RefundPayment() error = %v, want %v", err, tt.wantErr)
+			}
+
+			stored := db.PaymentHistory["alice"][db.indexPaymentByID("alice", payment.ID)]
+			if stored.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", stored.Status, tt.wantStatus)
+			}
+			if balance := db.GetWallet("alice").Balance; balance != tt.wantBalance {
+				t.Errorf("balance = %d, want %d", balance, tt.wantBalance)
+			}
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		db := newTestDatabase(t, nil)
+
+		if _, err := db.RefundPayment("alice", "unknown"); !errors.Is(err, ErrPaymentNotFound) {
+			t.Errorf("RefundPayment() error = %v, want %v", err, ErrPaymentNotFound)
+		}
+	})
+}
+
+// refundFailingProvider is a fake provider whose refunds fail with the given error (if any), recording the refunded
+// authorizations
+type refundFailingProvider struct {
+	*payments.Fake
+	err      error
+	refunded []string
+}
+
+func (p *refundFailingProvider) Refund(ctx context.Context, authorizationID string, amount int64) error {
+	if p.err != nil {
+		return p.err
+	}
+	if err := p.Fake.Refund(ctx, authorizationID, amount); err != nil {
+		return err
+	}
+	p.refunded = append(p.refunded, authorizationID)
+	return nil
+}
+
+func TestRefundTopUpStates(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		refundErr   error
+		spent       float64
+		refunds     int
+		wantErr     error
+		wantStatus  string
+		wantBalance int64
+	}{
+		{name: "captured", mode: payments.FakeApprove, refunds: 1,
+			wantStatus: PaymentRefunded, wantBalance: 0},
+		{name: "already refunded", mode: payments.FakeApprove, refunds: 2,
+			wantErr: ErrTopUpNotRefundable, wantStatus: PaymentRefunded, wantBalance: 0},
+		{name: "declined", mode: payments.FakeDecline, refunds: 1,
+			wantErr: ErrTopUpNotRefundable, wantStatus: PaymentFailed, wantBalance: 0},
+		{name: "balance spent", mode: payments.FakeApprove, spent: 5.5, refunds: 1,
+			wantErr: ErrInsufficientFunds, wantStatus: PaymentCaptured, wantBalance: 450},
+		{name: "refused by the provider", mode: payments.FakeApprove, refundErr: payments.ErrTimeout, refunds: 1,
+			wantErr: payments.ErrTimeout, wantStatus: PaymentCaptured, wantBalance: 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &refundFailingProvider{Fake: newTestFake(t, tt.mode)}
+			db := newTestDatabase(t, provider)
+
+			charged, _ := topUp(t, db, "k")
+			if tt.spent > 0 {
+				if _, err := db.chargePayment("alice", fare(t, db, tt.spent)); err != nil {
+					t.Fatalf("chargePayment() error = %v", err)
+				}
+			}
+			provider.err = tt.refundErr
+
+			var err error
+			for i := 0; i < tt.refunds; i++ {
+				_, err = db.RefundTopUp("alice", charged.ID)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("RefundTopUp() error = %v, want %v", err, tt.wantErr)
+			}
+
+			wallet := db.getWallet("alice")
+			if status := wallet.TopUps[0].Status; status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", status, tt.wantStatus)
+			}
+			if wallet.Balance != tt.wantBalance {
+				t.Errorf("balance = %d, want %d", wallet.Balance, tt.wantBalance)
+			}
+
+			// the amount is given back by the provider only once, and only when the top up is refunded
+			wantRefunds := 0
+			if tt.wantStatus == PaymentRefunded {
+				wantRefunds = 1
+			}
+			if len(provider.refunded) != wantRefunds {
+				t.Errorf("refunded %d times by the provider, want %d", len(provider.refunded), wantRefunds)
+			}
+
+			// the money collected through the provider is back to zero once refunded
+			for _, item := range db.GetTrialBalance().Accounts {
+				if item.Account == AccountProviderClearing && tt.wantStatus == PaymentRefunded && item.Balance != 0 {
+					t.Errorf("provider clearing balance = %d, want 0", item.Balance)
+				}
+			}
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		db := newTestDatabase(t, nil)
+
+		if _, err := db.RefundTopUp("alice", "unknown"); !errors.Is(err, ErrTopUpNotFound) {
+			t.Errorf("RefundTopUp() error = %v, want %v", err, ErrTopUpNotFound)
+		}
+	})
+}
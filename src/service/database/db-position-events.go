@@ -0,0 +1,131 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+var ErrInvalidPositionEvent = errors.New("Every event must have a positive sequence number and a timestamp")
+
+// PositionEvent is a scan of the phone of a user, buffered while the phone was offline. The sequence number is
+// assigned by the phone and grows with each scan.
+type PositionEvent struct {
+	Sequence  int64            `json:"sequence"`
+	Timestamp time.Time        `json:"timestamp"`
+	Sightings []BeaconSighting `json:"sightings"`
+}
+
+// EventCursor tracks the position events processed for a user, so that replayed events are ignored
+type EventCursor struct {
+	LastSequence int64 `json:"last_sequence"`
+
+	// LastEventTime is the last time the user has been heard from, live or by a buffered event
+	LastEventTime string `json:"last_event_time"`
+
+	// LastMoveTime is the time of the last move of the user. The events that happened before it are stale: replaying
+	// them would undo the move.
+	LastMoveTime string `json:"last_move_time"`
+}
+
+type PositionEventsResponse struct {
+	Accepted     int                           `json:"accepted"`
+	Duplicates   int                           `json:"duplicates"`
+	Stale        int                           `json:"stale"`
+	LastSequence int64                         `json:"last_sequence"`
+	Updates      []*UpdateUserPositionResponse `json:"updates"`
+}
+
+// Get the event cursor of a user, creating it if needed
+func (db *appdbimpl) eventCursor(userID string) *EventCursor {
+	cursor, found := db.EventCursors[userID]
+	if !found {
+		cursor = &EventCursor{}
+		db.EventCursors[userID] = cursor
+	}
+	return cursor
+}
+
+// Process a batch of position events uploaded late by the phone of a user. The events are replayed in sequence order,
+// at the time they happened, so that the boarding station and the fare match the actual trip. Events already
+// processed and events older than the last move of the user are ignored. The batch is applied as a whole: if an
+// event can't be processed, the events before it are undone, so that the phone can upload the batch again.
+func (db *appdbimpl) ProcessPositionEvents(userID string, events []PositionEvent) (*PositionEventsResponse, error) {
+	for _, event := range events {
+		if event.Sequence <= 0 || event.Timestamp.IsZero() {
+			return nil, ErrInvalidPositionEvent
+		}
+	}
+
+	sorted := make([]PositionEvent, len(events))
+	copy(sorted, events)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Sequence < sorted[j].Sequence
+	})
+
+	snapshot, err := db.snapshot()
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	cursor := db.eventCursor(userID)
+	lastEventTime, _ := time.Parse(time.RFC3339, cursor.LastEventTime)
+
+	response := &PositionEventsResponse{Updates: make([]*UpdateUserPositionResponse, 0, len(sorted))}
+
+	for _, event := range sorted {
+		if event.Sequence <= cursor.LastSequence {
+			// already processed, in this batch or in a previous one
+			response.Duplicates++
+			continue
+		}
+		cursor.LastSequence = event.Sequence
+
+		// the phone clock may be a bit ahead of the server one
+		at := event.Timestamp
+		if at.After(now) {
+			at = now
+		}
+		if lastMoveTime, _ := time.Parse(time.RFC3339, cursor.LastMoveTime); at.Before(lastMoveTime) {
+			// the user has already been moved after the event happened
+			response.Stale++
+			continue
+		}
+		if at.After(lastEventTime) {
+			lastEventTime = at
+			cursor.LastEventTime = at.Format(time.RFC3339)
+		}
+
+		// sightings without a timestamp were heard at the time of the event
+		sightings := make([]BeaconSighting, len(event.Sightings))
+		for i, sighting := range event.Sightings {
+			if sighting.Timestamp.IsZero() {
+				sighting.Timestamp = at
+			}
+			sightings[i] = sighting
+		}
+
+		update, err := db.updatePosition(userID, sightings, at, now)
+
+		if err != nil {
+			return nil, db.rollback(snapshot, userID, err)
+		}
+
+		response.Accepted++
+		response.Updates = append(response.Updates, update)
+	}
+
+	response.LastSequence = cursor.LastSequence
+
+	// update the database
+	err = db.Write()
+
+	if err != nil {
+		// error writing to the database
+		return nil, err
+	}
+
+	return response, nil
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// positionEvents builds a batch of events, one a minute up to the given time, each hearing the beacon of a station
+func positionEvents(t *testing.T, db *appdbimpl, station string, count int, until time.Time) []PositionEvent {
+	t.Helper()
+
+	beaconID := testBeacon(t, db, BeaconStation, station)
+
+	events := make([]PositionEvent, count)
+	for i := range events {
+		events[i] = PositionEvent{
+			Sequence:  int64(i + 1),
+			Timestamp: until.Add(time.Duration(i-count+1) * time.Minute),
+			Sightings: []BeaconSighting{{BeaconID: beaconID, RSSI: -60}},
+		}
+	}
+	return events
+}
+
+func TestProcessPositionEventsAfterLiveUpdate(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// live is the station heard by the live update sent before the batch, if any
+		live string
+
+		wantAccepted int
+		wantStale    int
+		wantStation  string
+	}{
+		{name: "live update without a move", wantAccepted: 2, wantStation: "Napoli Centrale"},
+		{name: "live update with a move", live: "Roma Termini", wantStale: 2, wantStation: "Roma Termini"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t, nil)
+
+			// the phone comes back online: a live update is sent before the buffered events are uploaded
+			var sightings []BeaconSighting
+			if tt.live != "" {
+				sightings = []BeaconSighting{{BeaconID: testBeacon(t, db, BeaconStation, tt.live), RSSI: -60}}
+			}
+			if _, err := db.UpdateUserPosition("alice", sightings); err != nil {
+				t.Fatalf("UpdateUserPosition() error = %v", err)
+			}
+
+			events := positionEvents(t, db, "Napoli Centrale", 2, time.Now().Add(-5*time.Minute))
+			response, err := db.ProcessPositionEvents("alice", events)
+			if err != nil {
+				t.Fatalf("ProcessPositionEvents() error = %v", err)
+			}
+
+			if response.Accepted != tt.wantAccepted || response.Stale != tt.wantStale {
+				t.Errorf("accepted %d, stale %d, want %d, %d", response.Accepted, response.Stale, tt.wantAccepted, tt.wantStale)
+			}
+			if got := committedPosition(db.GetUserPosition("alice")); got != InStation+"/"+tt.wantStation {
+				t.Errorf("user position = %q, want %s", got, tt.wantStation)
+			}
+
+			// the user has been heard from by the live update
+			if lastSeen := db.eventCursor("alice").LastEventTime; lastSeen < events[1].Timestamp.Format(time.RFC3339) {
+				t.Errorf("last event time = %s, want the time of the live update", lastSeen)
+			}
+		})
+	}
+}
+
+func TestProcessPositionEventsRollback(t *testing.T) {
+	db := newTestDatabase(t, nil)
+	db.cfg.Positioning.Dwell = time.Minute
+
+	// the first event only starts a pending move; the second one commits it, but the database can't be written
+	events := positionEvents(t, db, "Napoli Centrale", 2, time.Now().Add(-5*time.Minute))
+	filename := db.filename
+	db.filename = t.TempDir()
+
+	if _, err := db.ProcessPositionEvents("alice", events); err == nil {
+		t.Fatalf("ProcessPositionEvents() succeeded without writing the database")
+	}
+
+	if got := committedPosition(db.GetUserPosition("alice")); got != Away {
+		t.Errorf("user position = %q, want the user still away", got)
+	}
+	if cursor := db.eventCursor("alice"); cursor.LastSequence != 0 || cursor.LastEventTime != "" {
+		t.Errorf("cursor = %+v, want no event processed", cursor)
+	}
+	if move, found := db.pending["alice"]; found {
+		t.Errorf("pending move %+v left by the failed batch", move)
+	}
+
+	// the same batch is processed once the database can be written
+	db.filename = filename
+
+	response, err := db.ProcessPositionEvents("alice", events)
+	if err != nil {
+		t.Fatalf("ProcessPositionEvents() retry error = %v", err)
+	}
+	if response.Accepted != 2 || response.Duplicates != 0 {
+		t.Errorf("retry accepted %d, duplicates %d, want 2, 0", response.Accepted, response.Duplicates)
+	}
+	if got := committedPosition(db.GetUserPosition("alice")); got != InStation+"/Napoli Centrale" {
+		t.Errorf("user position = %q, want Napoli Centrale", got)
+	}
+}
@@ -0,0 +1,95 @@
+package database
+
+import "time"
+
+// A position the user seems to have moved to, which is not committed yet
+type pendingMove struct {
+	Position string
+	Since    time.Time
+}
+
+// Get the position of a beacon: its station, its train or away. Beacons of the same station (or train) have the same
+// position, so that moving between platforms (or cars) is not a move.
+func (db *appdbimpl) beaconPosition(beaconID string, at time.Time) string {
+	if station := db.GetStationByBeaconID(beaconID); station != nil {
+		return InStation + "/" + station.Name
+	}
+	if train := db.GetTrainByBeaconID(beaconID, at); train != nil {
+		return InTrain + "/" + train.ID
+	}
+	return Away
+}
+
+// Get the position the user is in
+func committedPosition(state *UserState) string {
+	switch {
+	case state == nil:
+		return Away
+	case state.Status == InStation && state.Station != nil:
+		return InStation + "/" + state.Station.Name
+	case state.Status == InTrain && state.Train != nil:
+		return InTrain + "/" + state.Train.ID
+	}
+	return Away
+}
+
+// Check if the user can be moved to the position of the beacon. A move is committed only after the new position has
+// been observed for the whole dwell period (or the grace period, when the user is leaving every beacon), so that a
+// missed scan or a flapping beacon doesn't charge the user or issue a new ticket.
+func (db *appdbimpl) settleBeacon(userID string, beaconID string, now time.Time) bool {
+	if db.pending == nil {
+		db.pending = make(map[string]*pendingMove)
+	}
+
+	position := db.beaconPosition(beaconID, now)
+
+	if position == committedPosition(db.GetUserPosition(userID)) {
+		// the user is still where we thought: forget any move seen in the meantime
+		delete(db.pending, userID)
+		return true
+	}
+
+	rules := db.positioningRules()
+	period := rules.Dwell
+	if position == Away {
+		period = rules.Grace
+	}
+
+	move, found := db.pending[userID]
+	if !found || move.Position != position {
+		move = &pendingMove{Position: position, Since: now}
+		db.pending[userID] = move
+	}
+
+	if now.Sub(move.Since) < period {
+		return false
+	}
+
+	delete(db.pending, userID)
+	return true
+}
+
+// Get the position of the user while a move is pending
+func (db *appdbimpl) pendingPositionResponse(userID string, beaconID string, at time.Time) *UpdateUserPositionResponse {
+	response := &UpdateUserPositionResponse{Status: Away}
+
+	if state := db.GetUserPosition(userID); state != nil {
+		switch {
+		case state.Status == InStation && state.Station != nil:
+			response.Status, response.ID = InStation, state.Station.Name
+		case state.Status == InTrain && state.Train != nil:
+			response.Status, response.ID = InTrain, state.Train.ID
+		}
+	}
+
+	switch {
+	case db.GetStationByBeaconID(beaconID) != nil:
+		response.PendingStatus = InStation
+	case db.GetTrainByBeaconID(beaconID, at) != nil:
+		response.PendingStatus = InTrain
+	default:
+		response.PendingStatus = Away
+	}
+
+	return response
+}
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrReceiptNotFound is returned when no payment has the requested receipt number
+var ErrReceiptNotFound = errors.New("Receipt not found")
+
+// Get the next receipt number. Receipt numbers are sequential and never reused.
+func (db *appdbimpl) nextReceiptNumber() string {
+	db.LastReceipt++
+	return fmt.Sprintf("DT-%08d", db.LastReceipt)
+}
+
+// Split an amount (VAT included) in net amount and VAT
+func vatBreakdown(gross int64, rate float64) *VATBreakdown {
+	net := int64(math.Round(float64(gross) / (1 + rate)))
+	return &VATBreakdown{
+		Rate:  rate,
+		Net:   net,
+		VAT:   gross - net,
+		Gross: gross,
+	}
+}
+
+// Get the payment of a user with the given receipt number. Without a user, the payments of all the users are searched.
+// Since receipt numbers are sequential, the receipts of the other users are not found.
+func (db *appdbimpl) GetReceipt(userID string, receiptNumber string) (*PaymentResponse, error) {
+	for owner, history := range db.PaymentHistory {
+		if userID != "" && owner != userID {
+			continue
+		}
+		for _, payment := range history {
+			if payment.ReceiptNumber != "" && payment.ReceiptNumber == receiptNumber {
+				return &payment, nil
+			}
+		}
+	}
+	return nil, ErrReceiptNotFound
+}
@@ -0,0 +1,164 @@
+package database
+
+import "time"
+
+const (
+	SessionPolicyLastStation string = "last_station"
+	SessionPolicyMaxFare            = "max_fare"
+	SessionPolicyIncomplete         = "incomplete"
+)
+
+const (
+	TripEndRunCompleted string = "run_completed"
+	TripEndIdle                = "idle"
+	TripEndTrainReset          = "train_reset"
+)
+
+// defaultSessionIdleTimeout is used when no idle timeout has been configured
+const defaultSessionIdleTimeout = 3 * time.Hour
+
+// SessionRules are the rules used to close the in-train sessions of the passengers whose phone went silent (e.g.,
+// because its battery died on board)
+type SessionRules struct {
+	// IdleTimeout is how long a passenger on a train can go without position updates before their session is closed
+	IdleTimeout time.Duration
+
+	// Policy is how a stale session is charged:
+	//   - last_station: the passenger got off at the last station the train reached
+	//   - max_fare: the passenger travelled to the last stop of the train
+	//   - incomplete: the trip is closed without charging the passenger
+	Policy string
+}
+
+// Get the session rules, filling the missing ones with the defaults
+func (db *appdbimpl) sessionRules() SessionRules {
+	rules := db.cfg.Sessions
+	if rules.IdleTimeout <= 0 {
+		rules.IdleTimeout = defaultSessionIdleTimeout
+	}
+	if rules.Policy == "" {
+		rules.Policy = SessionPolicyLastStation
+	}
+	return rules
+}
+
+// Get the reason why an in-train session is stale at the given time: the run of the train is over (the ticket is
+// not active anymore), or the user has been idle for too long. Users who checked in by hand send no position updates,
+// so they are never idle. Returns an empty string if the session is not stale.
+func (db *appdbimpl) staleSessionReason(userID string, state *UserState, now time.Time) string {
+	ticket, found := db.Tickets[state.TicketID]
+	if found {
+		db.refreshTicketStatus(ticket)
+		if ticket.Status != TicketActive {
+			return TripEndRunCompleted
+		}
+	}
+
+	if checkedIn(state) {
+		return ""
+	}
+
+	// the last position update of the user, or their boarding for the users who never sent events
+	var lastSeen time.Time
+	if cursor, ok := db.EventCursors[userID]; ok {
+		lastSeen, _ = time.Parse(time.RFC3339, cursor.LastEventTime)
+	}
+	if lastSeen.IsZero() && found {
+		lastSeen, _ = time.Parse(time.RFC3339, ticket.IssuedAt)
+	}
+
+	if !lastSeen.IsZero() && now.Sub(lastSeen) > db.sessionRules().IdleTimeout {
+		return TripEndIdle
+	}
+	return ""
+}
+
+// Get the station where a stale session on the given train ends, following the policy. Returns nil if the trip can't
+// be charged: the policy says so, or the train hasn't reached any station after the boarding one (e.g., it has been
+// reset).
+func staleSessionStation(state *UserState, train Train, policy string) *Station {
+	trip := *train.Trip
+
+	var station *Station
+	switch policy {
+	case SessionPolicyMaxFare:
+		station = trip[len(trip)-1].Station
+	case SessionPolicyLastStation:
+		station, _ = findLastStation(train)
+	}
+
+	if station == nil || indexStation(*station, train) < indexStation(*state.Station, train) {
+		return nil
+	}
+	return station
+}
+
+// ClosedSession is an in-train session closed by the server: the ended trip, and the move of the user off the train,
+// with the payment charged for the trip (if any)
+type ClosedSession struct {
+	Trip     Trip
+	Response *UpdateUserPositionResponse
+}
+
+// Close the in-train session of a user, ending their trip with the given reason. If a station is given, the user gets
+// off there and is charged; otherwise, the trip is incomplete. The user is moved away. The caller is responsible for
+// writing the database.
+func (db *appdbimpl) closeSession(userID string, state *UserState, station *Station, reason string, now time.Time) ClosedSession {
+	trip := db.openTrip(userID, state)
+	trip.EndReason = reason
+
+	response := &UpdateUserPositionResponse{Status: Away}
+
+	if station != nil {
+		response.PaymentResponse = db.alightTrain(userID, state, station, TripAlighted, PositionSourceServer, now)
+	} else {
+		if ticket, found := db.Tickets[state.TicketID]; found && ticket.Status == TicketActive {
+			db.setTicketStatus(ticket, TicketExpired)
+		}
+		trip.Status = TripIncomplete
+		trip.AlightedAt = now.Format(time.RFC3339)
+		trip.AlightingSource = PositionSourceServer
+	}
+
+	db.UserStates[userID] = &UserState{Status: Away}
+
+	return ClosedSession{Trip: *trip, Response: response}
+}
+
+// Close the in-train sessions that are stale at the given time, following the session policy: the trips are ended
+// with the reason they were closed for, and the users are moved away. It returns the closed sessions.
+func (db *appdbimpl) ReapStaleSessions(now time.Time) ([]ClosedSession, error) {
+	rules := db.sessionRules()
+
+	closed := make([]ClosedSession, 0)
+	for userID, state := range db.UserStates {
+		if state.Status != InTrain || state.Train == nil || state.Station == nil {
+			continue
+		}
+
+		reason := db.staleSessionReason(userID, state, now)
+		if reason == "" {
+			continue
+		}
+
+		// the position of the user has a copy of the train, taken when they boarded: the stations it reached since are
+		// on the train itself
+		var station *Station
+		if train, err := db.getTrainByID(state.Train.ID); err == nil {
+			station = staleSessionStation(state, *train, rules.Policy)
+		}
+		closed = append(closed, db.closeSession(userID, state, station, reason, now))
+	}
+
+	if len(closed) == 0 {
+		return closed, nil
+	}
+
+	err := db.Write()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return closed, nil
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// boardedAndLoaded boards alice on FR9422 in Napoli Centrale, and loads the database again: the position of the user
+// has a copy of the train, that is not updated when the train moves on
+func boardedAndLoaded(t *testing.T, cfg func(*Config)) *appdbimpl {
+	t.Helper()
+
+	db := newTestDatabase(t, nil)
+	moveTestTrain(t, db, "FR9422", "Napoli Centrale", "arrived", "08:00")
+
+	state := &UserState{
+		Status:  InTrain,
+		Train:   testTrain(t, db, "FR9422"),
+		Station: testStation(t, db, "Napoli Centrale"),
+		Source:  PositionSourceBeacon,
+	}
+	if _, err := db.applyMove("alice", state, time.Now()); err != nil {
+		t.Fatalf("can't board the train: %v", err)
+	}
+	if err := db.Write(); err != nil {
+		t.Fatalf("can't write the database: %v", err)
+	}
+
+	config := db.cfg
+	if cfg != nil {
+		cfg(&config)
+	}
+	loaded, err := Load(db.filename, config)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	return loaded.(*appdbimpl)
+}
+
+// checkClosedSession checks the only closed session, and the payment sent with it
+func checkClosedSession(t *testing.T, sessions []ClosedSession, wantStatus string, wantAlighting string, wantCost float64) {
+	t.Helper()
+
+	if len(sessions) != 1 {
+		t.Fatalf("got %d closed sessions, want 1", len(sessions))
+	}
+	trip, response := sessions[0].Trip, sessions[0].Response
+
+	if trip.UserID != "alice" || trip.Status != wantStatus || trip.AlightingStation != wantAlighting {
+		t.Errorf("trip = %+v, want %s in %q", trip, wantStatus, wantAlighting)
+	}
+	if trip.Cost != wantCost {
+		t.Errorf("trip cost = %v, want %v", trip.Cost, wantCost)
+	}
+
+	if response == nil || response.Status != Away {
+		t.Fatalf("response = %+v, want the user moved away", response)
+	}
+	if wantCost == 0 {
+		if response.PaymentResponse != nil {
+			t.Errorf("unexpected payment %+v", response.PaymentResponse)
+		}
+		return
+	}
+	if response.PaymentResponse == nil || response.PaymentResponse.ID != trip.PaymentID {
+		t.Errorf("response payment = %+v, want the payment %q of the trip", response.PaymentResponse, trip.PaymentID)
+	}
+}
+
+func TestReapStaleSessions(t *testing.T) {
+	tests := []struct {
+		policy        string
+		wantStatus    string
+		wantAlighting string
+		wantCost      float64
+	}{
+		{SessionPolicyLastStation, TripAlighted, "Roma Termini", 5.5},
+		{SessionPolicyMaxFare, TripAlighted, "Bologna Centrale", 22},
+		{SessionPolicyIncomplete, TripIncomplete, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			db := boardedAndLoaded(t, func(cfg *Config) { cfg.Sessions.Policy = tt.policy })
+
+			// the train moves on after the database is loaded
+			moveTestTrain(t, db, "FR9422", "Napoli Centrale", "departed", "08:02")
+			moveTestTrain(t, db, "FR9422", "Roma Termini", "arrived", "09:10")
+
+			// nothing is stale yet
+			if sessions, err := db.ReapStaleSessions(time.Now()); err != nil || len(sessions) != 0 {
+				t.Fatalf("ReapStaleSessions() = %+v, %v, want no closed session", sessions, err)
+			}
+
+			sessions, err := db.ReapStaleSessions(time.Now().Add(defaultSessionIdleTimeout + time.Minute))
+			if err != nil {
+				t.Fatalf("ReapStaleSessions() error = %v", err)
+			}
+
+			checkClosedSession(t, sessions, tt.wantStatus, tt.wantAlighting, tt.wantCost)
+
+			if state := db.GetUserPosition("alice"); state.Status != Away {
+				t.Errorf("user status = %q, want %q", state.Status, Away)
+			}
+		})
+	}
+}
+
+func TestResetTrainPositionClosesSessions(t *testing.T) {
+	db := boardedAndLoaded(t, nil)
+
+	moveTestTrain(t, db, "FR9422", "Napoli Centrale", "departed", "08:02")
+	moveTestTrain(t, db, "FR9422", "Roma Termini", "arrived", "09:10")
+
+	sessions, err := db.ResetTrainPosition("FR9422")
+	if err != nil {
+		t.Fatalf("ResetTrainPosition() error = %v", err)
+	}
+	checkClosedSession(t, sessions, TripAlighted, "Roma Termini", 5.5)
+
+	if sessions[0].Trip.EndReason != TripEndTrainReset {
+		t.Errorf("end reason = %q, want %q", sessions[0].Trip.EndReason, TripEndTrainReset)
+	}
+}
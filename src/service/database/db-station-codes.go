@@ -0,0 +1,158 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// stationCodePrefix identifies the format (and version) of the signed station codes
+const stationCodePrefix = "DTS1"
+
+// stationCodeBeaconPrefix starts the ID of the virtual beacon of a station, heard when its code is scanned. Virtual
+// beacons are not in the registry.
+const stationCodeBeaconPrefix = "station-code:"
+
+// defaultStationCodeValidity is used when no validity has been configured
+const defaultStationCodeValidity = 365 * 24 * time.Hour
+
+var (
+	// ErrInvalidStationCode is returned when a scanned code is not the signed code of a station
+	ErrInvalidStationCode = errors.New("Invalid station code")
+
+	// ErrStationCodeExpired is returned when a station code is scanned outside of its validity
+	ErrStationCodeExpired = errors.New("Station code expired or not valid yet")
+
+	// ErrNoStationCodeKey is returned when generating station codes without a configured ticket signing key
+	ErrNoStationCodeKey = errors.New("Station codes can't be generated without a configured ticket signing key")
+)
+
+// StationCodeClaims is the content of a signed station code. Short JSON keys keep the payload (and the QR code)
+// small.
+type StationCodeClaims struct {
+	Station   string `json:"stn"`
+	Platform  int    `json:"plt,omitempty"`
+	NotBefore int64  `json:"nbf"`
+	ExpiresAt int64  `json:"exp"`
+	KeyID     string `json:"kid"`
+}
+
+// StationCode is the signed code printed (as a QR code) in a station, or on one of its platforms
+type StationCode struct {
+	Station    string `json:"station"`
+	Platform   int    `json:"platform"`
+	Code       string `json:"code"`
+	ValidFrom  string `json:"valid_from"`
+	ValidUntil string `json:"valid_until"`
+}
+
+// Get how long the station codes are valid
+func (db *appdbimpl) stationCodeValidity() time.Duration {
+	if db.cfg.StationCodeValidity <= 0 {
+		return defaultStationCodeValidity
+	}
+	return db.cfg.StationCodeValidity
+}
+
+// Get the platforms of a station, from the trips of the trains stopping there
+func (db *appdbimpl) stationPlatforms(station Station) []int {
+	found := make(map[int]bool)
+	for _, train := range db.Trains {
+		for _, item := range *train.Trip {
+			if item.Station.Name == station.Name && item.Platform > 0 {
+				found[item.Platform] = true
+			}
+		}
+	}
+
+	platforms := make([]int, 0, len(found))
+	for platform := range found {
+		platforms = append(platforms, platform)
+	}
+	sort.Ints(platforms)
+	return platforms
+}
+
+// Generate the signed codes of a station, valid from the start of the given day. The codes of its platforms follow
+// the code of the station, if requested. The ticket signing key must be configured, since the codes outlive restarts.
+func (db *appdbimpl) GetStationCodes(stationID string, platforms bool, from time.Time) ([]StationCode, error) {
+	if db.cfg.TicketSigningKeyGenerated {
+		return nil, ErrNoStationCodeKey
+	}
+
+	station, err := db.GetStationByID(stationID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	validFrom := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.Local)
+	validUntil := validFrom.Add(db.stationCodeValidity())
+
+	numbers := []int{0}
+	if platforms {
+		numbers = append(numbers, db.stationPlatforms(*station)...)
+	}
+
+	codes := make([]StationCode, 0, len(numbers))
+	for _, platform := range numbers {
+		code, err := db.signClaims(stationCodePrefix, StationCodeClaims{
+			Station:   station.Name,
+			Platform:  platform,
+			NotBefore: validFrom.Unix(),
+			ExpiresAt: validUntil.Unix(),
+			KeyID:     db.ticketKeyID(),
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		codes = append(codes, StationCode{
+			Station:    station.Name,
+			Platform:   platform,
+			Code:       code,
+			ValidFrom:  validFrom.Format(time.RFC3339),
+			ValidUntil: validUntil.Format(time.RFC3339),
+		})
+	}
+
+	return codes, nil
+}
+
+// Check the signature and the validity of a station code scanned at the given time, returning its station
+func (db *appdbimpl) verifyStationCode(code string, at time.Time) (*Station, error) {
+	var claims StationCodeClaims
+	if !db.verifyClaims(stationCodePrefix, code, &claims) {
+		return nil, ErrInvalidStationCode
+	}
+
+	if at.Unix() < claims.NotBefore || at.Unix() >= claims.ExpiresAt {
+		return nil, ErrStationCodeExpired
+	}
+
+	station, err := db.GetStationByID(claims.Station)
+	if err != nil {
+		return nil, ErrInvalidStationCode
+	}
+	return station, nil
+}
+
+// Get the ID of the virtual beacon of a station, heard when its code is scanned
+func stationCodeBeacon(station *Station) string {
+	return stationCodeBeaconPrefix + station.Name
+}
+
+// Get the station of a virtual beacon, or nil if the beacon is not the virtual beacon of a station
+func (db *appdbimpl) getStationByCodeBeacon(beaconID string) *Station {
+	if !strings.HasPrefix(beaconID, stationCodeBeaconPrefix) {
+		return nil
+	}
+
+	station, err := db.GetStationByID(strings.TrimPrefix(beaconID, stationCodeBeaconPrefix))
+	if err != nil {
+		return nil
+	}
+	return station
+}
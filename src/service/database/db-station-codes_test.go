@@ -0,0 +1,31 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGetStationCodesNeedsConfiguredKey(t *testing.T) {
+	db := newTestDatabase(t, nil)
+	db.cfg.TicketSigningKeyGenerated = true
+
+	if _, err := db.GetStationCodes("Roma Termini", false, time.Now()); !errors.Is(err, ErrNoStationCodeKey) {
+		t.Errorf("GetStationCodes() error = %v, want %v", err, ErrNoStationCodeKey)
+	}
+
+	db.cfg.TicketSigningKeyGenerated = false
+
+	codes, err := db.GetStationCodes("Roma Termini", false, time.Now())
+	if err != nil {
+		t.Fatalf("GetStationCodes() error = %v", err)
+	}
+	if len(codes) != 1 {
+		t.Fatalf("got %d codes, want 1", len(codes))
+	}
+
+	station, err := db.verifyStationCode(codes[0].Code, time.Now())
+	if err != nil || station.Name != "Roma Termini" {
+		t.Errorf("verifyStationCode() = %v, %v, want Roma Termini", station, err)
+	}
+}
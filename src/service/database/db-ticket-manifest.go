@@ -0,0 +1,91 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// ManifestTicket is the compact form of a ticket in a manifest
+type ManifestTicket struct {
+	ID              string `json:"id"`
+	BoardingStation string `json:"brd"`
+	ExpiresAt       int64  `json:"exp"`
+}
+
+// TicketManifest is the list of the valid tickets of a train, for inspectors validating tickets offline. A full
+// manifest (Since = 0) lists the active tickets. A delta manifest lists the tickets issued since the given version, and
+// the tickets that are not valid anymore.
+type TicketManifest struct {
+	TrainID string           `json:"train_id"`
+	Version int64            `json:"version"`
+	Since   int64            `json:"since"`
+	Tickets []ManifestTicket `json:"tickets"`
+	Removed []string         `json:"removed"`
+}
+
+// Get the ticket manifest of the current run of a train. The version of the manifest is the version of the last
+// ticket change: devices pass it back as since to get only the changes.
+func (db *appdbimpl) GetTicketManifest(trainID string, since int64) (*TicketManifest, error) {
+
+	train, err := db.getTrainByID(trainID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := TicketManifest{
+		TrainID: train.ID,
+		Version: since,
+		Since:   since,
+		Tickets: make([]ManifestTicket, 0),
+		Removed: make([]string, 0),
+	}
+
+	changed := false
+
+	for _, ticket := range db.Tickets {
+		if ticket.TrainID != train.ID {
+			continue
+		}
+
+		changed = db.refreshTicketStatus(ticket) || changed
+
+		if ticket.Version <= since {
+			continue
+		}
+
+		if ticket.Version > manifest.Version {
+			manifest.Version = ticket.Version
+		}
+
+		if ticket.Status != TicketActive {
+			// a full manifest has nothing to remove
+			if since > 0 {
+				manifest.Removed = append(manifest.Removed, ticket.ID)
+			}
+			continue
+		}
+
+		expiresAt := int64(0)
+		if t, err := time.Parse(time.RFC3339, ticket.ExpiresAt); err == nil {
+			expiresAt = t.Unix()
+		}
+
+		manifest.Tickets = append(manifest.Tickets, ManifestTicket{
+			ID:              ticket.ID,
+			BoardingStation: ticket.BoardingStation,
+			ExpiresAt:       expiresAt,
+		})
+	}
+
+	if changed {
+		if err := db.Write(); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(manifest.Tickets, func(i, j int) bool { return manifest.Tickets[i].ID < manifest.Tickets[j].ID })
+	sort.Strings(manifest.Removed)
+
+	return &manifest, nil
+}
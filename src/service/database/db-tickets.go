@@ -1,47 +1,417 @@
 package database
 
 import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/gofrs/uuid"
 )
 
-func (db *appdbimpl) generateTicket(trainID string) (string, error) {
+// signedTicketPrefix identifies the format (and version) of the signed ticket payloads
+const signedTicketPrefix = "DT1"
 
-	// check if the train exists
-	_, err := db.getTrainByID(trainID)
+const (
+	TicketActive    string = "active"
+	TicketCompleted        = "completed"
+	TicketExpired          = "expired"
+	TicketRevoked          = "revoked"
+)
+
+// ticketExpiryGrace is how long a ticket stays valid after the scheduled arrival of the train at its last stop, in
+// case the arrival is never reported
+const ticketExpiryGrace = time.Hour
+
+var (
+	// ErrTicketRevoked is returned when validating a ticket that has been revoked
+	ErrTicketRevoked = errors.New("Ticket revoked")
+
+	// ErrTicketExpired is returned when validating a ticket of a train that has ended its run
+	ErrTicketExpired = errors.New("Ticket expired")
+
+	// ErrTicketCompleted is returned when validating a ticket of a passenger that has already got off the train
+	ErrTicketCompleted = errors.New("Ticket already used")
+
+	// ErrInvalidTicketSignature is returned when the signature of a signed ticket is not valid
+	ErrInvalidTicketSignature = errors.New("Invalid ticket signature")
+
+	// ErrTicketNotFound is returned when the ticket does not exist
+	ErrTicketNotFound = errors.New("Ticket not found")
+)
+
+// SignedTicketClaims is the content of a signed ticket. Short JSON keys keep the payload (and the QR code) small.
+type SignedTicketClaims struct {
+	TicketID        string `json:"tid"`
+	TrainID         string `json:"trn"`
+	RunDate         string `json:"run"`
+	BoardingStation string `json:"brd"`
+	IssuedAt        int64  `json:"iat"`
+	ExpiresAt       int64  `json:"exp"`
+	KeyID           string `json:"kid"`
+}
+
+// Ticket is issued to a passenger boarding a train, and is valid for a single run of the train
+type Ticket struct {
+	ID               string `json:"id"`
+	UserID           string `json:"user_id"`
+	TrainID          string `json:"train_id"`
+	RunDate          string `json:"run_date"`
+	BoardingStation  string `json:"boarding_station"`
+	AlightingStation string `json:"alighting_station"`
+	IssuedAt         string `json:"issued_at"`
+	ExpiresAt        string `json:"expires_at"`
+	Status           string `json:"status"`
+	StatusChangedAt  string `json:"status_changed_at"`
+	Version          int64  `json:"version"`
+}
+
+type TicketPublicKey struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"key_id"`
+	PublicKey string `json:"public_key"`
+}
+
+type RevokedTicket struct {
+	TicketID  string `json:"ticket_id"`
+	TrainID   string `json:"train_id"`
+	RevokedAt string `json:"revoked_at"`
+}
+
+// Get the identifier of the ticket signing key: scanners use it to pick the right public key
+func (db *appdbimpl) ticketKeyID() string {
+	publicKey := db.cfg.TicketSigningKey.Public().(ed25519.PublicKey)
+	digest := sha256.Sum256(publicKey)
+	return hex.EncodeToString(digest[:8])
+}
+
+// Sign a set of claims with the ticket signing key, producing a compact payload: <prefix>.<base64url claims>.<base64url
+// signature>
+func (db *appdbimpl) signClaims(prefix string, claims interface{}) (string, error) {
+	payload, err := json.Marshal(claims)
 
 	if err != nil {
 		return "", err
 	}
 
+	signingInput := prefix + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature := ed25519.Sign(db.cfg.TicketSigningKey, []byte(signingInput))
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Check the prefix and the signature of a signed payload, decoding its claims. Returns false if the payload is not
+// valid.
+func (db *appdbimpl) verifyClaims(prefix string, signed string, claims interface{}) bool {
+	parts := strings.Split(signed, ".")
+	if len(parts) != 3 || parts[0] != prefix {
+		return false
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+
+	publicKey := db.cfg.TicketSigningKey.Public().(ed25519.PublicKey)
+	if !ed25519.Verify(publicKey, []byte(parts[0]+"."+parts[1]), signature) {
+		return false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+
+	return json.Unmarshal(payload, claims) == nil
+}
+
+// Sign the claims of a ticket, producing a compact payload: DT1.<base64url claims>.<base64url signature>
+func (db *appdbimpl) signTicket(claims SignedTicketClaims) (string, error) {
+	claims.KeyID = db.ticketKeyID()
+	return db.signClaims(signedTicketPrefix, claims)
+}
+
+// Check the signature of a signed ticket, returning its claims
+func (db *appdbimpl) verifySignedTicket(signedTicket string) (*SignedTicketClaims, error) {
+	var claims SignedTicketClaims
+	if !db.verifyClaims(signedTicketPrefix, signedTicket, &claims) {
+		return nil, ErrInvalidTicketSignature
+	}
+
+	return &claims, nil
+}
+
+// Get the time a ticket issued at the given time expires: the scheduled arrival of the train at its last stop (on
+// the following day for overnight trains), plus the delay of the train and a grace period
+func ticketExpiry(train Train, issuedAt time.Time) time.Time {
+	trip := *train.Trip
+	arrival, err := time.ParseInLocation("15:04", trip[len(trip)-1].ScheduledArrivalTime, time.Local)
+	if err != nil {
+		return issuedAt.Add(24 * time.Hour)
+	}
+
+	departure, err := time.ParseInLocation("15:04", trip[0].ScheduledDepartureTime, time.Local)
+	if err != nil {
+		departure = arrival
+	}
+
+	// the run started on the day of the first departure before the issue time
+	runStart := time.Date(issuedAt.Year(), issuedAt.Month(), issuedAt.Day(), departure.Hour(), departure.Minute(), 0, 0, time.Local)
+	if runStart.After(issuedAt) && arrival.Before(departure) {
+		runStart = runStart.AddDate(0, 0, -1)
+	}
+
+	expiry := time.Date(runStart.Year(), runStart.Month(), runStart.Day(), arrival.Hour(), arrival.Minute(), 0, 0, time.Local)
+	if arrival.Before(departure) {
+		expiry = expiry.AddDate(0, 0, 1)
+	}
+
+	return expiry.Add(time.Duration(train.LastDelay)*time.Minute + ticketExpiryGrace)
+}
+
+// Give a new version to a ticket that has changed, so that the manifests can be synced. The caller is responsible
+// for writing the database.
+func (db *appdbimpl) touchTicket(ticket *Ticket) {
+	db.TicketVersion++
+	ticket.Version = db.TicketVersion
+}
+
+// Set the status of a ticket. The caller is responsible for writing the database.
+func (db *appdbimpl) setTicketStatus(ticket *Ticket, status string) {
+	ticket.Status = status
+	ticket.StatusChangedAt = time.Now().Format(time.RFC3339)
+	db.touchTicket(ticket)
+}
+
+// Expire a ticket that is past its expiry time. The caller is responsible for writing the database.
+func (db *appdbimpl) refreshTicketStatus(ticket *Ticket) bool {
+	if ticket.Status != TicketActive {
+		return false
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, ticket.ExpiresAt)
+	if err != nil || time.Now().Before(expiresAt) {
+		return false
+	}
+
+	db.setTicketStatus(ticket, TicketExpired)
+	return true
+}
+
+// Get the claims of the signed form of a ticket
+func ticketClaims(ticket *Ticket) SignedTicketClaims {
+	claims := SignedTicketClaims{
+		TicketID:        ticket.ID,
+		TrainID:         ticket.TrainID,
+		RunDate:         ticket.RunDate,
+		BoardingStation: ticket.BoardingStation,
+	}
+	if issuedAt, err := time.Parse(time.RFC3339, ticket.IssuedAt); err == nil {
+		claims.IssuedAt = issuedAt.Unix()
+	}
+	if expiresAt, err := time.Parse(time.RFC3339, ticket.ExpiresAt); err == nil {
+		claims.ExpiresAt = expiresAt.Unix()
+	}
+	return claims
+}
+
+// Generate a new ticket for a passenger boarding a train. It returns the ticket and the signed ticket, that can be
+// verified offline.
+func (db *appdbimpl) generateTicket(userID string, trainID string, boardingStation *Station) (*Ticket, string, error) {
+
+	// check if the train exists
+	train, err := db.getTrainByID(trainID)
+
+	if err != nil {
+		return nil, "", err
+	}
+
 	// generate a new ticket (a ticket is a UUID)
-	ticket, err := uuid.NewV4()
+	ticketID, err := uuid.NewV4()
 
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
 
-	db.ValidTickets[ticket.String()] = trainID
+	now := time.Now()
+	expiresAt := ticketExpiry(*train, now)
+
+	ticket := Ticket{
+		ID:               ticketID.String(),
+		UserID:           userID,
+		TrainID:          trainID,
+		RunDate:          now.Format("2006-01-02"),
+		BoardingStation:  boardingStation.Name,
+		AlightingStation: "",
+		IssuedAt:         now.Format(time.RFC3339),
+		ExpiresAt:        expiresAt.Format(time.RFC3339),
+		Status:           TicketActive,
+		StatusChangedAt:  now.Format(time.RFC3339),
+	}
+
+	signedTicket, err := db.signTicket(ticketClaims(&ticket))
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	db.touchTicket(&ticket)
+	db.Tickets[ticket.ID] = &ticket
 
 	// write changes to the database
 	err = db.Write()
 
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
 
-	return ticket.String(), nil
+	return &ticket, signedTicket, nil
 }
 
-func (db *appdbimpl) ValidateTicket(ticket string) (string, error) {
+// Mark the ticket of a passenger getting off a train as completed. The caller is responsible for writing the database.
+func (db *appdbimpl) completeTicket(ticketID string, alightingStation *Station) {
+	ticket, ok := db.Tickets[ticketID]
+	if !ok || ticket.Status != TicketActive {
+		return
+	}
+
+	ticket.AlightingStation = alightingStation.Name
+	db.setTicketStatus(ticket, TicketCompleted)
+}
+
+// Expire the active tickets of a train, when it ends its run. The caller is responsible for writing the database.
+func (db *appdbimpl) expireTrainTickets(trainID string) {
+	for _, ticket := range db.Tickets {
+		if ticket.TrainID == trainID && ticket.Status == TicketActive {
+			db.setTicketStatus(ticket, TicketExpired)
+		}
+	}
+}
+
+// Validate a ticket, given its ID or its signed payload. If the ticket is not valid, the error tells why (e.g.,
+// ErrTicketExpired); the ticket is returned anyway if it exists.
+func (db *appdbimpl) ValidateTicket(code string) (*Ticket, error) {
+
+	// a signed ticket is checked against its signature first
+	if strings.HasPrefix(code, signedTicketPrefix+".") {
+		claims, err := db.verifySignedTicket(code)
+
+		if err != nil {
+			return nil, err
+		}
+
+		code = claims.TicketID
+	}
 
 	// check if the ticket exists
-	trainID, ok := db.ValidTickets[ticket]
+	ticket, ok := db.Tickets[code]
 
 	if !ok {
-		return "", errors.New("Invalid ticket")
+		return nil, ErrTicketNotFound
+	}
+
+	if db.refreshTicketStatus(ticket) {
+		if err := db.Write(); err != nil {
+			return nil, err
+		}
+	}
+
+	switch ticket.Status {
+	case TicketRevoked:
+		return ticket, ErrTicketRevoked
+	case TicketExpired:
+		return ticket, ErrTicketExpired
+	case TicketCompleted:
+		return ticket, ErrTicketCompleted
+	}
+
+	return ticket, nil
+}
+
+// Get the signed form of a ticket, given its ID or its signed payload. Ed25519 signatures are deterministic: the
+// payload is the same the passenger received when boarding. Only valid tickets are signed: a revoked, expired or
+// completed ticket must not be shown as one that can be travelled with.
+func (db *appdbimpl) GetSignedTicket(code string) (string, error) {
+
+	ticket, err := db.ValidateTicket(code)
+
+	if err != nil {
+		return "", err
 	}
 
-	return trainID, nil
-}
\ No newline at end of file
+	return db.signTicket(ticketClaims(ticket))
+}
+
+// Check whether a user holds a ticket, given its ID or its signed payload
+func (db *appdbimpl) IsTicketHolder(userID string, code string) bool {
+
+	if strings.HasPrefix(code, signedTicketPrefix+".") {
+		claims, err := db.verifySignedTicket(code)
+
+		if err != nil {
+			return false
+		}
+
+		code = claims.TicketID
+	}
+
+	ticket, ok := db.Tickets[code]
+
+	return ok && ticket.UserID == userID
+}
+
+// Revoke a ticket: it won't be valid anymore, even if its signature is
+func (db *appdbimpl) RevokeTicket(ticketID string) error {
+
+	ticket, ok := db.Tickets[ticketID]
+
+	if !ok || ticket.Status == TicketRevoked {
+		return ErrTicketNotFound
+	}
+
+	db.setTicketStatus(ticket, TicketRevoked)
+
+	return db.Write()
+}
+
+// Get the list of the revoked tickets that haven't expired yet, so that scanners can reject them offline
+func (db *appdbimpl) GetRevokedTickets() []RevokedTicket {
+	revoked := make([]RevokedTicket, 0)
+	now := time.Now()
+
+	for _, ticket := range db.Tickets {
+		if ticket.Status != TicketRevoked {
+			continue
+		}
+
+		// an expired ticket is rejected anyway, thanks to the exp claim
+		if expiresAt, err := time.Parse(time.RFC3339, ticket.ExpiresAt); err == nil && expiresAt.Before(now) {
+			continue
+		}
+
+		revoked = append(revoked, RevokedTicket{
+			TicketID:  ticket.ID,
+			TrainID:   ticket.TrainID,
+			RevokedAt: ticket.StatusChangedAt,
+		})
+	}
+
+	sort.Slice(revoked, func(i, j int) bool { return revoked[i].RevokedAt < revoked[j].RevokedAt })
+
+	return revoked
+}
+
+// Get the public key that verifies the signed tickets
+func (db *appdbimpl) GetTicketPublicKey() *TicketPublicKey {
+	return &TicketPublicKey{
+		Algorithm: "Ed25519",
+		KeyID:     db.ticketKeyID(),
+		PublicKey: base64.StdEncoding.EncodeToString(db.cfg.TicketSigningKey.Public().(ed25519.PublicKey)),
+	}
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGetSignedTicket(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		wantErr error
+	}{
+		{"active", TicketActive, nil},
+		{"revoked", TicketRevoked, ErrTicketRevoked},
+		{"completed", TicketCompleted, ErrTicketCompleted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t, nil)
+
+			ticket, signed, err := db.generateTicket("alice", "FR9422", testStation(t, db, "Napoli Centrale"))
+			if err != nil {
+				t.Fatalf("can't generate the ticket: %v", err)
+			}
+			if tt.status != TicketActive {
+				db.setTicketStatus(ticket, tt.status)
+			}
+
+			// by the ID of the ticket, or by its signed payload
+			for _, code := range []string{ticket.ID, signed} {
+				got, err := db.GetSignedTicket(code)
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("GetSignedTicket() error = %v, want %v", err, tt.wantErr)
+				}
+				if err == nil && got != signed {
+					t.Errorf("GetSignedTicket() = %q, want the payload issued when boarding %q", got, signed)
+				}
+			}
+		})
+	}
+}
+
+func TestIsTicketHolder(t *testing.T) {
+	db := newTestDatabase(t, nil)
+
+	ticket, signed, err := db.generateTicket("alice", "FR9422", testStation(t, db, "Napoli Centrale"))
+	if err != nil {
+		t.Fatalf("can't generate the ticket: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		userID string
+		code   string
+		want   bool
+	}{
+		{"holder", "alice", ticket.ID, true},
+		{"holder with the signed payload", "alice", signed, true},
+		{"other user", "bob", ticket.ID, false},
+		{"other user with the signed payload", "bob", signed, false},
+		{"unknown ticket", "alice", "unknown", false},
+		{"forged payload", "alice", signed + "x", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := db.IsTicketHolder(tt.userID, tt.code); got != tt.want {
+				t.Errorf("IsTicketHolder(%q) = %v, want %v", tt.userID, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package database
+
+const (
+	TrainEventArrival   string = "arrival"
+	TrainEventDeparture        = "departure"
+	TrainEventDelay            = "delay"
+	TrainEventPlatform         = "platform"
+)
+
+// TrainEvent is a change of a train made by an update of its position: an arrival or a departure at a station, a new
+// delay, or a new platform at a station. Stops lists the stations of the train, so that the event can be delivered to
+// whoever follows one of them.
+type TrainEvent struct {
+	Type     string   `json:"type"`
+	TrainID  string   `json:"train_id"`
+	Station  string   `json:"station"`
+	Time     string   `json:"time"`
+	Delay    int      `json:"delay"`
+	Platform int      `json:"platform"`
+	Stops    []string `json:"-"`
+}
+
+// Get the names of the stations a train stops at, in order
+func trainStops(train Train) []string {
+	stops := make([]string, 0, len(*train.Trip))
+	for _, item := range *train.Trip {
+		stops = append(stops, item.Station.Name)
+	}
+	return stops
+}
@@ -5,32 +5,36 @@ import (
 	"time"
 )
 
-// Update train position
-func (db *appdbimpl) UpdateTrainPosition(trainID string, stationID string, status string, time_string string) error {
+// Update train position, and the platform at the station if it is given. The changes are returned as events.
+func (db *appdbimpl) UpdateTrainPosition(trainID string, stationID string, status string, time_string string, platform int) ([]TrainEvent, error) {
 
 	train, err := db.getTrainByID(trainID)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// get station
 	station, err := db.GetStationByID(stationID)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	station_idx := indexStation(*station, *train)
 
 	if station_idx == -1 {
-		return errors.New("Station not found in train's trip")
+		return nil, errors.New("Station not found in train's trip")
+	}
+
+	if platform < 0 {
+		return nil, errors.New("Invalid platform")
 	}
 
 	// check if the train is arrived on all the previous stations
 	for i := 0; i < station_idx; i++ {
 		if (*train.Trip)[i].ArrivalTime == "" || (*train.Trip)[i].DepartureTime == "" {
-			return errors.New("Illegal train position update: train is not arrived to and departed from all the previous stations")
+			return nil, errors.New("Illegal train position update: train is not arrived to and departed from all the previous stations")
 		}
 	}
 
@@ -38,29 +42,61 @@ func (db *appdbimpl) UpdateTrainPosition(trainID string, stationID string, statu
 	if status == "departed" {
 		// check if the train is already arrived
 		if station_idx > 0 && (*train.Trip)[station_idx].ArrivalTime == "" {
-			return errors.New("Illegal train position update: train has never arrived to the station")
+			return nil, errors.New("Illegal train position update: train has never arrived to the station")
 		}
 	}
 
+	if time_string == "" {
+		time_string = time.Now().Format("15:04")
+	}
+
+	events := make([]TrainEvent, 0)
+	event := TrainEvent{
+		TrainID:  train.ID,
+		Station:  station.Name,
+		Time:     time_string,
+		Delay:    train.LastDelay,
+		Platform: (*train.Trip)[station_idx].Platform,
+		Stops:    trainStops(*train),
+	}
+
+	if platform > 0 && platform != (*train.Trip)[station_idx].Platform {
+		(*train.Trip)[station_idx].Platform = platform
+		event.Platform = platform
+		event.Type = TrainEventPlatform
+		events = append(events, event)
+	}
+
 	if status == "arrived" {
-		if time_string == "" {
-			(*train.Trip)[station_idx].ArrivalTime = time.Now().Format("15:04")
-		} else {
-			(*train.Trip)[station_idx].ArrivalTime = time_string
-		}
+		(*train.Trip)[station_idx].ArrivalTime = time_string
+		event.Type = TrainEventArrival
+		events = append(events, event)
 	}
 	if status == "departed" {
-		if time_string == "" {
-			(*train.Trip)[station_idx].DepartureTime = time.Now().Format("15:04")
-		} else {
-			(*train.Trip)[station_idx].DepartureTime = time_string
-		}
+		(*train.Trip)[station_idx].DepartureTime = time_string
+		event.Type = TrainEventDeparture
+		events = append(events, event)
+	}
+
+	// the tickets expire when the train arrives to its last stop
+	if status == "arrived" && station_idx == len(*train.Trip)-1 {
+		db.expireTrainTickets(trainID)
 	}
 
 	delay, err := getTrainDelay(*train)
 
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// the events carry the delay after the update
+	for i := range events {
+		events[i].Delay = delay
+	}
+	if delay != train.LastDelay {
+		event.Type = TrainEventDelay
+		event.Delay = delay
+		events = append(events, event)
 	}
 
 	train.LastDelay = delay
@@ -68,18 +104,33 @@ func (db *appdbimpl) UpdateTrainPosition(trainID string, stationID string, statu
 	err = db.Write()
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return events, nil
 }
 
-func (db *appdbimpl) ResetTrainPosition(trainID string) error {
+// Reset the position of a train, for a new run. The passengers still on board get off at the last station the train
+// reached (their trips are incomplete if it reached none after their boarding), since the run is over. It returns
+// their closed sessions.
+func (db *appdbimpl) ResetTrainPosition(trainID string) ([]ClosedSession, error) {
 
 	train, err := db.getTrainByID(trainID)
 
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// the sessions are closed before the trip is cleared, while the last station is still known
+	now := time.Now()
+	closed := make([]ClosedSession, 0)
+	for userID, state := range db.UserStates {
+		if state.Status != InTrain || state.Train == nil || state.Station == nil || state.Train.ID != trainID {
+			continue
+		}
+
+		station := staleSessionStation(state, *train, SessionPolicyLastStation)
+		closed = append(closed, db.closeSession(userID, state, station, TripEndTrainReset, now))
 	}
 
 	for i := 0; i < len(*train.Trip); i++ {
@@ -88,18 +139,14 @@ func (db *appdbimpl) ResetTrainPosition(trainID string) error {
 	}
 	train.LastDelay = 0
 
-	// delete all the tickets for this train
-	for ticket, train := range db.ValidTickets {
-		if train == trainID {
-			delete(db.ValidTickets, ticket)
-		}
-	}
+	// the tickets of the previous run are not valid anymore, but they are kept
+	db.expireTrainTickets(trainID)
 
 	err = db.Write()
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return closed, nil
 }
\ No newline at end of file
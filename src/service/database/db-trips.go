@@ -0,0 +1,228 @@
+package database
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+const (
+	TripBoarded     string = "boarded"
+	TripAlighted           = "alighted"
+	TripTransferred        = "transferred"
+	TripIncomplete         = "incomplete"
+)
+
+// Trip is a journey of a passenger on a train, from the boarding to the alighting. A trip is boarded while the
+// passenger is on the train; it is alighted when the passenger gets off, or transferred when the passenger moves
+// straight to another train. Zero-cost trips are recorded too, without a payment. Trips of passengers who went silent
+// on board (or who are on board when the train is reset) are closed by the server, with the reason in EndReason; they
+// are incomplete when they can't be charged. The sources tell how the boarding and the alighting were established
+// (see PositionSourceBeacon).
+type Trip struct {
+	ID               string  `json:"id"`
+	UserID           string  `json:"user_id"`
+	Status           string  `json:"status"`
+	TrainID          string  `json:"train_id"`
+	TicketID         string  `json:"ticket_id"`
+	BoardingStation  string  `json:"boarding_station"`
+	AlightingStation string  `json:"alighting_station"`
+	BoardedAt        string  `json:"boarded_at"`
+	AlightedAt       string  `json:"alighted_at"`
+	Cost             float64 `json:"cost"`
+	PaymentID        string  `json:"payment_id"`
+	EndReason        string  `json:"end_reason"`
+	BoardingSource   string  `json:"boarding_source"`
+	AlightingSource  string  `json:"alighting_source"`
+}
+
+// tripTransition is what a move of a passenger does to their trips
+type tripTransition int
+
+const (
+	// tripNone leaves the trips alone: the passenger stays on the same train, or moves between stations and away
+	tripNone tripTransition = iota
+
+	// tripBoard starts a trip: the passenger gets on a train
+	tripBoard
+
+	// tripAlight ends the trip: the passenger gets off the train, to a station or away
+	tripAlight
+
+	// tripTransfer ends the trip and starts a new one: the passenger moves straight to another train
+	tripTransfer
+)
+
+// Get the transition of the trips of a passenger moving between two positions:
+//
+//	from \ to  | away   | station | same train | other train
+//	away       | none   | none    | -          | board
+//	station    | none   | none    | -          | board
+//	train      | alight | alight  | none       | transfer
+func nextTripTransition(previous *UserState, next *UserState) tripTransition {
+	onTrain := previous != nil && previous.Status == InTrain && previous.Train != nil
+
+	switch {
+	case next.Status == InTrain && onTrain && previous.Train.ID == next.Train.ID:
+		return tripNone
+	case next.Status == InTrain && onTrain:
+		return tripTransfer
+	case next.Status == InTrain:
+		return tripBoard
+	case onTrain:
+		return tripAlight
+	}
+	return tripNone
+}
+
+// Get the station a train is in at the given time. If the train hasn't arrived to any station yet, we suppose that it
+// is on the first station.
+func trainStationAt(train Train, at time.Time) *Station {
+	station, err := findStationAt(train, at)
+	if err != nil {
+		return (*train.Trip)[0].Station
+	}
+	return station
+}
+
+// Get the trip a passenger is on, creating it for passengers who boarded before the trips were introduced
+func (db *appdbimpl) openTrip(userID string, state *UserState) *Trip {
+	trips := db.Trips[userID]
+	for i := len(trips) - 1; i >= 0; i-- {
+		if trips[i].Status == TripBoarded && trips[i].TicketID == state.TicketID {
+			return &trips[i]
+		}
+	}
+
+	trip := Trip{
+		UserID:          userID,
+		Status:          TripBoarded,
+		TrainID:         state.Train.ID,
+		TicketID:        state.TicketID,
+		BoardingStation: state.Station.Name,
+		BoardingSource:  PositionSourceBeacon,
+	}
+	if tripID, err := uuid.NewV4(); err == nil {
+		trip.ID = tripID.String()
+	}
+	if ticket, found := db.Tickets[state.TicketID]; found {
+		trip.BoardedAt = ticket.IssuedAt
+	}
+
+	db.Trips[userID] = append(db.Trips[userID], trip)
+	return &db.Trips[userID][len(db.Trips[userID])-1]
+}
+
+// Start a trip for a passenger getting on a train, issuing their ticket. The caller is responsible for writing the
+// database.
+func (db *appdbimpl) boardTrain(userID string, state *UserState, at time.Time) (*Ticket, string, error) {
+	tripID, err := uuid.NewV4()
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	ticket, signedTicket, err := db.generateTicket(userID, state.Train.ID, state.Station)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	state.TicketID = ticket.ID
+
+	db.Trips[userID] = append(db.Trips[userID], Trip{
+		ID:              tripID.String(),
+		UserID:          userID,
+		Status:          TripBoarded,
+		TrainID:         state.Train.ID,
+		TicketID:        ticket.ID,
+		BoardingStation: state.Station.Name,
+		BoardedAt:       at.Format(time.RFC3339),
+		BoardingSource:  state.Source,
+	})
+
+	return ticket, signedTicket, nil
+}
+
+// End the trip of a passenger getting off a train at a station, completing their ticket and charging the fare. The
+// trip ends with the given status (alighted or transferred), established from the given source. The caller is
+// responsible for writing the database.
+func (db *appdbimpl) alightTrain(userID string, state *UserState, station *Station, status string, source string, at time.Time) *PaymentResponse {
+	trip := db.openTrip(userID, state)
+
+	db.completeTicket(state.TicketID, station)
+
+	trip.Status = status
+	trip.AlightingStation = station.Name
+	trip.AlightedAt = at.Format(time.RFC3339)
+	trip.AlightingSource = source
+
+	payment, err := db.processPayment(userID, *state.Train, *state.Station, *station)
+
+	if err != nil {
+		// if payment fails, it means that one of the stations is not in the train's trip
+		// if it happens, it means that there is an error in the database
+		// so we don't charge the user
+		return nil
+	}
+
+	trip.Cost = payment.Cost
+	trip.PaymentID = payment.ID
+
+	return payment
+}
+
+// Get the trips of a user, in chronological order. A user without trips has an empty list.
+func (db *appdbimpl) GetTrips(userID string) []Trip {
+	trips := make([]Trip, 0, len(db.Trips[userID]))
+	return append(trips, db.Trips[userID]...)
+}
+
+// TripProgress is the current trip of a passenger, as shown on the current-trip page: their position, their last trip
+// (still boarded while they are on the train) and, on the train, their ticket and the next stop of the train
+type TripProgress struct {
+	Position     *UserState     `json:"position"`
+	Trip         *Trip          `json:"trip"`
+	TicketCode   string         `json:"ticket_code"`
+	SignedTicket string         `json:"signed_ticket"`
+	NextStop     *TrainTripItem `json:"next_stop"`
+	Delay        int            `json:"delay"`
+}
+
+// Get the progress of the current trip of a user
+func (db *appdbimpl) GetTripProgress(userID string) *TripProgress {
+	progress := &TripProgress{Position: db.GetUserPosition(userID)}
+
+	if trips := db.Trips[userID]; len(trips) > 0 {
+		trip := trips[len(trips)-1]
+		progress.Trip = &trip
+	}
+
+	state := progress.Position
+	if state == nil || state.Status != InTrain || state.Train == nil {
+		return progress
+	}
+
+	progress.TicketCode = state.TicketID
+	if signedTicket, err := db.GetSignedTicket(state.TicketID); err == nil {
+		progress.SignedTicket = signedTicket
+	}
+
+	train, err := db.getTrainByID(state.Train.ID)
+	if err != nil {
+		return progress
+	}
+
+	progress.Delay = train.LastDelay
+
+	// the next stop is the first station (after the departure) the train hasn't arrived to yet
+	for i := 1; i < len(*train.Trip); i++ {
+		if (*train.Trip)[i].ArrivalTime == "" {
+			item := (*train.Trip)[i]
+			progress.NextStop = &item
+			break
+		}
+	}
+
+	return progress
+}
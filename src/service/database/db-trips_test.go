@@ -0,0 +1,258 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextTripTransition(t *testing.T) {
+	fr9422 := &Train{ID: "FR9422"}
+	ic774 := &Train{ID: "IC774"}
+	station := &Station{Name: "Roma Termini"}
+
+	away := &UserState{Status: Away}
+	inStation := &UserState{Status: InStation, Station: station}
+	onFR9422 := &UserState{Status: InTrain, Train: fr9422, Station: station}
+	onIC774 := &UserState{Status: InTrain, Train: ic774, Station: station}
+
+	tests := []struct {
+		name     string
+		previous *UserState
+		next     *UserState
+		want     tripTransition
+	}{
+		{"unknown to away", nil, away, tripNone},
+		{"unknown to station", nil, inStation, tripNone},
+		{"unknown to train", nil, onFR9422, tripBoard},
+		{"away to away", away, away, tripNone},
+		{"away to station", away, inStation, tripNone},
+		{"away to train", away, onFR9422, tripBoard},
+		{"station to away", inStation, away, tripNone},
+		{"station to station", inStation, inStation, tripNone},
+		{"station to train", inStation, onFR9422, tripBoard},
+		{"train to away", onFR9422, away, tripAlight},
+		{"train to station", onFR9422, inStation, tripAlight},
+		{"train to same train", onFR9422, onFR9422, tripNone},
+		{"train to other train", onFR9422, onIC774, tripTransfer},
+		{"train without train to train", &UserState{Status: InTrain}, onFR9422, tripBoard},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextTripTransition(tt.previous, tt.next); got != tt.want {
+				t.Errorf("nextTripTransition() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyMove(t *testing.T) {
+	// the positions are built on the database of each test, since the states point to its trains and stations
+	type position func(t *testing.T, db *appdbimpl) *UserState
+
+	away := func(t *testing.T, db *appdbimpl) *UserState {
+		return &UserState{Status: Away}
+	}
+	inStation := func(name string) position {
+		return func(t *testing.T, db *appdbimpl) *UserState {
+			return &UserState{Status: InStation, Station: testStation(t, db, name), Source: PositionSourceBeacon}
+		}
+	}
+	onTrain := func(trainID string, station string) position {
+		return func(t *testing.T, db *appdbimpl) *UserState {
+			return &UserState{
+				Status:  InTrain,
+				Train:   testTrain(t, db, trainID),
+				Station: testStation(t, db, station),
+				Source:  PositionSourceBeacon,
+			}
+		}
+	}
+
+	tests := []struct {
+		name string
+		from position
+		to   position
+
+		// wantTrips are the statuses of the trips of the user after the move
+		wantTrips []string
+
+		// wantTicket tells whether a ticket is issued
+		wantTicket bool
+
+		// wantAlighting and wantCost are the alighting station and the fare of the first trip, if it has ended
+		wantAlighting string
+		wantCost      float64
+	}{
+		{name: "away to away", from: away, to: away, wantTrips: []string{}},
+		{name: "away to station", from: away, to: inStation("Roma Termini"), wantTrips: []string{}},
+		{name: "away to train", from: away, to: onTrain("FR9422", "Napoli Centrale"),
+			wantTrips: []string{TripBoarded}, wantTicket: true},
+		{name: "station to away", from: inStation("Roma Termini"), to: away, wantTrips: []string{}},
+		{name: "station to station", from: inStation("Roma Termini"), to: inStation("Roma Tiburtina"),
+			wantTrips: []string{}},
+		{name: "station to train", from: inStation("Napoli Centrale"), to: onTrain("FR9422", "Napoli Centrale"),
+			wantTrips: []string{TripBoarded}, wantTicket: true},
+		{name: "train to away", from: onTrain("FR9422", "Napoli Centrale"), to: away,
+			wantTrips: []string{TripAlighted}, wantAlighting: "Roma Termini", wantCost: 5.5},
+		{name: "train to station", from: onTrain("FR9422", "Napoli Centrale"), to: inStation("Roma Termini"),
+			wantTrips: []string{TripAlighted}, wantAlighting: "Roma Termini", wantCost: 5.5},
+		{name: "train to same train", from: onTrain("FR9422", "Napoli Centrale"), to: onTrain("FR9422", "Roma Termini"),
+			wantTrips: []string{TripBoarded}},
+		{name: "train to other train", from: onTrain("FR9422", "Napoli Centrale"), to: onTrain("IC774", "Ferrara"),
+			wantTrips: []string{TripTransferred, TripBoarded}, wantTicket: true, wantAlighting: "Roma Termini",
+			wantCost: 5.5},
+		{name: "zero-cost trip", from: onTrain("FR9422", "Roma Termini"), to: inStation("Roma Termini"),
+			wantTrips: []string{TripAlighted}, wantAlighting: "Roma Termini", wantCost: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t, nil)
+
+			// FR9422 left Napoli Centrale and is now in Roma Termini
+			moveTestTrain(t, db, "FR9422", "Napoli Centrale", "arrived", "08:00")
+			moveTestTrain(t, db, "FR9422", "Napoli Centrale", "departed", "08:02")
+			moveTestTrain(t, db, "FR9422", "Roma Termini", "arrived", "09:10")
+
+			now := time.Now()
+
+			if _, err := db.applyMove("alice", tt.from(t, db), now); err != nil {
+				t.Fatalf("can't move the user to the starting position: %v", err)
+			}
+			// the trips started by the starting position are not part of the result
+			startTrips := len(db.Trips["alice"])
+			if startTrips > 1 {
+				t.Fatalf("the starting position started %d trips", startTrips)
+			}
+			startState := db.GetUserPosition("alice")
+
+			next := tt.to(t, db)
+			response, err := db.applyMove("alice", next, now)
+			if err != nil {
+				t.Fatalf("applyMove() error = %v", err)
+			}
+
+			trips := db.GetTrips("alice")
+			if len(trips) != len(tt.wantTrips) {
+				t.Fatalf("got %d trips, want %d: %+v", len(trips), len(tt.wantTrips), trips)
+			}
+			for i, status := range tt.wantTrips {
+				if trips[i].Status != status {
+					t.Errorf("trip %d status = %q, want %q", i, trips[i].Status, status)
+				}
+			}
+
+			if got := response.TicketCode != ""; got != tt.wantTicket {
+				t.Errorf("ticket issued = %v, want %v", got, tt.wantTicket)
+			}
+
+			if response.Status != next.Status {
+				t.Errorf("response status = %q, want %q", response.Status, next.Status)
+			}
+
+			state := db.GetUserPosition("alice")
+			if state.Status != next.Status {
+				t.Errorf("user status = %q, want %q", state.Status, next.Status)
+			}
+			if tt.wantTicket && state.TicketID != response.TicketCode {
+				t.Errorf("user ticket = %q, want the new ticket %q", state.TicketID, response.TicketCode)
+			}
+			if state.Status == InTrain && !tt.wantTicket && state != startState {
+				t.Errorf("the user on the same train should keep their position and their ticket")
+			}
+
+			if tt.wantAlighting == "" {
+				if response.PaymentResponse != nil {
+					t.Errorf("unexpected payment %+v", response.PaymentResponse)
+				}
+				return
+			}
+
+			ended := trips[0]
+			if ended.AlightingStation != tt.wantAlighting {
+				t.Errorf("alighting station = %q, want %q", ended.AlightingStation, tt.wantAlighting)
+			}
+			if ended.AlightedAt == "" {
+				t.Errorf("the ended trip has no alighting time")
+			}
+			if ended.Cost != tt.wantCost {
+				t.Errorf("trip cost = %v, want %v", ended.Cost, tt.wantCost)
+			}
+
+			if ticket := db.Tickets[ended.TicketID]; ticket == nil || ticket.Status != TicketCompleted {
+				t.Errorf("the ticket of the ended trip is not completed: %+v", ticket)
+			}
+
+			// zero-cost trips are recorded without charging the user
+			if tt.wantCost == 0 {
+				if ended.PaymentID != "" {
+					t.Errorf("zero-cost trip has payment %q", ended.PaymentID)
+				}
+				if history := db.PaymentHistory["alice"]; len(history) != 0 {
+					t.Errorf("zero-cost trip charged the user: %+v", history)
+				}
+				return
+			}
+
+			if response.PaymentResponse == nil || ended.PaymentID != response.PaymentResponse.ID {
+				t.Errorf("trip payment = %q, want the payment of the response %+v", ended.PaymentID, response.PaymentResponse)
+			}
+		})
+	}
+}
+
+func TestOpenTrip(t *testing.T) {
+	db := newTestDatabase(t, nil)
+
+	train := testTrain(t, db, "FR9422")
+	napoli := testStation(t, db, "Napoli Centrale")
+
+	// a passenger who boarded before the trips were introduced has a ticket, but no trip
+	ticket, _, err := db.generateTicket("alice", train.ID, napoli)
+	if err != nil {
+		t.Fatalf("can't generate the ticket: %v", err)
+	}
+	state := &UserState{Status: InTrain, Train: train, Station: napoli, TicketID: ticket.ID}
+	db.UserStates["alice"] = state
+
+	trip := db.openTrip("alice", state)
+
+	if got := len(db.Trips["alice"]); got != 1 {
+		t.Fatalf("got %d trips, want 1", got)
+	}
+	if trip.ID == "" {
+		t.Errorf("the trip has no ID")
+	}
+	if trip.Status != TripBoarded || trip.TrainID != train.ID || trip.TicketID != ticket.ID {
+		t.Errorf("trip = %+v, want a boarded trip on %s with ticket %s", trip, train.ID, ticket.ID)
+	}
+	if trip.BoardingStation != napoli.Name {
+		t.Errorf("boarding station = %q, want %q", trip.BoardingStation, napoli.Name)
+	}
+	if trip.BoardedAt != ticket.IssuedAt {
+		t.Errorf("boarded at %q, want the issue time of the ticket %q", trip.BoardedAt, ticket.IssuedAt)
+	}
+	if trip.BoardingSource != PositionSourceBeacon {
+		t.Errorf("boarding source = %q, want %q", trip.BoardingSource, PositionSourceBeacon)
+	}
+
+	// the trip is reused afterwards
+	if again := db.openTrip("alice", state); again.ID != trip.ID || len(db.Trips["alice"]) != 1 {
+		t.Errorf("openTrip() created a second trip %+v", again)
+	}
+
+	// and it is ended when the passenger gets off
+	moveTestTrain(t, db, "FR9422", "Napoli Centrale", "arrived", "08:00")
+	moveTestTrain(t, db, "FR9422", "Napoli Centrale", "departed", "08:02")
+	moveTestTrain(t, db, "FR9422", "Roma Termini", "arrived", "09:10")
+
+	if _, err := db.applyMove("alice", &UserState{Status: Away}, time.Now()); err != nil {
+		t.Fatalf("applyMove() error = %v", err)
+	}
+
+	trips := db.GetTrips("alice")
+	if len(trips) != 1 || trips[0].Status != TripAlighted || trips[0].AlightingStation != "Roma Termini" {
+		t.Errorf("trips = %+v, want the legacy trip alighted in Roma Termini", trips)
+	}
+}
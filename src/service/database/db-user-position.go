@@ -1,202 +1,166 @@
 package database
 
-// Update user position
-func (db *appdbimpl) UpdateUserPosition(userID string, beaconID string) (*UpdateUserPositionResponse, error) {
+import (
+	"strings"
+	"time"
+)
+
+// Update user position, resolving it from the beacons heard by the phone of the user
+func (db *appdbimpl) UpdateUserPosition(userID string, sightings []BeaconSighting) (*UpdateUserPositionResponse, error) {
+	now := time.Now()
+	db.eventCursor(userID).LastEventTime = now.Format(time.RFC3339)
+	return db.updatePosition(userID, sightings, now, now)
+}
 
-	// get current user position
-	previousPosition := Away
-	previousUserPosition := db.GetUserPosition(userID)
+// Update user position from the beacons heard by the phone of the user at the given time, received by the server at
+// the given time (later than the beacons were heard, for the events uploaded in batches)
+func (db *appdbimpl) updatePosition(userID string, sightings []BeaconSighting, at time.Time, received time.Time) (*UpdateUserPositionResponse, error) {
+	beaconID, scan := db.resolveBeacon(userID, sightings, at, received)
 
-	if previousUserPosition != nil {
-		previousPosition = previousUserPosition.Status
+	// suspicious movements are recorded for review, but never block the passenger
+	flagged := len(db.SuspiciousEvents)
+	if err := db.checkMovement(userID, scan, at); err != nil {
+		return nil, err
 	}
 
-	if station := db.GetStationByBeaconID(beaconID); station != nil {
-		// User is in a station
-
-		// update the database
-		db.UserStates[userID] = &UserState{
-			Station: station,
-			Train:   nil,
-			Status:  InStation,
-		}
-
-		// update the database
-		err := db.Write()
+	// a user who checked in by hand stays on the train until they check out, or until they are heard on another train
+	stay := checkedIn(db.GetUserPosition(userID)) && db.GetTrainByBeaconID(beaconID, at) == nil
 
-		if err != nil {
-			// error writing to the database
-			return nil, err
+	if stay || !db.settleBeacon(userID, beaconID, at) {
+		// not enough evidence yet that the user has moved
+		response := db.pendingPositionResponse(userID, beaconID, at)
+		if stay {
+			delete(db.pending, userID)
+			response.PendingStatus = ""
 		}
+		response.Rejected = scan.Rejected
 
-		// check if the user was on a train
-		if previousPosition == InTrain {
-			// process payment
-			payment, err := db.processPayment(userID, *previousUserPosition.Train, *previousUserPosition.Station, *station)
-
-			// payment is successful
-			if err == nil {
-				return &UpdateUserPositionResponse{
-					Status:          InStation,
-					ID:              station.Name,
-					PaymentResponse: payment,
-				}, nil
+		if len(db.SuspiciousEvents) != flagged {
+			if err := db.Write(); err != nil {
+				return nil, err
 			}
-			// if payment fails, it means that one of the stations is not in the train's trip
-			// if it happens, it means that there is an error in the database
-			// so we don't charge the user
 		}
 
-		// user was not on a train
-		return &UpdateUserPositionResponse{
-			Status:          InStation,
-			ID:              station.Name,
-			PaymentResponse: nil,
-		}, nil
+		return response, nil
+	}
 
-	} else if train := db.GetTrainByBeaconID(beaconID); train != nil {
-		// User is on a train
+	response, err := db.moveUser(userID, beaconID, at)
 
-		// Check if the user was in a different train before
-		// this means that the user has changed train (very quickly)
+	if response == nil {
+		return response, err
+	}
 
-		last_train_station, err := findLastStation(*train)
+	response.Rejected = scan.Rejected
 
-		if err != nil {
-			// error occurred: train hasn't arrived to any station
-			// we suppose that the train is on the first station
-			last_train_station = (*train.Trip)[0].Station
+	if response.TicketCode != "" {
+		flagged = len(db.SuspiciousEvents)
+		if err := db.checkTicketChurn(userID, at); err != nil {
+			return nil, err
 		}
-
-		ticket := ""
-		if previousPosition != InTrain || previousUserPosition.Train.BeaconID != beaconID {
-			ticket, err = db.generateTicket(train.ID)
-
-			if err != nil {
-				// error generating ticket
+		if len(db.SuspiciousEvents) != flagged {
+			if err := db.Write(); err != nil {
 				return nil, err
 			}
-
-			// update the database
-			db.UserStates[userID] = &UserState{
-				Station: last_train_station,
-				Train:   train,
-				Status:  InTrain,
-			}
-		}
-
-		// update the database
-		err = db.Write()
-
-		if err != nil {
-			// error writing to the database
-			return nil, err
 		}
+	}
 
-		if previousPosition == InTrain && previousUserPosition.Train.BeaconID != beaconID {
+	return response, err
+}
 
-			// find previous train's last station
-			last_train_station, err := findLastStation(*previousUserPosition.Train)
+// Get the position of a beacon at the given time, as the state of a user there. A user on a train boards it at the
+// station the train is in.
+func (db *appdbimpl) positionAt(beaconID string, at time.Time) *UserState {
+	if station := db.GetStationByBeaconID(beaconID); station != nil {
+		return &UserState{Status: InStation, Station: station}
+	}
+	if train := db.GetTrainByBeaconID(beaconID, at); train != nil {
+		return &UserState{Status: InTrain, Train: train, Station: trainStationAt(*train, at)}
+	}
+	return &UserState{Status: Away}
+}
 
-			if err != nil {
-				// error occurred: train hasn't arrived to any station
-				// we suppose that the train is on the first station
-				last_train_station = (*previousUserPosition.Train.Trip)[0].Station
-			}
+// Move the user to the station or the train of the given beacon, or away if the beacon is unknown. The stations
+// where the user got on and off the trains are the ones the trains were in at the given time, unless the user got off
+// in a station.
+func (db *appdbimpl) moveUser(userID string, beaconID string, at time.Time) (*UpdateUserPositionResponse, error) {
+	next := db.positionAt(beaconID, at)
+	next.Source = PositionSourceBeacon
+	if strings.HasPrefix(beaconID, stationCodeBeaconPrefix) {
+		next.Source = PositionSourceQRCode
+	}
+	return db.applyMove(userID, next, at)
+}
 
-			payment, err := db.processPayment(userID, *previousUserPosition.Train, *previousUserPosition.Station, *last_train_station)
-
-			if err != nil {
-				// error processing the payment
-				// if it happens, it means that there is an error in the database
-				// so we don't charge the user
-				return &UpdateUserPositionResponse{
-					Status:          InTrain,
-					ID:              train.ID,
-					PaymentResponse: nil,
-					TicketCode:      ticket,
-				}, err
-			}
+// Move the user to the given position, through the trip state machine (see nextTripTransition)
+func (db *appdbimpl) applyMove(userID string, next *UserState, at time.Time) (*UpdateUserPositionResponse, error) {
+	previous := db.GetUserPosition(userID)
+	transition := nextTripTransition(previous, next)
 
-			return &UpdateUserPositionResponse{
-				Status:          InTrain,
-				ID:              train.ID,
-				PaymentResponse: payment,
-				TicketCode:      ticket,
-			}, nil
-		}
+	response := &UpdateUserPositionResponse{Status: next.Status}
 
-		// user still is in the same train
-		// we don't need to update the database or process any payment (yet)
-		return &UpdateUserPositionResponse{
-			Status:          InTrain,
-			ID:              train.ID,
-			PaymentResponse: nil,
-			TicketCode:      ticket,
-		}, nil
-
-	} else {
-		// User is away from any beacon
-
-		// update the database
-		db.UserStates[userID] = &UserState{
-			Station: nil,
-			Train:   nil,
-			Status:  Away,
+	switch transition {
+	case tripAlight:
+		station := next.Station
+		if station == nil {
+			station = trainStationAt(*previous.Train, at)
 		}
+		response.PaymentResponse = db.alightTrain(userID, previous, station, TripAlighted, next.Source, at)
+	case tripTransfer:
+		response.PaymentResponse = db.alightTrain(userID, previous, trainStationAt(*previous.Train, at), TripTransferred, next.Source, at)
+	}
 
-		// update the database
-		err := db.Write()
+	switch transition {
+	case tripBoard, tripTransfer:
+		ticket, signedTicket, err := db.boardTrain(userID, next, at)
 
 		if err != nil {
-			// error writing to the database
+			// error generating ticket
 			return nil, err
 		}
 
-		if previousPosition == InTrain {
-			// User was on a train and it ended its trip
+		response.TicketCode, response.SignedTicket = ticket.ID, signedTicket
+	case tripNone:
+		if next.Status == InTrain {
+			// user still is in the same train, with the same ticket
+			next = previous
+		}
+	}
 
-			// find train's last station
-			last_train_station, err := findLastStation(*previousUserPosition.Train)
+	// update the database
+	db.UserStates[userID] = next
+	if committedPosition(previous) != committedPosition(next) {
+		db.eventCursor(userID).LastMoveTime = at.Format(time.RFC3339)
+	}
 
-			if err != nil {
-				// error occurred: train hasn't arrived to any station
-				// we suppose that the train is on the first station
-				last_train_station = (*previousUserPosition.Train.Trip)[0].Station
-			}
+	err := db.Write()
 
-			payment, err := db.processPayment(userID, *previousUserPosition.Train, *previousUserPosition.Station, *last_train_station)
-
-			if err != nil {
-				// error occurred: station not found in train's trip
-				// if it happens, it means that there is an error in the database
-				// so we don't charge the user
-				return &UpdateUserPositionResponse{
-					Status:          Away,
-					ID:              "",
-					PaymentResponse: nil,
-					TicketCode:      "",
-				}, err
-			}
+	if err != nil {
+		// error writing to the database
+		return nil, err
+	}
 
-			// payment is successful
-			return &UpdateUserPositionResponse{
-				Status:          Away,
-				ID:              "",
-				PaymentResponse: payment,
-				TicketCode:      "",
-			}, nil
-		}
+	switch next.Status {
+	case InStation:
+		response.ID = next.Station.Name
+	case InTrain:
+		response.ID = next.Train.ID
+	}
 
-		// user hasn't been on a train before
-		// or payment was already processed
-		return &UpdateUserPositionResponse{
-			Status:          Away,
-			ID:              "",
-			PaymentResponse: nil,
-			TicketCode:      "",
-		}, nil
+	return db.withDebtWarning(userID, response), nil
+}
+
+// Warn the user boarding a new train (i.e., receiving a new ticket) that there are unpaid trips
+func (db *appdbimpl) withDebtWarning(userID string, response *UpdateUserPositionResponse) *UpdateUserPositionResponse {
+	if response.TicketCode == "" {
+		return response
 	}
+
+	if debt := db.getUserDebt(userID); debt > 0 {
+		response.Warning = WarningUnpaidDebt
+		response.Debt = debt
+	}
+
+	return response
 }
 
 // Get user position
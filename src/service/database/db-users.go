@@ -0,0 +1,226 @@
+package database
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+const (
+	RolePassenger string = "passenger"
+	RoleOperator         = "operator"
+	RoleInspector        = "inspector"
+	RoleAdmin            = "admin"
+)
+
+const (
+	// passwordIterations is the number of PBKDF2 iterations used to hash the passwords
+	passwordIterations = 100000
+
+	minUsernameLength = 3
+	maxUsernameLength = 20
+	minPasswordLength = 8
+)
+
+var (
+	// ErrUserExists is returned when registering a username that is already taken
+	ErrUserExists = errors.New("User already exists")
+
+	// ErrInvalidCredentials is returned when the username or the password is wrong
+	ErrInvalidCredentials = errors.New("Invalid username or password")
+
+	// ErrInvalidUsername is returned when the username is too short or too long
+	ErrInvalidUsername = errors.New("The username must be between 3 and 20 characters long")
+
+	// ErrInvalidPassword is returned when the password is too short
+	ErrInvalidPassword = errors.New("The password must be at least 8 characters long")
+
+	// ErrUserNotFound is returned when the user does not exist
+	ErrUserNotFound = errors.New("User not found")
+
+	// ErrInvalidRole is returned when assigning an unknown role
+	ErrInvalidRole = errors.New("Invalid role")
+)
+
+type User struct {
+	Username     string   `json:"username"`
+	PasswordHash string   `json:"password_hash"`
+	PasswordSalt string   `json:"password_salt"`
+	CreatedAt    string   `json:"created_at"`
+	Role         string   `json:"role"`
+	Lines        []string `json:"lines"`
+}
+
+// Derive a key from the password with PBKDF2-HMAC-SHA256 (RFC 8018), producing a single block
+func hashPassword(password string, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, []byte(password))
+
+	// first block: U1 = PRF(password, salt || INT(1))
+	var blockIndex [4]byte
+	binary.BigEndian.PutUint32(blockIndex[:], 1)
+	prf.Write(salt)
+	prf.Write(blockIndex[:])
+	u := prf.Sum(nil)
+
+	key := make([]byte, len(u))
+	copy(key, u)
+
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+
+	return key
+}
+
+// HashPassword hashes a new password with a random salt, returning the hash and the salt (base64 encoded). It is slow
+// by design and doesn't use the database, so it can be called without holding the lock of the database.
+func HashPassword(password string) (string, string, error) {
+
+	if len(password) < minPasswordLength {
+		return "", "", ErrInvalidPassword
+	}
+
+	salt := make([]byte, 16)
+
+	_, err := rand.Read(salt)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	hash := hashPassword(password, salt, passwordIterations)
+
+	return base64.StdEncoding.EncodeToString(hash), base64.StdEncoding.EncodeToString(salt), nil
+}
+
+// CheckPassword checks the password of a user. As HashPassword, it is slow and doesn't use the database.
+func CheckPassword(user User, password string) error {
+
+	salt, err := base64.StdEncoding.DecodeString(user.PasswordSalt)
+
+	if err != nil {
+		return err
+	}
+
+	hash, err := base64.StdEncoding.DecodeString(user.PasswordHash)
+
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare(hash, hashPassword(password, salt, passwordIterations)) != 1 {
+		return ErrInvalidCredentials
+	}
+
+	return nil
+}
+
+// Register a new user, with the password hashed by HashPassword
+func (db *appdbimpl) CreateUser(username string, passwordHash string, passwordSalt string) (*User, error) {
+
+	if len(username) < minUsernameLength || len(username) > maxUsernameLength {
+		return nil, ErrInvalidUsername
+	}
+
+	if _, ok := db.Users[username]; ok {
+		return nil, ErrUserExists
+	}
+
+	user := User{
+		Username:     username,
+		PasswordHash: passwordHash,
+		PasswordSalt: passwordSalt,
+		CreatedAt:    time.Now().Format(time.RFC3339),
+		Role:         RolePassenger,
+		Lines:        make([]string, 0),
+	}
+
+	db.Users[username] = &user
+
+	err := db.Write()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// Get a user
+func (db *appdbimpl) GetUser(username string) (*User, error) {
+	user, ok := db.Users[username]
+
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+
+	// users registered before roles were introduced are passengers
+	if user.Role == "" {
+		user.Role = RolePassenger
+	}
+
+	return user, nil
+}
+
+// Set the role of a user. Lines are the train lines an operator works on, and are ignored for other roles.
+func (db *appdbimpl) SetUserRole(username string, role string, lines []string) (*User, error) {
+
+	switch role {
+	case RolePassenger, RoleOperator, RoleInspector, RoleAdmin:
+	default:
+		return nil, ErrInvalidRole
+	}
+
+	user, err := db.GetUser(username)
+
+	if err != nil {
+		return nil, err
+	}
+
+	user.Role = role
+	user.Lines = make([]string, 0)
+	if role == RoleOperator {
+		user.Lines = append(user.Lines, lines...)
+	}
+
+	err = db.Write()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Check whether an operator works on the line of a train
+func (db *appdbimpl) IsOperatorOfTrain(username string, trainID string) bool {
+	user, err := db.GetUser(username)
+
+	if err != nil || user.Role != RoleOperator {
+		return false
+	}
+
+	train, err := db.getTrainByID(trainID)
+
+	if err != nil {
+		return false
+	}
+
+	for _, line := range user.Lines {
+		if line != "" && line == train.Line {
+			return true
+		}
+	}
+
+	return false
+}
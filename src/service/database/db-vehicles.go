@@ -0,0 +1,241 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// runSlack is how long before the first departure and after the last arrival a vehicle belongs to a train run, so
+// that passengers boarding early or getting off late are located on the train
+const runSlack = 30 * time.Minute
+
+var (
+	// ErrVehicleNotFound is returned when the vehicle does not exist
+	ErrVehicleNotFound = errors.New("Vehicle not found")
+
+	// ErrVehicleExists is returned when registering a vehicle ID that is already taken
+	ErrVehicleExists = errors.New("Vehicle already registered")
+
+	// ErrInvalidVehicle is returned when the vehicle has no ID or a negative number of cars
+	ErrInvalidVehicle = errors.New("The vehicle needs an ID and a non-negative number of cars")
+
+	// ErrInvalidRunDate is returned when the date of a train run is not a valid date (YYYY-MM-DD)
+	ErrInvalidRunDate = errors.New("Invalid run date")
+
+	// ErrVehicleAlreadyAssigned is returned when a vehicle is assigned twice to a run, or to two overlapping runs
+	ErrVehicleAlreadyAssigned = errors.New("The vehicle is already assigned to an overlapping run")
+
+	// ErrInvalidSchedule is returned when assigning vehicles to a train whose scheduled times can't be read
+	ErrInvalidSchedule = errors.New("The schedule of the train is not valid")
+)
+
+// Vehicle is a physical trainset. The beacons in its cars locate the passengers on the train runs it is assigned to.
+type Vehicle struct {
+	ID    string `json:"id"`
+	Model string `json:"model"`
+	Cars  int    `json:"cars"`
+}
+
+// VehicleAssignment is the list of the vehicles (coupled, from the head of the train) running a train on a day
+type VehicleAssignment struct {
+	Date     string   `json:"date"`
+	TrainID  string   `json:"train_id"`
+	Vehicles []string `json:"vehicles"`
+}
+
+// Get the vehicles, sorted by ID
+func (db *appdbimpl) GetVehicles() []Vehicle {
+	vehicles := make([]Vehicle, 0, len(db.Vehicles))
+	for _, vehicle := range db.Vehicles {
+		vehicles = append(vehicles, *vehicle)
+	}
+
+	sort.Slice(vehicles, func(i, j int) bool {
+		return vehicles[i].ID < vehicles[j].ID
+	})
+
+	return vehicles
+}
+
+// Register a new vehicle
+func (db *appdbimpl) CreateVehicle(vehicle Vehicle) (*Vehicle, error) {
+	if vehicle.ID == "" || vehicle.Cars < 0 {
+		return nil, ErrInvalidVehicle
+	}
+
+	if _, found := db.Vehicles[vehicle.ID]; found {
+		return nil, ErrVehicleExists
+	}
+
+	db.Vehicles[vehicle.ID] = &vehicle
+
+	err := db.Write()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &vehicle, nil
+}
+
+// Get the time span of the run of a train on a day, from the first scheduled departure to the last scheduled
+// arrival (on the following day for overnight trains) plus the delay, widened by the run slack
+func runWindow(train Train, date time.Time) (time.Time, time.Time, bool) {
+	if train.Trip == nil || len(*train.Trip) == 0 {
+		return time.Time{}, time.Time{}, false
+	}
+
+	trip := *train.Trip
+	departure, err := time.ParseInLocation("15:04", trip[0].ScheduledDepartureTime, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	arrival, err := time.ParseInLocation("15:04", trip[len(trip)-1].ScheduledArrivalTime, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	start := time.Date(date.Year(), date.Month(), date.Day(), departure.Hour(), departure.Minute(), 0, 0, time.Local)
+	end := time.Date(date.Year(), date.Month(), date.Day(), arrival.Hour(), arrival.Minute(), 0, 0, time.Local)
+	if end.Before(start) {
+		end = end.AddDate(0, 0, 1)
+	}
+
+	return start.Add(-runSlack), end.Add(time.Duration(train.LastDelay)*time.Minute + runSlack), true
+}
+
+// Get the train run by a vehicle at the given time, through the assignments of that day and of the day before (for
+// overnight trains). Returns nil if the vehicle isn't running any train.
+func (db *appdbimpl) getTrainByVehicle(vehicleID string, at time.Time) *Train {
+	today := at.Format("2006-01-02")
+	yesterday := at.AddDate(0, 0, -1).Format("2006-01-02")
+
+	for _, assignment := range db.Assignments {
+		if assignment.Date != today && assignment.Date != yesterday {
+			continue
+		}
+		if !containsString(assignment.Vehicles, vehicleID) {
+			continue
+		}
+
+		train, err := db.getTrainByID(assignment.TrainID)
+		if err != nil {
+			continue
+		}
+
+		date, _ := time.ParseInLocation("2006-01-02", assignment.Date, time.Local)
+		if start, end, ok := runWindow(*train, date); ok && !at.Before(start) && !at.After(end) {
+			return train
+		}
+	}
+
+	return nil
+}
+
+// Check if a list of strings contains a string
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the assignments of a day, sorted by train
+func (db *appdbimpl) GetVehicleAssignments(date string) ([]VehicleAssignment, error) {
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		return nil, ErrInvalidRunDate
+	}
+
+	assignments := make([]VehicleAssignment, 0)
+	for _, assignment := range db.Assignments {
+		if assignment.Date == date {
+			assignments = append(assignments, assignment)
+		}
+	}
+
+	sort.Slice(assignments, func(i, j int) bool {
+		return assignments[i].TrainID < assignments[j].TrainID
+	})
+
+	return assignments, nil
+}
+
+// Assign the vehicles to the run of a train on a day, replacing the previous assignment of the run. An empty list of
+// vehicles removes the assignment. A vehicle can't run two trains at the same time.
+func (db *appdbimpl) AssignVehicles(date string, trainID string, vehicles []string) (*VehicleAssignment, error) {
+	runDate, err := time.ParseInLocation("2006-01-02", date, time.Local)
+
+	if err != nil {
+		return nil, ErrInvalidRunDate
+	}
+
+	train, err := db.getTrainByID(trainID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// without the time span of the run, the overlapping runs can't be found
+	start, end, ok := runWindow(*train, runDate)
+
+	if !ok && len(vehicles) > 0 {
+		return nil, ErrInvalidSchedule
+	}
+
+	for i, vehicleID := range vehicles {
+		if _, found := db.Vehicles[vehicleID]; !found {
+			return nil, ErrVehicleNotFound
+		}
+		if containsString(vehicles[:i], vehicleID) {
+			return nil, ErrVehicleAlreadyAssigned
+		}
+
+		// the vehicle must be free during the whole run
+		for _, assignment := range db.Assignments {
+			if assignment.TrainID == train.ID && assignment.Date == date {
+				continue
+			}
+			if !containsString(assignment.Vehicles, vehicleID) {
+				continue
+			}
+
+			other, err := db.getTrainByID(assignment.TrainID)
+			if err != nil {
+				continue
+			}
+			otherDate, _ := time.ParseInLocation("2006-01-02", assignment.Date, time.Local)
+			if otherStart, otherEnd, ok := runWindow(*other, otherDate); ok && start.Before(otherEnd) && otherStart.Before(end) {
+				return nil, ErrVehicleAlreadyAssigned
+			}
+		}
+	}
+
+	assignment := VehicleAssignment{
+		Date:     date,
+		TrainID:  train.ID,
+		Vehicles: append(make([]string, 0, len(vehicles)), vehicles...),
+	}
+
+	// replace the previous assignment of the run
+	assignments := make([]VehicleAssignment, 0, len(db.Assignments)+1)
+	for _, previous := range db.Assignments {
+		if previous.TrainID != train.ID || previous.Date != date {
+			assignments = append(assignments, previous)
+		}
+	}
+	if len(vehicles) > 0 {
+		assignments = append(assignments, assignment)
+	}
+	db.Assignments = assignments
+
+	err = db.Write()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &assignment, nil
+}
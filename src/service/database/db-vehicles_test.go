@@ -0,0 +1,48 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAssignVehiclesSchedule(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule string
+		vehicles []string
+		wantErr  error
+	}{
+		{name: "valid schedule", schedule: "11:00", vehicles: []string{"ETR1000-01"}},
+		{name: "invalid schedule", schedule: "soon", vehicles: []string{"ETR1000-01"}, wantErr: ErrInvalidSchedule},
+		{name: "assignment removed with an invalid schedule", schedule: "soon"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t, nil)
+			if _, err := db.CreateVehicle(Vehicle{ID: "ETR1000-01", Model: "ETR 1000", Cars: 8}); err != nil {
+				t.Fatalf("CreateVehicle() error = %v", err)
+			}
+			if _, err := db.AssignVehicles("2026-10-19", "FR9422", []string{"ETR1000-01"}); err != nil {
+				t.Fatalf("AssignVehicles() error = %v", err)
+			}
+
+			(*testTrain(t, db, "FR9422").Trip)[0].ScheduledDepartureTime = tt.schedule
+
+			_, err := db.AssignVehicles("2026-10-19", "FR9422", tt.vehicles)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AssignVehicles() error = %v, want %v", err, tt.wantErr)
+			}
+
+			// a refused assignment leaves the previous one in place
+			assignments, _ := db.GetVehicleAssignments("2026-10-19")
+			wantAssignments := 1
+			if err == nil && len(tt.vehicles) == 0 {
+				wantAssignments = 0
+			}
+			if len(assignments) != wantAssignments {
+				t.Errorf("got %d assignments, want %d", len(assignments), wantAssignments)
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ami-sc/DajeTrains/service/payments"
+	"github.com/gofrs/uuid"
+)
+
+const (
+	WalletTopUp          string = "top_up"
+	WalletFare                  = "fare"
+	WalletRefund                = "refund"
+	WalletDebtSettlement        = "debt_settlement"
+	WalletFine                  = "fine"
+	WalletTopUpRefund           = "top_up_refund"
+)
+
+var (
+	// ErrInvalidAmount is returned when topping up a wallet with a non-positive amount
+	ErrInvalidAmount = errors.New("The amount must be greater than zero")
+
+	// ErrTopUpNotFound is returned when completing a top up that has not been started
+	ErrTopUpNotFound = errors.New("Top up not found")
+
+	// ErrTopUpNotRefundable is returned when refunding a top up that has not been captured
+	ErrTopUpNotRefundable = errors.New("Only captured top ups can be refunded")
+)
+
+// WalletMovement is an entry of the wallet ledger. Amounts are in euro cents: credits are positive, debits negative.
+type WalletMovement struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	Amount    int64  `json:"amount"`
+	Balance   int64  `json:"balance"`
+	PaymentID string `json:"payment_id"`
+	Time      string `json:"time"`
+}
+
+// TopUp is a charge on the user payment method that credits the wallet
+type TopUp struct {
+	ID              string `json:"id"`
+	Status          string `json:"status"`
+	IdempotencyKey  string `json:"idempotency_key"`
+	AuthorizationID string `json:"authorization_id"`
+	FailureReason   string `json:"failure_reason"`
+	Amount          int64  `json:"amount"`
+	Time            string `json:"time"`
+}
+
+// Wallet is the prepaid stored value of a user
+type Wallet struct {
+	Balance   int64            `json:"balance"`
+	Movements []WalletMovement `json:"movements"`
+	TopUps    []TopUp          `json:"top_ups"`
+}
+
+type WalletResponse struct {
+	Balance   int64            `json:"balance"`
+	Debt      int64            `json:"debt"`
+	Movements []WalletMovement `json:"movements"`
+}
+
+// Get the wallet of a user, creating an empty one if needed
+func (db *appdbimpl) getWallet(userID string) *Wallet {
+	wallet, ok := db.Wallets[userID]
+	if !ok {
+		wallet = &Wallet{
+			Balance:   0,
+			Movements: make([]WalletMovement, 0),
+			TopUps:    make([]TopUp, 0),
+		}
+		db.Wallets[userID] = wallet
+	}
+	return wallet
+}
+
+// Add a movement to the wallet ledger and update the balance. The caller is responsible for writing the database.
+func (db *appdbimpl) addWalletMovement(wallet *Wallet, movementType string, amount int64, paymentID string) {
+	movementID, err := uuid.NewV4()
+
+	id := ""
+	if err == nil {
+		id = movementID.String()
+	}
+
+	wallet.Balance += amount
+	wallet.Movements = append(wallet.Movements, WalletMovement{
+		ID:        id,
+		Type:      movementType,
+		Amount:    amount,
+		Balance:   wallet.Balance,
+		PaymentID: paymentID,
+		Time:      time.Now().Format(time.RFC3339),
+	})
+}
+
+// Get the total amount of the unpaid trips of a user
+func (db *appdbimpl) getUserDebt(userID string) int64 {
+	debt := int64(0)
+	for _, payment := range db.PaymentHistory[userID] {
+		if payment.Status == PaymentUnpaid {
+			debt += toCents(payment.Cost)
+		}
+	}
+	return debt
+}
+
+// Pay the unpaid trips of a user (oldest first) with the wallet balance, as long as it is enough, and issue their
+// receipts
+func (db *appdbimpl) settleDebts(userID string) {
+	wallet := db.getWallet(userID)
+
+	for i, payment := range db.PaymentHistory[userID] {
+		if payment.Status != PaymentUnpaid {
+			continue
+		}
+
+		amount := toCents(payment.Cost)
+		if wallet.Balance < amount {
+			return
+		}
+
+		db.addWalletMovement(wallet, WalletDebtSettlement, -amount, payment.ID)
+		db.PaymentHistory[userID][i].Status = PaymentCaptured
+		db.PaymentHistory[userID][i].FailureReason = ""
+		// the debts of the databases created before keep the receipt issued along with them
+		if payment.ReceiptNumber == "" {
+			db.PaymentHistory[userID][i].ReceiptNumber = db.nextReceiptNumber()
+		}
+	}
+}
+
+// Get the wallet of a user
+func (db *appdbimpl) GetWallet(userID string) *WalletResponse {
+	wallet, ok := db.Wallets[userID]
+
+	if !ok {
+		return &WalletResponse{
+			Balance:   0,
+			Debt:      db.getUserDebt(userID),
+			Movements: make([]WalletMovement, 0),
+		}
+	}
+
+	return &WalletResponse{
+		Balance:   wallet.Balance,
+		Debt:      db.getUserDebt(userID),
+		Movements: wallet.Movements,
+	}
+}
+
+// Start a top up of the wallet of a user, for the amount in euro cents. The top up is stored as pending, so that it is
+// not charged twice: it must be charged with ChargeTopUp, then completed with CompleteTopUp. If a top up with the
+// same idempotency key has already been started, it is returned instead, and false tells that it must not be charged.
+// A pending top up that is not being charged anymore (the capture timed out, or the server stopped before completing
+// it) is returned to be charged again: the provider tells whether it has been captured in the meantime.
+func (db *appdbimpl) StartTopUp(userID string, amount int64, idempotencyKey string) (*TopUp, bool, error) {
+
+	if amount <= 0 {
+		return nil, false, ErrInvalidAmount
+	}
+
+	wallet := db.getWallet(userID)
+
+	if db.charging == nil {
+		db.charging = make(map[string]bool)
+	}
+
+	// the same top up is never charged twice
+	if idempotencyKey != "" {
+		for _, topUp := range wallet.TopUps {
+			if topUp.IdempotencyKey != idempotencyKey || topUp.Status == PaymentFailed {
+				continue
+			}
+			if topUp.Status == PaymentPending && !db.charging[topUp.ID] {
+				db.charging[topUp.ID] = true
+				return &topUp, true, nil
+			}
+			return &topUp, false, nil
+		}
+	}
+
+	topUpID, err := uuid.NewV4()
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	topUp := TopUp{
+		ID:             topUpID.String(),
+		Status:         PaymentPending,
+		IdempotencyKey: idempotencyKey,
+		Amount:         amount,
+		Time:           time.Now().Format(time.RFC3339),
+	}
+
+	if topUp.IdempotencyKey == "" {
+		topUp.IdempotencyKey = topUp.ID
+	}
+
+	wallet.TopUps = append(wallet.TopUps, topUp)
+
+	err = db.Write()
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	db.charging[topUp.ID] = true
+
+	return &topUp, true, nil
+}
+
+// Charge a started top up through the payment provider, returning it with the outcome of the charge. It doesn't use
+// the database, so it can be called without holding the lock of the database while waiting for the provider.
+func (db *appdbimpl) ChargeTopUp(userID string, topUp TopUp) TopUp {
+	// the key of the provider is the ID of the top up, not the one of the client: when a failed top up is tried again,
+	// the new top up gets a new authorization, since the one of the failed top up may have been voided
+	topUp.Status, topUp.AuthorizationID, topUp.FailureReason = db.collectPayment(payments.AuthorizationRequest{
+		IdempotencyKey: topUp.ID,
+		CustomerID:     userID,
+		Amount:         topUp.Amount,
+		Description:    "DajeTrains wallet top up",
+	})
+	return topUp
+}
+
+// Complete a charged top up, storing the outcome of the charge. If the amount has been captured, it is credited to the
+// wallet, and the new balance is used to settle the debts of the user. A top up is completed once: if it is not
+// pending anymore, it is returned as it is.
+func (db *appdbimpl) CompleteTopUp(userID string, topUp TopUp) (*TopUp, error) {
+
+	wallet := db.getWallet(userID)
+
+	topUpIdx := -1
+	for i := range wallet.TopUps {
+		if wallet.TopUps[i].ID == topUp.ID {
+			topUpIdx = i
+		}
+	}
+
+	if topUpIdx == -1 {
+		return nil, ErrTopUpNotFound
+	}
+
+	delete(db.charging, topUp.ID)
+
+	if stored := wallet.TopUps[topUpIdx]; stored.Status != PaymentPending {
+		return &stored, nil
+	}
+
+	wallet.TopUps[topUpIdx] = topUp
+
+	if topUp.Status == PaymentCaptured {
+		err := db.postJournalEntry(JournalTopUp, topUp.ID, "Wallet top up",
+			debit(AccountProviderClearing, topUp.Amount), credit(passengerAccount(userID), topUp.Amount))
+
+		if err != nil {
+			return nil, err
+		}
+
+		db.addWalletMovement(wallet, WalletTopUp, topUp.Amount, topUp.ID)
+		db.settleDebts(userID)
+	}
+
+	err := db.Write()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &topUp, nil
+}
+
+// Refund a captured top up through the payment provider, taking the amount back from the wallet. The balance must
+// still cover the whole amount: the part that has been spent on fares can't be given back. If the provider refuses the
+// refund, nothing is changed.
+func (db *appdbimpl) RefundTopUp(userID string, topUpID string) (*TopUp, error) {
+
+	wallet := db.getWallet(userID)
+
+	topUpIdx := -1
+	for i := range wallet.TopUps {
+		if wallet.TopUps[i].ID == topUpID {
+			topUpIdx = i
+		}
+	}
+
+	if topUpIdx == -1 {
+		return nil, ErrTopUpNotFound
+	}
+
+	topUp := wallet.TopUps[topUpIdx]
+
+	if topUp.Status != PaymentCaptured {
+		return nil, ErrTopUpNotRefundable
+	} else if wallet.Balance < topUp.Amount {
+		return nil, ErrInsufficientFunds
+	}
+
+	ctx, cancel := db.paymentContext()
+	defer cancel()
+
+	err := db.cfg.PaymentProvider.Refund(ctx, topUp.AuthorizationID, topUp.Amount)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.postJournalEntry(JournalTopUpRefund, topUp.ID, "Wallet top up refund",
+		debit(passengerAccount(userID), topUp.Amount), credit(AccountProviderClearing, topUp.Amount))
+
+	if err != nil {
+		return nil, err
+	}
+
+	db.addWalletMovement(wallet, WalletTopUpRefund, -topUp.Amount, topUp.ID)
+
+	topUp.Status = PaymentRefunded
+	wallet.TopUps[topUpIdx] = topUp
+
+	err = db.Write()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &topUp, nil
+}
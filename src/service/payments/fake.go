@@ -0,0 +1,182 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+const (
+	// FakeApprove makes the fake provider accept every charge
+	FakeApprove string = "approve"
+
+	// FakeDecline makes the fake provider decline every authorization
+	FakeDecline = "decline"
+
+	// FakeTimeout makes the fake provider never answer an authorization before the request deadline
+	FakeTimeout = "timeout"
+)
+
+// FakeConfig is used to configure the fake provider
+type FakeConfig struct {
+	// Mode is one of FakeApprove, FakeDecline or FakeTimeout
+	Mode string
+
+	// Delay is added to every call, to simulate a slow provider
+	Delay time.Duration
+}
+
+type fakeAuthorization struct {
+	amount   int64
+	captured int64
+	refunded int64
+	voided   bool
+}
+
+// Fake is a local payment provider that keeps its state in memory
+type Fake struct {
+	mode  string
+	delay time.Duration
+
+	mu             sync.Mutex
+	authorizations map[string]*fakeAuthorization
+	idempotency    map[string]string
+}
+
+// NewFake returns a new fake provider
+func NewFake(cfg FakeConfig) (*Fake, error) {
+	switch cfg.Mode {
+	case FakeApprove, FakeDecline, FakeTimeout:
+	default:
+		return nil, fmt.Errorf("unknown fake provider mode %q", cfg.Mode)
+	}
+
+	return &Fake{
+		mode:           cfg.Mode,
+		delay:          cfg.Delay,
+		authorizations: make(map[string]*fakeAuthorization),
+		idempotency:    make(map[string]string),
+	}, nil
+}
+
+// wait simulates the network latency, returning ErrTimeout if the context expires first
+func (f *Fake) wait(ctx context.Context) error {
+	if f.delay <= 0 {
+		return nil
+	}
+
+	select {
+	case <-time.After(f.delay):
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %v", ErrTimeout, ctx.Err())
+	}
+}
+
+func (f *Fake) Authorize(ctx context.Context, req AuthorizationRequest) (*Authorization, error) {
+	if err := f.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	switch f.mode {
+	case FakeDecline:
+		return nil, ErrDeclined
+	case FakeTimeout:
+		<-ctx.Done()
+		return nil, fmt.Errorf("%w: %v", ErrTimeout, ctx.Err())
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// same key, same authorization
+	if id, ok := f.idempotency[req.IdempotencyKey]; ok && req.IdempotencyKey != "" {
+		auth := f.authorizations[id]
+		return &Authorization{ID: id, Amount: auth.amount, Captured: auth.captured, Voided: auth.voided}, nil
+	}
+
+	id, err := uuid.NewV4()
+
+	if err != nil {
+		return nil, err
+	}
+
+	f.authorizations[id.String()] = &fakeAuthorization{amount: req.Amount}
+	if req.IdempotencyKey != "" {
+		f.idempotency[req.IdempotencyKey] = id.String()
+	}
+
+	return &Authorization{ID: id.String(), Amount: req.Amount}, nil
+}
+
+func (f *Fake) Capture(ctx context.Context, authorizationID string, amount int64) error {
+	if err := f.wait(ctx); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	auth, ok := f.authorizations[authorizationID]
+
+	if !ok {
+		return ErrUnknownAuthorization
+	}
+
+	if auth.voided || auth.captured+amount > auth.amount {
+		return ErrInvalidState
+	}
+
+	auth.captured += amount
+
+	return nil
+}
+
+func (f *Fake) Void(ctx context.Context, authorizationID string) error {
+	if err := f.wait(ctx); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	auth, ok := f.authorizations[authorizationID]
+
+	if !ok {
+		return ErrUnknownAuthorization
+	}
+
+	if auth.captured > 0 {
+		return ErrInvalidState
+	}
+
+	auth.voided = true
+
+	return nil
+}
+
+func (f *Fake) Refund(ctx context.Context, authorizationID string, amount int64) error {
+	if err := f.wait(ctx); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	auth, ok := f.authorizations[authorizationID]
+
+	if !ok {
+		return ErrUnknownAuthorization
+	}
+
+	if auth.refunded+amount > auth.captured {
+		return ErrInvalidState
+	}
+
+	auth.refunded += amount
+
+	return nil
+}
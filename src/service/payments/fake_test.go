@@ -0,0 +1,213 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestFake creates a fake provider, failing the test if the configuration is refused
+func newTestFake(t *testing.T, cfg FakeConfig) *Fake {
+	t.Helper()
+
+	fake, err := NewFake(cfg)
+	if err != nil {
+		t.Fatalf("NewFake() error = %v", err)
+	}
+	return fake
+}
+
+// authorize reserves an amount on a fake provider, failing the test if the authorization is refused
+func authorize(t *testing.T, fake *Fake, key string, amount int64) *Authorization {
+	t.Helper()
+
+	auth, err := fake.Authorize(context.Background(), AuthorizationRequest{IdempotencyKey: key, Amount: amount})
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+	return auth
+}
+
+func TestNewFakeModes(t *testing.T) {
+	for _, mode := range []string{FakeApprove, FakeDecline, FakeTimeout} {
+		if _, err := NewFake(FakeConfig{Mode: mode}); err != nil {
+			t.Errorf("NewFake(%q) error = %v", mode, err)
+		}
+	}
+
+	if _, err := NewFake(FakeConfig{Mode: "maybe"}); err == nil {
+		t.Errorf("NewFake() accepted an unknown mode")
+	}
+}
+
+func TestFakeDecline(t *testing.T) {
+	fake := newTestFake(t, FakeConfig{Mode: FakeDecline})
+
+	auth, err := fake.Authorize(context.Background(), AuthorizationRequest{IdempotencyKey: "k", Amount: 500})
+	if !errors.Is(err, ErrDeclined) {
+		t.Errorf("Authorize() error = %v, want %v", err, ErrDeclined)
+	}
+	if auth != nil {
+		t.Errorf("Authorize() = %+v, want no authorization", auth)
+	}
+}
+
+func TestFakeTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  FakeConfig
+	}{
+		{"timeout mode", FakeConfig{Mode: FakeTimeout}},
+		{"slower than the deadline", FakeConfig{Mode: FakeApprove, Delay: time.Minute}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newTestFake(t, tt.cfg)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			_, err := fake.Authorize(ctx, AuthorizationRequest{IdempotencyKey: "k", Amount: 500})
+
+			if !errors.Is(err, ErrTimeout) {
+				t.Errorf("Authorize() error = %v, want %v", err, ErrTimeout)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("Authorize() returned after %v, long after the deadline", elapsed)
+			}
+		})
+	}
+}
+
+func TestFakeDelayedCallsTimeOut(t *testing.T) {
+	fake := newTestFake(t, FakeConfig{Mode: FakeApprove, Delay: 10 * time.Millisecond})
+	auth := authorize(t, fake, "k", 500)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+
+	if err := fake.Capture(ctx, auth.ID, 500); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Capture() error = %v, want %v", err, ErrTimeout)
+	}
+	if err := fake.Void(ctx, auth.ID); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Void() error = %v, want %v", err, ErrTimeout)
+	}
+	if err := fake.Refund(ctx, auth.ID, 500); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Refund() error = %v, want %v", err, ErrTimeout)
+	}
+
+	// the calls that timed out changed nothing
+	if err := fake.Capture(context.Background(), auth.ID, 500); err != nil {
+		t.Errorf("Capture() after the timeouts error = %v", err)
+	}
+}
+
+func TestFakeIdempotentAuthorize(t *testing.T) {
+	fake := newTestFake(t, FakeConfig{Mode: FakeApprove})
+
+	first := authorize(t, fake, "k1", 500)
+	if first.ID == "" || first.Amount != 500 {
+		t.Fatalf("Authorize() = %+v, want an authorization of 500", first)
+	}
+
+	// a retry gets the same authorization, even with a different amount
+	if again := authorize(t, fake, "k1", 900); again.ID != first.ID || again.Amount != 500 {
+		t.Errorf("Authorize() retry = %+v, want %+v", again, first)
+	}
+
+	if other := authorize(t, fake, "k2", 500); other.ID == first.ID {
+		t.Errorf("Authorize() with another key returned the same authorization %s", other.ID)
+	}
+
+	// without a key, every request is a new authorization
+	if a, b := authorize(t, fake, "", 500), authorize(t, fake, "", 500); a.ID == b.ID {
+		t.Errorf("Authorize() without a key returned the same authorization %s twice", a.ID)
+	}
+
+	// the amount is reserved once: the retry doesn't allow capturing it twice
+	if err := fake.Capture(context.Background(), first.ID, 500); err != nil {
+		t.Fatalf("Capture() error = %v", err)
+	}
+	if err := fake.Capture(context.Background(), authorize(t, fake, "k1", 500).ID, 500); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("Capture() of a retried authorization error = %v, want %v", err, ErrInvalidState)
+	}
+
+	// a retry gets the authorization in its current state
+	if again := authorize(t, fake, "k1", 500); again.Captured != 500 || again.Voided {
+		t.Errorf("Authorize() retry after the capture = %+v, want 500 captured", again)
+	}
+	if err := fake.Void(context.Background(), authorize(t, fake, "k3", 500).ID); err != nil {
+		t.Fatalf("Void() error = %v", err)
+	}
+	if again := authorize(t, fake, "k3", 500); !again.Voided || again.Captured != 0 {
+		t.Errorf("Authorize() retry after the void = %+v, want it voided", again)
+	}
+}
+
+func TestFakeLifecycle(t *testing.T) {
+	type step struct {
+		op     string
+		amount int64
+		want   error
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"capture", []step{{"capture", 500, nil}}},
+		{"partial captures", []step{{"capture", 200, nil}, {"capture", 300, nil}, {"capture", 1, ErrInvalidState}}},
+		{"capture more than authorized", []step{{"capture", 501, ErrInvalidState}}},
+		{"void", []step{{"void", 0, nil}}},
+		{"capture after void", []step{{"void", 0, nil}, {"capture", 500, ErrInvalidState}}},
+		{"void after capture", []step{{"capture", 500, nil}, {"void", 0, ErrInvalidState}}},
+		{"refund without capture", []step{{"refund", 500, ErrInvalidState}}},
+		{"refund after void", []step{{"void", 0, nil}, {"refund", 1, ErrInvalidState}}},
+		{"refund", []step{{"capture", 500, nil}, {"refund", 500, nil}}},
+		{"partial refunds", []step{{"capture", 500, nil}, {"refund", 200, nil}, {"refund", 300, nil}}},
+		{"refund twice", []step{{"capture", 500, nil}, {"refund", 500, nil}, {"refund", 1, ErrInvalidState}}},
+		{"refund more than captured", []step{{"capture", 300, nil}, {"refund", 301, ErrInvalidState}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newTestFake(t, FakeConfig{Mode: FakeApprove})
+			auth := authorize(t, fake, "k", 500)
+
+			for i, s := range tt.steps {
+				var err error
+				switch s.op {
+				case "capture":
+					err = fake.Capture(context.Background(), auth.ID, s.amount)
+				case "void":
+					err = fake.Void(context.Background(), auth.ID)
+				case "refund":
+					err = fake.Refund(context.Background(), auth.ID, s.amount)
+				}
+
+				if !errors.Is(err, s.want) {
+					t.Fatalf("step %d (%s %d) error = %v, want %v", i, s.op, s.amount, err, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFakeUnknownAuthorization(t *testing.T) {
+	fake := newTestFake(t, FakeConfig{Mode: FakeApprove})
+	ctx := context.Background()
+
+	if err := fake.Capture(ctx, "unknown", 500); !errors.Is(err, ErrUnknownAuthorization) {
+		t.Errorf("Capture() error = %v, want %v", err, ErrUnknownAuthorization)
+	}
+	if err := fake.Void(ctx, "unknown"); !errors.Is(err, ErrUnknownAuthorization) {
+		t.Errorf("Void() error = %v, want %v", err, ErrUnknownAuthorization)
+	}
+	if err := fake.Refund(ctx, "unknown", 500); !errors.Is(err, ErrUnknownAuthorization) {
+		t.Errorf("Refund() error = %v, want %v", err, ErrUnknownAuthorization)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Package payments describes how DajeTrains talks to a payment provider. A charge follows the usual card lifecycle: the
+amount is first authorized (reserved on the customer payment method), then captured. An authorization that is not
+going to be captured must be voided, and a captured amount can be refunded.
+
+All amounts are expressed in euro cents.
+
+The package ships with a local fake provider (see NewFake) that can be configured to approve, decline or time out, so
+that failure paths can be exercised without a real provider.
+*/
+package payments
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrDeclined is returned when the provider refuses the charge
+	ErrDeclined = errors.New("payment declined")
+
+	// ErrTimeout is returned when the provider does not answer in time
+	ErrTimeout = errors.New("payment provider timed out")
+
+	// ErrUnknownAuthorization is returned when an operation refers to an authorization the provider does not know
+	ErrUnknownAuthorization = errors.New("unknown authorization")
+
+	// ErrInvalidState is returned when an operation is not allowed in the current state of the authorization (e.g.,
+	// refunding an authorization that has never been captured)
+	ErrInvalidState = errors.New("operation not allowed in the current authorization state")
+)
+
+// Provider is the interface implemented by payment providers
+type Provider interface {
+	// Authorize reserves the requested amount. Requests with the same idempotency key must return the same
+	// authorization, in its current state, instead of reserving the amount twice.
+	Authorize(ctx context.Context, req AuthorizationRequest) (*Authorization, error)
+
+	// Capture collects the given amount from a previous authorization
+	Capture(ctx context.Context, authorizationID string, amount int64) error
+
+	// Void releases an authorization that has not been captured
+	Void(ctx context.Context, authorizationID string) error
+
+	// Refund gives back (part of) a captured amount
+	Refund(ctx context.Context, authorizationID string, amount int64) error
+}
+
+// AuthorizationRequest contains the parameters of an authorization
+type AuthorizationRequest struct {
+	// IdempotencyKey identifies the operation: retries must use the same key
+	IdempotencyKey string
+
+	// CustomerID is the identifier of the customer being charged
+	CustomerID string
+
+	// Amount is the amount to reserve, in euro cents
+	Amount int64
+
+	// Description is a human-readable description of the charge
+	Description string
+}
+
+// Authorization is an amount reserved by the provider
+type Authorization struct {
+	// ID is the provider identifier of the authorization
+	ID string
+
+	// Amount is the reserved amount, in euro cents
+	Amount int64
+
+	// Captured is the amount captured so far, in euro cents
+	Captured int64
+
+	// Voided tells whether the authorization has been released
+	Voided bool
+}
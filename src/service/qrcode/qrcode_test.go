@@ -0,0 +1,242 @@
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+// formatInformation is the format information of each level and mask, as listed by the standard
+var formatInformation = map[Level][8]string{
+	Low:      {"111011111000100", "111001011110011", "111110110101010", "111100010011101", "110011000101111", "110001100011000", "110110001000001", "110100101110110"},
+	Medium:   {"101010000010010", "101000100100101", "101111001111100", "101101101001011", "100010111111001", "100000011001110", "100111110010111", "100101010100000"},
+	Quartile: {"011010101011111", "011000001101000", "011111100110001", "011101000000110", "010010010110100", "010000110000011", "010111011011010", "010101111101101"},
+	High:     {"001011010001001", "001001110111110", "001110011100111", "001100111010000", "000011101100010", "000001001010101", "000110100001100", "000100000111011"},
+}
+
+// readFormat reads the first copy of the format information of a code, and finds the level and the mask it encodes
+func readFormat(t *testing.T, c *Code) (Level, int) {
+	t.Helper()
+
+	bits := 0
+	set := func(i int, dark bool) {
+		if dark {
+			bits |= 1 << i
+		}
+	}
+	for i := 0; i <= 5; i++ {
+		set(i, c.Dark(8, i))
+	}
+	set(6, c.Dark(8, 7))
+	set(7, c.Dark(8, 8))
+	set(8, c.Dark(7, 8))
+	for i := 9; i < 15; i++ {
+		set(i, c.Dark(14-i, 8))
+	}
+
+	read := strconv.FormatInt(int64(bits), 2)
+	for len(read) < 15 {
+		read = "0" + read
+	}
+
+	for level, masks := range formatInformation {
+		for mask, format := range masks {
+			if format == read {
+				return level, mask
+			}
+		}
+	}
+	t.Fatalf("format information %s is not valid", read)
+	return 0, 0
+}
+
+// readCodewords removes the mask of a code and reads its codewords back, in the zig-zag order of the standard
+func readCodewords(t *testing.T, c *Code) []byte {
+	t.Helper()
+
+	level, mask := readFormat(t, c)
+
+	unmasked := newCode(c.Version)
+	unmasked.drawFunctionPatterns(level)
+	for y := range c.modules {
+		copy(unmasked.modules[y], c.modules[y])
+	}
+	unmasked.applyMask(mask)
+
+	var bits bitBuffer
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.Size; vert++ {
+			y := vert
+			if upward {
+				y = c.Size - 1 - vert
+			}
+			for x := right; x >= right-1; x-- {
+				if !unmasked.isFunction[y][x] {
+					bits = append(bits, unmasked.modules[y][x])
+				}
+			}
+		}
+	}
+	return bits[:len(bits)/8*8].bytes()
+}
+
+func TestReedSolomonRemainder(t *testing.T) {
+	// the data codewords of HELLO WORLD, at version 1 and level M
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	if got := reedSolomonRemainder(data, reedSolomonDivisor(len(want))); !bytes.Equal(got, want) {
+		t.Errorf("reedSolomonRemainder() = %v, want %v", got, want)
+	}
+}
+
+func TestFormatBits(t *testing.T) {
+	for level := Low; level <= High; level++ {
+		for mask := 0; mask < 8; mask++ {
+			c := newCode(1)
+			c.drawFormatBits(level, mask)
+
+			if gotLevel, gotMask := readFormat(t, c); gotLevel != level || gotMask != mask {
+				t.Errorf("drawFormatBits(%d, %d) encodes level %d, mask %d", level, mask, gotLevel, gotMask)
+			}
+		}
+	}
+}
+
+func TestVersionInformation(t *testing.T) {
+	tests := []struct {
+		version int
+		want    string
+	}{
+		{7, "000111110010010100"},
+		{8, "001000010110111100"},
+	}
+
+	for _, tt := range tests {
+		c := newCode(tt.version)
+		c.drawVersion()
+
+		// the bits are stored from the least significant, in the block over the bottom left finder pattern
+		got := ""
+		for i := 17; i >= 0; i-- {
+			if c.Dark(i/3, c.Size-11+i%3) {
+				got += "1"
+			} else {
+				got += "0"
+			}
+		}
+		if got != tt.want {
+			t.Errorf("version %d information = %s, want %s", tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeOutput(t *testing.T) {
+	code, err := Encode([]byte("hello"), Medium)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	if code.Version != 1 || code.Size != 21 {
+		t.Fatalf("code version %d, size %d, want version 1, size 21", code.Version, code.Size)
+	}
+	if level, _ := readFormat(t, code); level != Medium {
+		t.Errorf("code level = %d, want %d", level, Medium)
+	}
+
+	// byte mode, 5 bytes of data, the terminator and the padding
+	data := []byte{0x40, 0x56, 0x86, 0x56, 0xc6, 0xc6, 0xf0, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11, 0xec}
+	want := append(data, reedSolomonRemainder(data, reedSolomonDivisor(10))...)
+
+	if got := readCodewords(t, code); !bytes.Equal(got, want) {
+		t.Errorf("codewords = %x, want %x", got, want)
+	}
+
+	// the finder patterns are in three corners, surrounded by light separators
+	for _, corner := range [][2]int{{0, 0}, {20, 0}, {0, 20}} {
+		if !code.Dark(corner[0], corner[1]) {
+			t.Errorf("module %v is light, want the corner of a finder pattern", corner)
+		}
+	}
+	if code.Dark(7, 7) || code.Dark(13, 7) || code.Dark(7, 13) {
+		t.Errorf("the separators of the finder patterns are not light")
+	}
+	if code.Dark(-1, 0) || code.Dark(21, 0) {
+		t.Errorf("the quiet zone is not light")
+	}
+}
+
+func TestEncodeVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		length      int
+		level       Level
+		wantVersion int
+		wantErr     error
+	}{
+		{"empty", 0, Medium, 1, nil},
+		{"version 1 at L", 17, Low, 1, nil},
+		{"over version 1 at L", 18, Low, 2, nil},
+		{"version 1 at M", 14, Medium, 1, nil},
+		{"over version 1 at M", 15, Medium, 2, nil},
+		{"version 1 at Q", 11, Quartile, 1, nil},
+		{"over version 1 at Q", 12, Quartile, 2, nil},
+		{"version 1 at H", 7, High, 1, nil},
+		{"over version 1 at H", 8, High, 2, nil},
+		{"longer character count", 231, Low, 10, nil},
+		{"version 40 at L", 2953, Low, 40, nil},
+		{"too long at L", 2954, Low, 0, ErrDataTooLong},
+		{"version 40 at H", 1273, High, 40, nil},
+		{"too long at H", 1274, High, 0, ErrDataTooLong},
+		{"unknown level", 10, High + 1, 0, ErrInvalidLevel},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Encode(bytes.Repeat([]byte{'a'}, tt.length), tt.level)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Encode() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if code.Version != tt.wantVersion {
+				t.Errorf("version = %d, want %d", code.Version, tt.wantVersion)
+			}
+			if want := tt.wantVersion*4 + 17; code.Size != want {
+				t.Errorf("size = %d, want %d", code.Size, want)
+			}
+			if level, _ := readFormat(t, code); level != tt.level {
+				t.Errorf("code level = %d, want %d", level, tt.level)
+			}
+		})
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Level
+		wantErr error
+	}{
+		{"L", Low, nil},
+		{"m", Medium, nil},
+		{"Q", Quartile, nil},
+		{"H", High, nil},
+		{"X", Low, ErrInvalidLevel},
+		{"", Low, ErrInvalidLevel},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseLevel(tt.value)
+		if got != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("ParseLevel(%q) = %d, %v, want %d, %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package qrcode
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// Get the number of pixels of each module, and the offset of the code, so that the code (with its quiet zone) fills a
+// square image of the given size. The image is never smaller than one pixel per module.
+func (c *Code) layout(size int) (int, int, int) {
+	total := c.Size + 2*quietZone
+	scale := size / total
+	if scale < 1 {
+		scale = 1
+	}
+	if size < scale*total {
+		size = scale * total
+	}
+	offset := (size-scale*total)/2 + scale*quietZone
+	return size, scale, offset
+}
+
+// Image renders the code as a square black and white image, about size pixels wide (including the quiet zone)
+func (c *Code) Image(size int) image.Image {
+	size, scale, offset := c.layout(size)
+
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.modules[y][x] {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex(offset+x*scale+dx, offset+y*scale+dy, 1)
+				}
+			}
+		}
+	}
+	return img
+}
+
+// PNG renders the code as a PNG image, about size pixels wide (including the quiet zone)
+func (c *Code) PNG(size int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.Image(size)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Width returns the number of modules on each side of the code, including the quiet zone
+func (c *Code) Width() int {
+	return c.Size + 2*quietZone
+}
+
+// Path returns the SVG path data of the dark modules, each a unit square, with the origin at the top left corner of
+// the quiet zone
+func (c *Code) Path() string {
+	var buf bytes.Buffer
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				fmt.Fprintf(&buf, "M%d,%dh1v1h-1z", x+quietZone, y+quietZone)
+			}
+		}
+	}
+	return buf.String()
+}
+
+// SVG renders the code as an SVG image, size pixels wide (including the quiet zone). Each module is a unit square of
+// a single path, so the image scales without blurring.
+func (c *Code) SVG(size int) []byte {
+	total := c.Width()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" version="1.1" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n", size, size, total, total)
+	fmt.Fprintf(&buf, `<rect width="100%%" height="100%%" fill="#FFFFFF"/>`+"\n")
+	buf.WriteString(`<path fill="#000000" d="` + c.Path() + `"/>` + "\n</svg>\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,111 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// maxControlPayload is the maximum size of the payload of a control frame
+const maxControlPayload = 125
+
+// errClosed is returned when writing after the close frame has been sent
+var errClosed = errors.New("WebSocket closed")
+
+// readFrame reads a frame from the client, unmasking its payload. Frames of the clients must be masked.
+func (c *Conn) readFrame() (bool, int, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.rw, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	opcode := int(header[0] & 0x0F)
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	// no extension is negotiated, so the reserved bits must be zero
+	if header[0]&0x70 != 0 || !masked {
+		return false, 0, nil, c.fail(CloseProtocolError, ErrProtocol)
+	}
+
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.rw, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.rw, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+
+	if opcode >= OpClose && (!fin || length > maxControlPayload) {
+		return false, 0, nil, c.fail(CloseProtocolError, ErrProtocol)
+	}
+	if length > MaxMessageSize {
+		return false, 0, nil, c.fail(CloseTooLarge, ErrMessageTooLarge)
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.rw, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.rw, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// writeFrame sends a frame to the client
+func (c *Conn) writeFrame(opcode int, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	return c.writeFrameLocked(opcode, payload)
+}
+
+// writeFrameLocked sends a frame to the client. The frames of the server are not masked. The write lock must be held.
+func (c *Conn) writeFrameLocked(opcode int, payload []byte) error {
+	if c.closeSent {
+		return errClosed
+	}
+	if opcode == OpClose {
+		c.closeSent = true
+	}
+
+	header := []byte{0x80 | byte(opcode)}
+	switch length := len(payload); {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126, byte(length>>8), byte(length))
+	default:
+		var extended [8]byte
+		binary.BigEndian.PutUint64(extended[:], uint64(length))
+		header = append(append(header, 127), extended[:]...)
+	}
+
+	_, _ = c.rw.Write(header)
+	_, _ = c.rw.Write(payload)
+	return c.flush()
+}
+
+// flush sends the buffered data, giving up if the client doesn't receive it in time
+func (c *Conn) flush() error {
+	if err := c.conn.SetWriteDeadline(time.Now().Add(WriteTimeout)); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
@@ -0,0 +1,252 @@
+/*
+Package websocket is a minimal implementation of the server side of the WebSocket protocol (RFC 6455), so that live
+updates can be pushed without any external library.
+
+Upgrade performs the opening handshake and returns a Conn. Pings are answered automatically while reading; the
+caller sends its own pings (and sets a read deadline) to detect dead peers. Extensions, such as compression, and
+subprotocols are not supported. Messages are limited to MaxMessageSize bytes.
+*/
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// acceptGUID is appended to the key of the client to compute the accept key of the handshake
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// MaxMessageSize is the maximum size of a message received from a client
+const MaxMessageSize = 64 << 10
+
+// WriteTimeout is how long a client can take to receive a frame before the write fails
+const WriteTimeout = 10 * time.Second
+
+// Opcodes of the frames
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// Status codes of the close frames
+const (
+	CloseNormal        = 1000
+	CloseGoingAway     = 1001
+	CloseProtocolError = 1002
+	CloseNoStatus      = 1005
+	ClosePolicy        = 1008
+	CloseTooLarge      = 1009
+	CloseTryAgainLater = 1013
+)
+
+var (
+	// ErrNotWebSocket is returned when a request is not a valid WebSocket handshake
+	ErrNotWebSocket = errors.New("Not a WebSocket handshake")
+
+	// ErrProtocol is returned when a client breaks the protocol. The connection has been closed.
+	ErrProtocol = errors.New("WebSocket protocol error")
+
+	// ErrMessageTooLarge is returned when a client sends a message larger than MaxMessageSize. The connection has been
+	// closed.
+	ErrMessageTooLarge = errors.New("WebSocket message too large")
+)
+
+// CloseError is returned when the client closes the connection, with the status code and the reason it sent
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return "WebSocket closed by the client: " + e.Reason
+}
+
+// Conn is a WebSocket connection. Messages can be read by one goroutine while another one writes.
+type Conn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+
+	// writeMu serializes the frames, since pongs are written by the reader
+	writeMu   sync.Mutex
+	closeSent bool
+}
+
+// Upgrade performs the opening handshake of a WebSocket connection, taking over the connection of the request. The
+// write timeout of the server doesn't apply to the connection anymore. If the request is not a valid handshake,
+// ErrNotWebSocket is returned and nothing is written: the caller writes the error response.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet || !headerHas(r.Header, "Connection", "upgrade") || !headerHas(r.Header, "Upgrade", "websocket") {
+		return nil, ErrNotWebSocket
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, ErrNotWebSocket
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, ErrNotWebSocket
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("the connection can't be hijacked")
+	}
+
+	netConn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := netConn.SetDeadline(time.Time{}); err != nil {
+		_ = netConn.Close()
+		return nil, err
+	}
+
+	c := &Conn{conn: netConn, rw: rw}
+
+	_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	_, _ = rw.WriteString("Upgrade: websocket\r\n")
+	_, _ = rw.WriteString("Connection: Upgrade\r\n")
+	_, _ = rw.WriteString("Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n")
+
+	if err := c.flush(); err != nil {
+		_ = netConn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Get the accept key of the handshake, for the given key of the client
+func acceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// Check if a header has the given token in its comma-separated list, ignoring the case
+func headerHas(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadMessage reads the next message from the client, returning its opcode and its payload. Fragmented messages are
+// reassembled. Pings are answered; pings and pongs are returned too (outside of fragmented messages), so that the
+// caller can see that the client is alive. When the client closes the connection, the close frame is answered and a
+// *CloseError is returned.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	opcode := 0
+	var message []byte
+
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case OpPing, OpPong:
+			if op == OpPing {
+				if err := c.writeFrame(OpPong, payload); err != nil {
+					return 0, nil, err
+				}
+			}
+			if opcode == 0 {
+				return op, payload, nil
+			}
+			continue
+
+		case OpClose:
+			closeErr := &CloseError{Code: CloseNoStatus}
+			if len(payload) >= 2 {
+				closeErr.Code = int(payload[0])<<8 | int(payload[1])
+				closeErr.Reason = string(payload[2:])
+			}
+			code := closeErr.Code
+			if code == CloseNoStatus {
+				code = CloseNormal
+			}
+			_ = c.Close(code, "")
+			return 0, nil, closeErr
+
+		case OpText, OpBinary:
+			if opcode != 0 {
+				return 0, nil, c.fail(CloseProtocolError, ErrProtocol)
+			}
+			opcode = op
+
+		case OpContinuation:
+			if opcode == 0 {
+				return 0, nil, c.fail(CloseProtocolError, ErrProtocol)
+			}
+
+		default:
+			return 0, nil, c.fail(CloseProtocolError, ErrProtocol)
+		}
+
+		if len(message)+len(payload) > MaxMessageSize {
+			return 0, nil, c.fail(CloseTooLarge, ErrMessageTooLarge)
+		}
+		message = append(message, payload...)
+
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+// WriteMessage sends a message (or a control frame) to the client, in a single frame
+func (c *Conn) WriteMessage(opcode int, payload []byte) error {
+	return c.writeFrame(opcode, payload)
+}
+
+// Ping sends a ping to the client, which answers with a pong
+func (c *Conn) Ping(payload []byte) error {
+	return c.writeFrame(OpPing, payload)
+}
+
+// SetReadDeadline sets the time the next frame must be received by, as in net.Conn. Once it has passed, ReadMessage
+// fails.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// Close sends a close frame with the given status code and reason (unless one has already been sent), and closes the
+// connection
+func (c *Conn) Close(code int, reason string) error {
+	c.writeMu.Lock()
+	if !c.closeSent {
+		payload := append([]byte{byte(code >> 8), byte(code)}, reason...)
+		if len(payload) > 125 {
+			payload = payload[:125]
+		}
+		_ = c.writeFrameLocked(OpClose, payload)
+	}
+	c.writeMu.Unlock()
+
+	return c.conn.Close()
+}
+
+// fail closes the connection with the given status code, after a violation of the protocol by the client
+func (c *Conn) fail(code int, err error) error {
+	_ = c.Close(code, err.Error())
+	return err
+}
@@ -0,0 +1,450 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// frame is a frame sent by the server, as read by the client
+type frame struct {
+	fin     bool
+	opcode  int
+	payload []byte
+}
+
+// testPeer is the client side of a connection: the frames sent by the server are collected in frames
+type testPeer struct {
+	conn   net.Conn
+	frames chan frame
+}
+
+// newTestConn connects a Conn to a test client
+func newTestConn(t *testing.T) (*Conn, *testPeer) {
+	t.Helper()
+
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		_ = server.Close()
+		_ = client.Close()
+	})
+
+	peer := &testPeer{conn: client, frames: make(chan frame, 16)}
+	go peer.readFrames(t)
+
+	return &Conn{conn: server, rw: bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server))}, peer
+}
+
+// readFrames reads the frames sent by the server, until the connection is closed
+func (p *testPeer) readFrames(t *testing.T) {
+	defer close(p.frames)
+
+	for {
+		var header [2]byte
+		if _, err := io.ReadFull(p.conn, header[:]); err != nil {
+			return
+		}
+		if header[1]&0x80 != 0 {
+			t.Errorf("the server sent a masked frame")
+			return
+		}
+
+		length := uint64(header[1] & 0x7F)
+		switch length {
+		case 126:
+			var extended [2]byte
+			if _, err := io.ReadFull(p.conn, extended[:]); err != nil {
+				return
+			}
+			length = uint64(binary.BigEndian.Uint16(extended[:]))
+		case 127:
+			var extended [8]byte
+			if _, err := io.ReadFull(p.conn, extended[:]); err != nil {
+				return
+			}
+			length = binary.BigEndian.Uint64(extended[:])
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(p.conn, payload); err != nil {
+			return
+		}
+		p.frames <- frame{fin: header[0]&0x80 != 0, opcode: int(header[0] & 0x0F), payload: payload}
+	}
+}
+
+// send writes the given frames to the server, without waiting for them to be read
+func (p *testPeer) send(frames ...[]byte) {
+	data := bytes.Join(frames, nil)
+	go func() {
+		_, _ = p.conn.Write(data)
+	}()
+}
+
+// next gets the next frame sent by the server, failing the test if there is none
+func (p *testPeer) next(t *testing.T) frame {
+	t.Helper()
+
+	select {
+	case f, ok := <-p.frames:
+		if !ok {
+			t.Fatalf("the connection was closed, want a frame")
+		}
+		return f
+	case <-time.After(time.Second):
+		t.Fatalf("no frame received")
+		return frame{}
+	}
+}
+
+// closed checks that the server closed the connection, without sending other frames
+func (p *testPeer) closed(t *testing.T) {
+	t.Helper()
+
+	select {
+	case f, ok := <-p.frames:
+		if ok {
+			t.Fatalf("got frame %+v, want the connection closed", f)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("the connection is still open")
+	}
+}
+
+// clientFrame builds a frame as sent by a client, masked with a fixed key
+func clientFrame(fin bool, opcode int, payload []byte) []byte {
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+
+	header := []byte{first}
+	switch length := len(payload); {
+	case length < 126:
+		header = append(header, 0x80|byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 0x80|126, byte(length>>8), byte(length))
+	default:
+		var extended [8]byte
+		binary.BigEndian.PutUint64(extended[:], uint64(length))
+		header = append(append(header, 0x80|127), extended[:]...)
+	}
+
+	mask := []byte{0x37, 0xfa, 0x21, 0x3d}
+	masked := make([]byte, len(payload))
+	for i := range payload {
+		masked[i] = payload[i] ^ mask[i%4]
+	}
+	return append(append(header, mask...), masked...)
+}
+
+// closeCode gets the status code of a close frame
+func closeCode(t *testing.T, f frame) int {
+	t.Helper()
+
+	if f.opcode != OpClose || len(f.payload) < 2 {
+		t.Fatalf("frame %+v, want a close frame with a status code", f)
+	}
+	return int(binary.BigEndian.Uint16(f.payload))
+}
+
+func TestAcceptKey(t *testing.T) {
+	// the example of RFC 6455, section 1.3
+	if got, want := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Errorf("acceptKey() = %q, want %q", got, want)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = c.WriteMessage(OpText, []byte("hello"))
+		_ = c.Close(CloseNormal, "")
+	}))
+	defer server.Close()
+
+	// the handshake of RFC 6455, section 1.3
+	const (
+		upgrade = "Connection: keep-alive, Upgrade\r\nUpgrade: websocket\r\n"
+		key     = "Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+		version = "Sec-WebSocket-Version: 13\r\n"
+		refused = "HTTP/1.1 400 Bad Request\r\n"
+	)
+
+	tests := []struct {
+		name    string
+		headers string
+		want    string
+	}{
+		{
+			name:    "valid handshake",
+			headers: upgrade + version + key,
+			want: "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+				"Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n\r\n\x81\x05hello",
+		},
+		{name: "other version", headers: upgrade + "Sec-WebSocket-Version: 8\r\n" + key,
+			want: refused + "Sec-Websocket-Version: 13\r\n"},
+		{name: "invalid key", headers: upgrade + version + "Sec-WebSocket-Key: c2hvcnQ=\r\n", want: refused},
+		{name: "no upgrade", headers: version + key, want: refused},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", server.Listener.Addr().String())
+			if err != nil {
+				t.Fatalf("can't connect to the server: %v", err)
+			}
+			defer conn.Close()
+
+			_, _ = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n" + tt.headers + "\r\n"))
+
+			response := make([]byte, len(tt.want))
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := io.ReadFull(conn, response); err != nil {
+				t.Fatalf("can't read the response: %v", err)
+			}
+			if string(response) != tt.want {
+				t.Errorf("response = %q, want %q", response, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	tests := []struct {
+		name        string
+		frames      [][]byte
+		wantOpcode  int
+		wantPayload string
+		wantPong    string
+	}{
+		{
+			// the example of RFC 6455, section 5.7
+			name:        "masked text",
+			frames:      [][]byte{{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58}},
+			wantOpcode:  OpText,
+			wantPayload: "Hello",
+		},
+		{
+			name:        "fragmented text",
+			frames:      [][]byte{clientFrame(false, OpText, []byte("Hel")), clientFrame(true, OpContinuation, []byte("lo"))},
+			wantOpcode:  OpText,
+			wantPayload: "Hello",
+		},
+		{
+			name: "ping between the fragments",
+			frames: [][]byte{
+				clientFrame(false, OpBinary, []byte("Hel")),
+				clientFrame(true, OpPing, []byte("ping")),
+				clientFrame(false, OpContinuation, []byte("l")),
+				clientFrame(true, OpContinuation, []byte("o")),
+			},
+			wantOpcode:  OpBinary,
+			wantPayload: "Hello",
+			wantPong:    "ping",
+		},
+		{
+			name:        "16-bit length",
+			frames:      [][]byte{clientFrame(true, OpText, bytes.Repeat([]byte("a"), 300))},
+			wantOpcode:  OpText,
+			wantPayload: strings.Repeat("a", 300),
+		},
+		{
+			name:        "64-bit length",
+			frames:      [][]byte{clientFrame(true, OpText, bytes.Repeat([]byte("a"), 0x10000))},
+			wantOpcode:  OpText,
+			wantPayload: strings.Repeat("a", 0x10000),
+		},
+		{
+			name:        "ping",
+			frames:      [][]byte{clientFrame(true, OpPing, []byte("alive?"))},
+			wantOpcode:  OpPing,
+			wantPayload: "alive?",
+			wantPong:    "alive?",
+		},
+		{
+			name:        "pong",
+			frames:      [][]byte{clientFrame(true, OpPong, []byte("alive"))},
+			wantOpcode:  OpPong,
+			wantPayload: "alive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, peer := newTestConn(t)
+			peer.send(tt.frames...)
+
+			opcode, payload, err := c.ReadMessage()
+			if err != nil {
+				t.Fatalf("ReadMessage() error = %v", err)
+			}
+			if opcode != tt.wantOpcode || string(payload) != tt.wantPayload {
+				t.Errorf("ReadMessage() = %d, %q, want %d, %q", opcode, payload, tt.wantOpcode, tt.wantPayload)
+			}
+
+			if tt.wantPong != "" {
+				if pong := peer.next(t); pong.opcode != OpPong || !pong.fin || string(pong.payload) != tt.wantPong {
+					t.Errorf("answer = %+v, want a pong with %q", pong, tt.wantPong)
+				}
+			}
+
+			// nothing else has been sent
+			_ = c.conn.Close()
+			peer.closed(t)
+		})
+	}
+}
+
+func TestReadMessageViolations(t *testing.T) {
+	unmasked := clientFrame(true, OpText, []byte("Hello"))
+	unmasked[1] &^= 0x80
+	unmasked = append(unmasked[:2], unmasked[6:]...)
+
+	reserved := clientFrame(true, OpText, []byte("Hello"))
+	reserved[0] |= 0x40
+
+	tooLarge := clientFrame(true, OpText, nil)
+	tooLarge = append([]byte{tooLarge[0], 0x80 | 127}, append(make([]byte, 8), tooLarge[2:]...)...)
+	binary.BigEndian.PutUint64(tooLarge[2:], MaxMessageSize+1)
+
+	tests := []struct {
+		name     string
+		frames   [][]byte
+		wantErr  error
+		wantCode int
+	}{
+		{name: "unmasked frame", frames: [][]byte{unmasked}, wantErr: ErrProtocol, wantCode: CloseProtocolError},
+		{name: "reserved bits", frames: [][]byte{reserved}, wantErr: ErrProtocol, wantCode: CloseProtocolError},
+		{name: "unknown opcode", frames: [][]byte{clientFrame(true, 0x3, nil)}, wantErr: ErrProtocol,
+			wantCode: CloseProtocolError},
+		{name: "control frame over 125 bytes", frames: [][]byte{clientFrame(true, OpPing, bytes.Repeat([]byte("a"), 126))},
+			wantErr: ErrProtocol, wantCode: CloseProtocolError},
+		{name: "fragmented control frame", frames: [][]byte{clientFrame(false, OpPing, []byte("ping"))},
+			wantErr: ErrProtocol, wantCode: CloseProtocolError},
+		{name: "continuation without a message", frames: [][]byte{clientFrame(true, OpContinuation, []byte("lo"))},
+			wantErr: ErrProtocol, wantCode: CloseProtocolError},
+		{
+			name:     "new message before the end of the fragmented one",
+			frames:   [][]byte{clientFrame(false, OpText, []byte("Hel")), clientFrame(true, OpText, []byte("lo"))},
+			wantErr:  ErrProtocol,
+			wantCode: CloseProtocolError,
+		},
+		{name: "frame too large", frames: [][]byte{tooLarge}, wantErr: ErrMessageTooLarge, wantCode: CloseTooLarge},
+		{
+			name: "fragments too large",
+			frames: [][]byte{
+				clientFrame(false, OpText, bytes.Repeat([]byte("a"), MaxMessageSize/2+1)),
+				clientFrame(true, OpContinuation, bytes.Repeat([]byte("a"), MaxMessageSize/2+1)),
+			},
+			wantErr:  ErrMessageTooLarge,
+			wantCode: CloseTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, peer := newTestConn(t)
+			peer.send(tt.frames...)
+
+			if _, _, err := c.ReadMessage(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadMessage() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if code := closeCode(t, peer.next(t)); code != tt.wantCode {
+				t.Errorf("close code = %d, want %d", code, tt.wantCode)
+			}
+			peer.closed(t)
+		})
+	}
+}
+
+func TestCloseHandshake(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    []byte
+		wantCode   int
+		wantReason string
+		wantAnswer int
+	}{
+		{"with a status code", []byte("\x03\xe9going away"), CloseGoingAway, "going away", CloseGoingAway},
+		{"without a status code", nil, CloseNoStatus, "", CloseNormal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, peer := newTestConn(t)
+			peer.send(clientFrame(true, OpClose, tt.payload))
+
+			_, _, err := c.ReadMessage()
+			var closeErr *CloseError
+			if !errors.As(err, &closeErr) {
+				t.Fatalf("ReadMessage() error = %v, want a close error", err)
+			}
+			if closeErr.Code != tt.wantCode || closeErr.Reason != tt.wantReason {
+				t.Errorf("close error = %+v, want %d %q", closeErr, tt.wantCode, tt.wantReason)
+			}
+
+			// the close frame is answered with the same status code, once
+			if code := closeCode(t, peer.next(t)); code != tt.wantAnswer {
+				t.Errorf("answer code = %d, want %d", code, tt.wantAnswer)
+			}
+			peer.closed(t)
+
+			if err := c.WriteMessage(OpText, []byte("late")); !errors.Is(err, errClosed) {
+				t.Errorf("WriteMessage() after the close error = %v, want %v", err, errClosed)
+			}
+		})
+	}
+}
+
+func TestClose(t *testing.T) {
+	c, peer := newTestConn(t)
+
+	if err := c.Close(CloseGoingAway, strings.Repeat("a", 200)); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// the reason is cut, so that the frame fits in a control frame
+	f := peer.next(t)
+	if code := closeCode(t, f); code != CloseGoingAway {
+		t.Errorf("close code = %d, want %d", code, CloseGoingAway)
+	}
+	if len(f.payload) != maxControlPayload {
+		t.Errorf("close payload of %d bytes, want %d", len(f.payload), maxControlPayload)
+	}
+	peer.closed(t)
+}
+
+func TestWriteMessage(t *testing.T) {
+	c, peer := newTestConn(t)
+
+	for _, length := range []int{0, 125, 126, 0xFFFF, 0x10000} {
+		payload := bytes.Repeat([]byte("a"), length)
+		if err := c.WriteMessage(OpText, payload); err != nil {
+			t.Fatalf("WriteMessage() error = %v", err)
+		}
+		if f := peer.next(t); !f.fin || f.opcode != OpText || !bytes.Equal(f.payload, payload) {
+			t.Errorf("frame of %d bytes = %v %d, %d bytes", length, f.fin, f.opcode, len(f.payload))
+		}
+	}
+
+	if err := c.Ping([]byte("alive?")); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+	if f := peer.next(t); f.opcode != OpPing || string(f.payload) != "alive?" {
+		t.Errorf("frame = %+v, want a ping", f)
+	}
+}